@@ -0,0 +1,166 @@
+// Package azurestorage provides a pprofio Storage that uploads profiles to
+// Azure Blob Storage as gzip-encoded block blobs.
+//
+//	store, err := azurestorage.New("https://myaccount.blob.core.windows.net", "profiles", "checkout",
+//		azurestorage.WithManagedIdentity(""))
+//
+// Without an option, New authenticates with the default Azure credential
+// chain (environment, workload identity, managed identity, Azure CLI).
+// WithConnectionString, WithManagedIdentity and WithCredential select a
+// specific method instead.
+//
+// Blobs are named "<prefix>/<type>/<unix nanos>.pprof" and stored with
+// Content-Encoding gzip, so listing a prefix and type returns that type's
+// profiles in collection order.
+package azurestorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+
+	"github.com/pprofio/pprofio"
+)
+
+var (
+	contentEncoding = "gzip"
+	contentType     = "application/octet-stream"
+)
+
+// Storage uploads each profile as a block blob named
+// <prefix>/<type>/<unix nanos>.pprof in a single container.
+type Storage struct {
+	prefix string
+	client *container.Client
+}
+
+type options struct {
+	connectionString string
+	managedIdentity  bool
+	clientID         string
+	credential       azcore.TokenCredential
+	clientOptions    *container.ClientOptions
+}
+
+// Option configures how Storage authenticates to Azure.
+type Option func(*options)
+
+// WithConnectionString authenticates with a storage account connection
+// string. The connection string's blob endpoint replaces accountURL.
+func WithConnectionString(connectionString string) Option {
+	return func(o *options) {
+		o.connectionString = connectionString
+	}
+}
+
+// WithManagedIdentity authenticates with the managed identity of the host.
+// An empty clientID selects the system-assigned identity.
+func WithManagedIdentity(clientID string) Option {
+	return func(o *options) {
+		o.managedIdentity = true
+		o.clientID = clientID
+	}
+}
+
+// WithCredential authenticates with any Azure token credential.
+func WithCredential(credential azcore.TokenCredential) Option {
+	return func(o *options) {
+		o.credential = credential
+	}
+}
+
+// WithClientOptions sets the Azure SDK client options, e.g. retry policy
+// or transport.
+func WithClientOptions(clientOptions *container.ClientOptions) Option {
+	return func(o *options) {
+		o.clientOptions = clientOptions
+	}
+}
+
+// New creates a Storage for the given container. Without an auth option,
+// the default Azure credential chain (environment, workload identity,
+// managed identity, Azure CLI) is used.
+func New(accountURL, containerName, prefix string, opts ...Option) (*Storage, error) {
+	if containerName == "" {
+		return nil, errors.New("container is required")
+	}
+
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.connectionString != "" {
+		client, err := container.NewClientFromConnectionString(o.connectionString, containerName, o.clientOptions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create container client: %w", err)
+		}
+		return &Storage{prefix: prefix, client: client}, nil
+	}
+
+	if accountURL == "" {
+		return nil, errors.New("accountURL is required")
+	}
+
+	credential := o.credential
+	if credential == nil {
+		var err error
+		if o.managedIdentity {
+			var miOptions *azidentity.ManagedIdentityCredentialOptions
+			if o.clientID != "" {
+				miOptions = &azidentity.ManagedIdentityCredentialOptions{ID: azidentity.ClientID(o.clientID)}
+			}
+			credential, err = azidentity.NewManagedIdentityCredential(miOptions)
+		} else {
+			credential, err = azidentity.NewDefaultAzureCredential(nil)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Azure credential: %w", err)
+		}
+	}
+
+	containerURL := strings.TrimSuffix(accountURL, "/") + "/" + containerName
+	client, err := container.NewClient(containerURL, credential, o.clientOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create container client: %w", err)
+	}
+	return &Storage{prefix: prefix, client: client}, nil
+}
+
+// Upload stores the profile gzip-compressed and returns the blob URL
+func (s *Storage) Upload(ctx context.Context, filePath string) (pprofio.UploadResult, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return pprofio.UploadResult{}, fmt.Errorf("failed to read profile file: %w", err)
+	}
+
+	data, err = pprofio.CompressProfile(data)
+	if err != nil {
+		return pprofio.UploadResult{}, err
+	}
+
+	name := path.Join(s.prefix, string(pprofio.UploadProfileType(ctx, filePath)), fmt.Sprintf("%d.pprof", time.Now().UnixNano()))
+	blobClient := s.client.NewBlockBlobClient(name)
+
+	_, err = blobClient.UploadBuffer(ctx, data, &blockblob.UploadBufferOptions{
+		HTTPHeaders: &blob.HTTPHeaders{
+			BlobContentEncoding: &contentEncoding,
+			BlobContentType:     &contentType,
+		},
+	})
+	if err != nil {
+		return pprofio.UploadResult{}, fmt.Errorf("failed to upload blob: %w", err)
+	}
+
+	return pprofio.UploadResult{ProfileURL: blobClient.URL(), Size: int64(len(data))}, nil
+}
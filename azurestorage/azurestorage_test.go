@@ -0,0 +1,106 @@
+package azurestorage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testAccountKey is the public Azurite development key
+const testAccountKey = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+
+type blobServer struct {
+	mu      sync.Mutex
+	paths   []string
+	headers []http.Header
+	bodies  [][]byte
+}
+
+func (s *blobServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	s.mu.Lock()
+	s.paths = append(s.paths, r.URL.Path)
+	s.headers = append(s.headers, r.Header.Clone())
+	s.bodies = append(s.bodies, body)
+	s.mu.Unlock()
+
+	w.Header().Set("ETag", `"0x8D"`)
+	w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+	w.WriteHeader(http.StatusCreated)
+}
+
+func TestStorage_UploadGzipBlob(t *testing.T) {
+	server := &blobServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	connectionString := "DefaultEndpointsProtocol=http;AccountName=devstoreaccount1;AccountKey=" + testAccountKey +
+		";BlobEndpoint=" + ts.URL + "/devstoreaccount1;"
+	storage, err := New("", "profiles", "prod/api", WithConnectionString(connectionString))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	profile := []byte("cpu profile data")
+	path := filepath.Join(t.TempDir(), "cpu.pprof123")
+	if err := os.WriteFile(path, profile, 0600); err != nil {
+		t.Fatalf("Failed to write profile: %v", err)
+	}
+
+	result, err := storage.Upload(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	blobURL := result.ProfileURL
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.paths) != 1 {
+		t.Fatalf("Expected 1 request, got %d", len(server.paths))
+	}
+
+	blobPath := server.paths[0]
+	if !strings.HasPrefix(blobPath, "/devstoreaccount1/profiles/prod/api/cpu/") || !strings.HasSuffix(blobPath, ".pprof") {
+		t.Errorf("Blob path = %q, want under /devstoreaccount1/profiles/prod/api/cpu/", blobPath)
+	}
+	// The SDK escapes the slashes in blob names, which Azure accepts
+	if unescaped, _ := url.PathUnescape(blobURL); unescaped != ts.URL+blobPath {
+		t.Errorf("Upload() = %q, want %q", blobURL, ts.URL+blobPath)
+	}
+
+	headers := server.headers[0]
+	if got := headers.Get("x-ms-blob-content-encoding"); got != "gzip" {
+		t.Errorf("x-ms-blob-content-encoding = %q, want gzip", got)
+	}
+	if got := headers.Get("x-ms-blob-type"); got != "BlockBlob" {
+		t.Errorf("x-ms-blob-type = %q, want BlockBlob", got)
+	}
+
+	gr, err := gzip.NewReader(bytes.NewReader(server.bodies[0]))
+	if err != nil {
+		t.Fatalf("Uploaded blob is not gzip: %v", err)
+	}
+	got, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatalf("Failed to decompress blob: %v", err)
+	}
+	if !bytes.Equal(got, profile) {
+		t.Errorf("Decompressed blob = %q, want %q", got, profile)
+	}
+}
+
+func TestNewRequiresContainer(t *testing.T) {
+	if _, err := New("https://account.blob.core.windows.net", "", "prefix"); err == nil {
+		t.Error("New() without a container should return an error")
+	}
+}
@@ -0,0 +1,100 @@
+package pprofio
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+// captureHeapBaseline records the heap profile that later baseline deltas
+// are computed against.
+func (p *Profiler) captureHeapBaseline() error {
+	runtime.GC()
+
+	baseline, err := p.parseHeapProfile()
+	if err != nil {
+		return err
+	}
+
+	p.deltaMu.Lock()
+	defer p.deltaMu.Unlock()
+	p.heapBaseline = baseline
+	return nil
+}
+
+// parseHeapProfile returns the current heap profile as written by
+// writeHeapProfile.
+func (p *Profiler) parseHeapProfile() (*profile.Profile, error) {
+	var buf bytes.Buffer
+	if err := p.writeHeapProfile(&buf); err != nil {
+		return nil, fmt.Errorf("failed to write heap profile: %w", err)
+	}
+	prof, err := profile.Parse(&buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse heap profile: %w", err)
+	}
+	return prof, nil
+}
+
+// writeHeapBaselineDelta writes the change in the heap since the baseline
+// taken at Start, so backends can show growth since boot. It reports false
+// when no baseline exists yet, in which case the current profile becomes
+// the baseline and nothing is written.
+func (p *Profiler) writeHeapBaselineDelta(w io.Writer) (bool, error) {
+	current, err := p.parseHeapProfile()
+	if err != nil {
+		return false, err
+	}
+
+	p.deltaMu.Lock()
+	baseline := p.heapBaseline
+	if baseline == nil {
+		p.heapBaseline = current
+	}
+	p.deltaMu.Unlock()
+	if baseline == nil {
+		return false, nil
+	}
+
+	base := baseline.Copy()
+	base.Scale(-1)
+	delta, err := profile.Merge([]*profile.Profile{base, current})
+	if err != nil {
+		return false, fmt.Errorf("failed to compute heap baseline delta: %w", err)
+	}
+	delta.TimeNanos = current.TimeNanos
+	delta.DurationNanos = current.TimeNanos - baseline.TimeNanos
+
+	return true, delta.Write(w)
+}
+
+// uploadHeapBaselineDelta uploads the heap delta against the startup
+// baseline as an additional memory profile, marked in its metadata.
+func (p *Profiler) uploadHeapBaselineDelta(ctx context.Context) (CollectionResult, error) {
+	f, err := p.createTempFile(profileTypeMemory)
+	if err != nil {
+		return CollectionResult{}, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer p.releaseTempFile(f.Name(), profileTypeMemory)
+
+	ok, err := p.writeHeapBaselineDelta(f)
+	f.Close()
+	if err != nil || !ok {
+		return CollectionResult{}, err
+	}
+
+	p.deltaMu.Lock()
+	baselineTime := time.Unix(0, p.heapBaseline.TimeNanos).UTC()
+	p.deltaMu.Unlock()
+
+	ctx = withExtraMetadata(ctx, map[string]string{
+		"heap_baseline": "delta",
+		"baseline_time": baselineTime.Format(time.RFC3339),
+	})
+	return p.uploadProfile(ctx, f.Name(), string(profileTypeMemory))
+}
@@ -0,0 +1,114 @@
+package pprofio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+// inuseSpace sums the inuse_space values of a heap profile.
+func inuseSpace(t *testing.T, data []byte) int64 {
+	t.Helper()
+	prof, err := profile.Parse(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to parse heap profile: %v", err)
+	}
+	column := -1
+	for i, st := range prof.SampleType {
+		if st.Type == "inuse_space" {
+			column = i
+		}
+	}
+	if column < 0 {
+		t.Fatalf("Heap profile has no inuse_space: %v", prof.SampleType)
+	}
+	var total int64
+	for _, sample := range prof.Sample {
+		total += sample.Value[column]
+	}
+	return total
+}
+
+func TestHeapBaselineDelta(t *testing.T) {
+	var mu sync.Mutex
+	var metadata []map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m map[string]string
+		json.NewDecoder(r.Body).Decode(&m)
+		mu.Lock()
+		metadata = append(metadata, m)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	storage := &captureStorage{}
+	p, err := New(Config{
+		APIKey:            "test-key",
+		IngestURL:         server.URL,
+		SampleRate:        time.Hour,
+		Storage:           storage,
+		ServiceName:       "test-service",
+		EnableMemory:      true,
+		HeapBaselineDelta: true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	// Only the flushes below collect
+	p.Pause()
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer p.Stop()
+
+	const cycles = 4
+	const leakPerCycle = 4 << 20
+	var leaked [][]byte
+	for i := 0; i < cycles; i++ {
+		for j := 0; j < leakPerCycle/1024; j++ {
+			leaked = append(leaked, make([]byte, 1024))
+		}
+		for _, r := range p.Flush(context.Background(), ProfileMemory) {
+			if r.Err != nil {
+				t.Fatalf("Flush() error = %v", r.Err)
+			}
+		}
+	}
+	runtime.KeepAlive(leaked)
+
+	storage.mu.Lock()
+	uploads := storage.uploads
+	storage.mu.Unlock()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(uploads) != 2*cycles || len(metadata) != 2*cycles {
+		t.Fatalf("Expected %d uploads and metadata posts, got %d and %d", 2*cycles, len(uploads), len(metadata))
+	}
+
+	var previous int64
+	for i := 0; i < cycles; i++ {
+		current, delta := metadata[2*i], metadata[2*i+1]
+		if current["heap_baseline"] != "" || delta["heap_baseline"] != "delta" || delta["baseline_time"] == "" {
+			t.Fatalf("cycle %d metadata = %v, %v; want the current profile then the baseline delta", i, current, delta)
+		}
+
+		growth := inuseSpace(t, uploads[2*i+1])
+		if growth <= previous {
+			t.Errorf("cycle %d baseline delta inuse_space = %d, want growth beyond %d", i, growth, previous)
+		}
+		// Each cycle adds about leakPerCycle on top of the last
+		if step := growth - previous; step > 2*leakPerCycle {
+			t.Errorf("cycle %d grew by %d bytes, want at most %d", i, step, 2*leakPerCycle)
+		}
+		previous = growth
+	}
+}
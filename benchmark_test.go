@@ -0,0 +1,101 @@
+package pprofio
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"testing"
+	"time"
+)
+
+// discardStorage accepts uploads without keeping them
+type discardStorage struct{}
+
+func (discardStorage) Upload(ctx context.Context, filePath string) (UploadResult, error) {
+	return UploadResult{ProfileID: "bench", ProfileURL: "https://storage.pprofio.com/bench.pprof"}, nil
+}
+
+func newBenchmarkProfiler(b *testing.B, config Config) *Profiler {
+	b.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	b.Cleanup(server.Close)
+
+	config.APIKey = "test-key"
+	config.IngestURL = server.URL
+	config.ServiceName = "bench-service"
+	config.Storage = discardStorage{}
+	p, err := New(config)
+	if err != nil {
+		b.Fatalf("New() error = %v", err)
+	}
+	return p
+}
+
+func benchmarkCollect(b *testing.B, config Config, t profileType) {
+	p := newBenchmarkProfiler(b, config)
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.collectProfile(ctx, t); err != nil {
+			b.Fatalf("collectProfile() error = %v", err)
+		}
+	}
+}
+
+func BenchmarkCollectCPU(b *testing.B) {
+	benchmarkCollect(b, Config{EnableCPU: true, ProfileDuration: 10 * time.Millisecond}, profileTypeCPU)
+}
+
+func BenchmarkCollectMemory(b *testing.B) {
+	benchmarkCollect(b, Config{EnableMemory: true}, profileTypeMemory)
+}
+
+func BenchmarkCollectGoroutine(b *testing.B) {
+	benchmarkCollect(b, Config{EnableGoroutine: true}, profileTypeGoroutine)
+}
+
+func BenchmarkCollectMutex(b *testing.B) {
+	benchmarkCollect(b, Config{EnableMutex: true}, profileTypeMutex)
+}
+
+func BenchmarkUploadGzip(b *testing.B) {
+	filePath := filepath.Join(b.TempDir(), "goroutine.pprof")
+	f, err := os.Create(filePath)
+	if err != nil {
+		b.Fatalf("Create() error = %v", err)
+	}
+	// debug=1 writes an uncompressed text profile, so the gzip path is taken
+	if err := pprof.Lookup("goroutine").WriteTo(f, 1); err != nil {
+		b.Fatalf("WriteTo() error = %v", err)
+	}
+	f.Close()
+
+	storage := NewHTTPStorage("https://api.pprofio.com/upload", "test-key", "")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := storage.readAndCompressFile(filePath); err != nil {
+			b.Fatalf("readAndCompressFile() error = %v", err)
+		}
+	}
+}
+
+func BenchmarkSpan(b *testing.B) {
+	p := newBenchmarkProfiler(b, Config{EnableCustom: true})
+	ctx := WithProfiler(context.Background(), p)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, span := StartSpan(ctx, "bench", "endpoint", "/api")
+		span.End()
+	}
+}
@@ -0,0 +1,229 @@
+// Package boltstorage provides a pprofio Storage backed by an embedded bbolt
+// database, giving single-binary deployments a queryable local profile
+// history without a directory of files.
+//
+//	store, err := boltstorage.New("/var/lib/myapp/profiles.db")
+//	cfg.Storage = store
+//
+// Profiles are kept gzip-compressed in a "profiles" bucket, with an Entry
+// describing each in a "metadata" bucket, both keyed by creation time and
+// type so List returns them oldest first and Get reads one back for go tool
+// pprof.
+package boltstorage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pprofio/pprofio"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	profilesBucket = []byte("profiles")
+	metadataBucket = []byte("metadata")
+)
+
+// ErrNotFound is returned by Get when no profile is stored under the key
+var ErrNotFound = errors.New("profile not found")
+
+// Entry describes a stored profile
+type Entry struct {
+	Key        string            `json:"key"`
+	Type       string            `json:"type"`
+	Tags       map[string]string `json:"tags,omitempty"`
+	Size       int64             `json:"size"`
+	Created    time.Time         `json:"created"`
+	Compressed bool              `json:"compressed"`
+}
+
+// Storage stores profiles in a bbolt database keyed by timestamp and type.
+//
+// After each upload, profiles older than MaxAge and those beyond the newest
+// MaxProfiles are deleted. Zero values disable the respective limit.
+type Storage struct {
+	MaxAge      time.Duration
+	MaxProfiles int
+
+	db *bolt.DB
+}
+
+// New opens (creating if necessary) the database at path
+func New(path string) (*Storage, error) {
+	if path == "" {
+		return nil, errors.New("path is required")
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt database: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(profilesBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(metadataBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create buckets: %w", err)
+	}
+
+	return &Storage{db: db}, nil
+}
+
+// Close closes the underlying database
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+// Upload stores the profile compressed and returns its key
+func (s *Storage) Upload(ctx context.Context, filePath string) (pprofio.UploadResult, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return pprofio.UploadResult{}, fmt.Errorf("failed to read profile file: %w", err)
+	}
+
+	now := time.Now()
+	entry := Entry{
+		Type:    string(pprofio.UploadProfileType(ctx, filePath)),
+		Size:    int64(len(data)),
+		Created: now,
+	}
+	entry.Tags, _ = pprofio.TagsFromUploadContext(ctx)
+	entry.Key = fmt.Sprintf("%020d-%s", now.UnixNano(), entry.Type)
+
+	// Compressed records that Get must undo the compression applied here
+	entry.Compressed = !pprofio.IsGzipped(data)
+	stored, err := pprofio.CompressProfile(data)
+	if err != nil {
+		return pprofio.UploadResult{}, err
+	}
+
+	meta, err := json.Marshal(entry)
+	if err != nil {
+		return pprofio.UploadResult{}, fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(profilesBucket).Put([]byte(entry.Key), stored); err != nil {
+			return err
+		}
+		if err := tx.Bucket(metadataBucket).Put([]byte(entry.Key), meta); err != nil {
+			return err
+		}
+		return s.prune(tx, now)
+	})
+	if err != nil {
+		return pprofio.UploadResult{}, fmt.Errorf("failed to store profile: %w", err)
+	}
+
+	return pprofio.UploadResult{ProfileURL: entry.Key, Type: entry.Type, Size: entry.Size}, nil
+}
+
+// List returns the stored profiles, oldest first
+func (s *Storage) List() ([]Entry, error) {
+	var entries []Entry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(metadataBucket).ForEach(func(k, v []byte) error {
+			var entry Entry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return fmt.Errorf("failed to decode metadata for %s: %w", k, err)
+			}
+			entries = append(entries, entry)
+			return nil
+		})
+	})
+	return entries, err
+}
+
+// Get returns the profile stored under key, exactly as it was uploaded
+func (s *Storage) Get(key string) ([]byte, Entry, error) {
+	var data, meta []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data = copyBytes(tx.Bucket(profilesBucket).Get([]byte(key)))
+		meta = copyBytes(tx.Bucket(metadataBucket).Get([]byte(key)))
+		return nil
+	})
+	if err != nil {
+		return nil, Entry{}, err
+	}
+	if data == nil || meta == nil {
+		return nil, Entry{}, ErrNotFound
+	}
+
+	var entry Entry
+	if err := json.Unmarshal(meta, &entry); err != nil {
+		return nil, Entry{}, fmt.Errorf("failed to decode metadata: %w", err)
+	}
+
+	if entry.Compressed {
+		data, err = decompress(data)
+		if err != nil {
+			return nil, Entry{}, err
+		}
+	}
+
+	return data, entry, nil
+}
+
+// prune applies the retention policy within tx. Keys sort by creation time,
+// so the oldest profiles come first.
+func (s *Storage) prune(tx *bolt.Tx, now time.Time) error {
+	profiles := tx.Bucket(profilesBucket)
+	metadata := tx.Bucket(metadataBucket)
+
+	var keys [][]byte
+	if err := metadata.ForEach(func(k, _ []byte) error {
+		keys = append(keys, copyBytes(k))
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	excess := 0
+	if s.MaxProfiles > 0 && len(keys) > s.MaxProfiles {
+		excess = len(keys) - s.MaxProfiles
+	}
+
+	cutoff := fmt.Sprintf("%020d", now.Add(-s.MaxAge).UnixNano())
+	for i, k := range keys {
+		expired := s.MaxAge > 0 && string(k) < cutoff
+		if i >= excess && !expired {
+			continue
+		}
+		if err := profiles.Delete(k); err != nil {
+			return err
+		}
+		if err := metadata.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decompress(data []byte) ([]byte, error) {
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress profile: %w", err)
+	}
+	defer gr.Close()
+
+	return io.ReadAll(gr)
+}
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte(nil), b...)
+}
@@ -0,0 +1,104 @@
+package boltstorage
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeProfile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Failed to write profile: %v", err)
+	}
+	return path
+}
+
+func TestStorage_UploadListGet(t *testing.T) {
+	dir := t.TempDir()
+
+	storage, err := New(filepath.Join(dir, "profiles.db"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer storage.Close()
+
+	cpu := []byte("cpu profile data")
+	heap := []byte{0x1f, 0x8b, 0x08, 0x00, 'h', 'e', 'a', 'p'} // already gzipped
+
+	cpuResult, err := storage.Upload(context.Background(), writeProfile(t, dir, "cpu.pprof123", cpu))
+	if err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	heapResult, err := storage.Upload(context.Background(), writeProfile(t, dir, "memory.pprof456", heap))
+	if err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	cpuKey, heapKey := cpuResult.ProfileURL, heapResult.ProfileURL
+	if cpuResult.Type != "cpu" || cpuResult.Size != int64(len(cpu)) {
+		t.Errorf("Upload() = %+v, want type cpu and size %d", cpuResult, len(cpu))
+	}
+
+	entries, err := storage.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("List() returned %d entries, want 2", len(entries))
+	}
+	if entries[0].Key != cpuKey || entries[0].Type != "cpu" {
+		t.Errorf("entries[0] = %+v, want key %q type cpu", entries[0], cpuKey)
+	}
+	if entries[1].Key != heapKey || entries[1].Type != "memory" {
+		t.Errorf("entries[1] = %+v, want key %q type memory", entries[1], heapKey)
+	}
+
+	for key, want := range map[string][]byte{cpuKey: cpu, heapKey: heap} {
+		got, entry, err := storage.Get(key)
+		if err != nil {
+			t.Fatalf("Get(%q) error = %v", key, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("Get(%q) = %q, want %q", key, got, want)
+		}
+		if entry.Size != int64(len(want)) {
+			t.Errorf("Get(%q) size = %d, want %d", key, entry.Size, len(want))
+		}
+	}
+
+	if _, _, err := storage.Get("missing"); err != ErrNotFound {
+		t.Errorf("Get() of missing key error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestStorage_MaxProfiles(t *testing.T) {
+	dir := t.TempDir()
+
+	storage, err := New(filepath.Join(dir, "profiles.db"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer storage.Close()
+	storage.MaxProfiles = 2
+
+	var keys []string
+	for i := 0; i < 4; i++ {
+		key, err := storage.Upload(context.Background(), writeProfile(t, dir, "goroutine.pprof", []byte{byte(i)}))
+		if err != nil {
+			t.Fatalf("Upload() error = %v", err)
+		}
+		keys = append(keys, key.ProfileURL)
+	}
+
+	entries, err := storage.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(entries) != 2 || entries[0].Key != keys[2] || entries[1].Key != keys[3] {
+		t.Errorf("List() = %+v, want the newest two profiles", entries)
+	}
+}
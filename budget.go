@@ -0,0 +1,57 @@
+package pprofio
+
+import (
+	"sync"
+	"time"
+)
+
+// uploadBudget caps the number of collections within a sliding window.
+type uploadBudget struct {
+	mu     sync.Mutex
+	max    int
+	window time.Duration
+	times  []time.Time
+	now    func() time.Time
+}
+
+func newUploadBudget(max int, window time.Duration) *uploadBudget {
+	return &uploadBudget{max: max, window: window, now: time.Now}
+}
+
+// allow reports whether another upload fits in the current window, and
+// records it if so.
+func (b *uploadBudget) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	cutoff := now.Add(-b.window)
+
+	// Drop uploads that have slid out of the window
+	kept := b.times[:0]
+	for _, t := range b.times {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	b.times = kept
+
+	if len(b.times) >= b.max {
+		return false
+	}
+	b.times = append(b.times, now)
+	return true
+}
+
+// withinBudget reports whether a scheduled collection may run, counting a
+// skip in the profiler stats when the budget is exhausted.
+func (p *Profiler) withinBudget() bool {
+	if p.budget == nil || p.budget.allow() {
+		return true
+	}
+
+	p.statsMu.Lock()
+	p.stats.BudgetSkips++
+	p.statsMu.Unlock()
+	return false
+}
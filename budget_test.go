@@ -0,0 +1,65 @@
+package pprofio
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestUploadBudgetSlidingWindow(t *testing.T) {
+	now := time.Unix(0, 0)
+	budget := newUploadBudget(2, time.Hour)
+	budget.now = func() time.Time { return now }
+
+	if !budget.allow() || !budget.allow() {
+		t.Fatal("First two uploads should fit in the budget")
+	}
+	if budget.allow() {
+		t.Error("Third upload within the window should be rejected")
+	}
+
+	now = now.Add(time.Hour + time.Second)
+	if !budget.allow() {
+		t.Error("Upload should be allowed once earlier uploads leave the window")
+	}
+}
+
+func TestMaxUploadsPerHour(t *testing.T) {
+	metadataServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer metadataServer.Close()
+
+	storage := &captureStorage{}
+	p, err := New(Config{
+		APIKey:            "test-key",
+		IngestURL:         metadataServer.URL,
+		SampleRate:        5 * time.Millisecond,
+		Storage:           storage,
+		ServiceName:       "test-service",
+		EnableGoroutine:   true,
+		MaxUploadsPerHour: 2,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	p.Stop()
+
+	storage.mu.Lock()
+	uploads := len(storage.uploads)
+	storage.mu.Unlock()
+
+	if uploads != 2 {
+		t.Errorf("Uploaded %d profiles, want budget of 2", uploads)
+	}
+	if p.Stats().BudgetSkips == 0 {
+		t.Error("Stats().BudgetSkips should count skipped collections")
+	}
+}
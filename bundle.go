@@ -0,0 +1,131 @@
+package pprofio
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// BundlePath is the route served by Handler for support bundles
+const BundlePath = "/pprofio/bundle.zip"
+
+// bundleManifestName is the manifest file inside a support bundle
+const bundleManifestName = "metadata.json"
+
+// recentProfile is the most recently collected profile of one type, kept in
+// memory for support bundles.
+type recentProfile struct {
+	Type        ProfileType `json:"type"`
+	File        string      `json:"file"`
+	CollectedAt time.Time   `json:"collected_at"`
+	SizeBytes   int         `json:"size_bytes"`
+
+	data []byte
+}
+
+// bundleManifest describes a support bundle's contents
+type bundleManifest struct {
+	Service     string            `json:"service"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	GeneratedAt time.Time         `json:"generated_at"`
+	Profiles    []recentProfile   `json:"profiles"`
+}
+
+// retainRecent keeps the profile as the most recent of its type, replacing
+// the previous one. Nothing is kept until Handler mounts the bundle route or
+// when FlushOnCrash is unset, and traces are never kept, since they can run
+// to many megabytes and would be held in memory for the process lifetime.
+func (p *Profiler) retainRecent(ctx context.Context, profileType ProfileType, profile collectedProfile) {
+	// The heap baseline delta is an extra view of a memory profile collected
+	// in the same cycle; bundles hold the plain profile
+	if profileType == profileTypeTrace || extraMetadataFromContext(ctx)["heap_baseline"] != "" {
+		return
+	}
+
+	p.recentMu.Lock()
+	keep := p.keepRecent
+	p.recentMu.Unlock()
+	if !keep {
+		return
+	}
+
+	data, err := profile.bytes()
+	if err != nil {
+		return
+	}
+
+	p.recentMu.Lock()
+	defer p.recentMu.Unlock()
+	if p.recent == nil {
+		p.recent = make(map[ProfileType]recentProfile)
+	}
+	p.recent[profileType] = recentProfile{
+		Type:        profileType,
+		File:        string(profileType) + ".pprof",
+		CollectedAt: time.Now().UTC(),
+		SizeBytes:   len(data),
+		data:        data,
+	}
+}
+
+// recentProfiles returns the retained profiles ordered by type.
+func (p *Profiler) recentProfiles() []recentProfile {
+	p.recentMu.Lock()
+	defer p.recentMu.Unlock()
+
+	profiles := make([]recentProfile, 0, len(p.recent))
+	for _, profile := range p.recent {
+		profiles = append(profiles, profile)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Type < profiles[j].Type })
+	return profiles
+}
+
+// serveBundle streams a zip of the most recent profile per type and a
+// metadata.json manifest describing them.
+func (p *Profiler) serveBundle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	manifest := bundleManifest{
+		Service:     p.config.ServiceName,
+		Tags:        p.profileTags(),
+		GeneratedAt: time.Now().UTC(),
+		Profiles:    p.recentProfiles(),
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="pprofio-bundle-%s.zip"`,
+		manifest.GeneratedAt.Format("20060102T150405Z")))
+
+	// Headers are sent with the first write, so failures past this point
+	// can only truncate the response
+	zw := zip.NewWriter(w)
+	for _, profile := range manifest.Profiles {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: profile.File, Method: zip.Deflate, Modified: profile.CollectedAt})
+		if err != nil {
+			return
+		}
+		if _, err := fw.Write(profile.data); err != nil {
+			return
+		}
+	}
+
+	fw, err := zw.Create(bundleManifestName)
+	if err != nil {
+		return
+	}
+	enc := json.NewEncoder(fw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return
+	}
+	zw.Close()
+}
@@ -1,7 +1,10 @@
 package pprofio
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestConfigValidation(t *testing.T) {
@@ -149,3 +152,259 @@ func TestDefaultConfig(t *testing.T) {
 		t.Error("EnableCustom should be false by default")
 	}
 }
+
+func TestConfigValidation_HeapDefaultSampleType(t *testing.T) {
+	base := Config{
+		APIKey:      "test-key",
+		IngestURL:   "https://api.pprofio.com",
+		Storage:     &HTTPStorage{URL: "https://api.pprofio.com/upload", APIKey: "test-key"},
+		ServiceName: "test-service",
+	}
+
+	for _, sampleType := range []string{"alloc_objects", "alloc_space", "inuse_objects", "inuse_space"} {
+		cfg := base
+		cfg.HeapDefaultSampleType = sampleType
+		if err := cfg.validate(); err != nil {
+			t.Errorf("validate() with HeapDefaultSampleType %q error = %v", sampleType, err)
+		}
+	}
+
+	cfg := base
+	cfg.HeapDefaultSampleType = "inuse_bytes"
+	if err := cfg.validate(); err == nil {
+		t.Error("validate() with unknown HeapDefaultSampleType should return error")
+	}
+}
+
+func TestConfigValidation_Profiles(t *testing.T) {
+	cfg := Config{
+		APIKey:       "test-key",
+		IngestURL:    "https://api.pprofio.com",
+		Storage:      &HTTPStorage{URL: "https://api.pprofio.com/upload", APIKey: "test-key"},
+		ServiceName:  "test-service",
+		Profiles:     []string{"cpu", "mutex"},
+		EnableMemory: true,
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("validate() error = %v", err)
+	}
+
+	got := []bool{cfg.EnableCPU, cfg.EnableMemory, cfg.EnableAllocs, cfg.EnableGoroutine,
+		cfg.EnableMutex, cfg.EnableBlock, cfg.EnableCustom, cfg.EnableTrace}
+	want := []bool{true, false, false, false, true, false, false, false}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Enable flags = %v, want %v", got, want)
+		}
+	}
+
+	cfg.Profiles = []string{"heap"}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("validate() error = %v", err)
+	}
+	if !cfg.EnableMemory || cfg.EnableCPU {
+		t.Error(`Profiles ["heap"] should enable only memory profiling`)
+	}
+
+	cfg.Profiles = []string{"cpu", "threads"}
+	if err := cfg.validate(); err == nil {
+		t.Error("validate() with unknown profile name should return error")
+	}
+}
+
+func TestConfigValidation_FileExtensions(t *testing.T) {
+	base := Config{
+		APIKey:      "test-key",
+		IngestURL:   "https://api.pprofio.com",
+		Storage:     &HTTPStorage{URL: "https://api.pprofio.com/upload", APIKey: "test-key"},
+		ServiceName: "test-service",
+	}
+
+	tests := []struct {
+		name    string
+		exts    map[ProfileType]string
+		wantErr bool
+	}{
+		{name: "valid", exts: map[ProfileType]string{ProfileCPU: ".cpu.pb.gz", ProfileMemory: ".heap.pb.gz"}},
+		{name: "missing dot", exts: map[ProfileType]string{ProfileCPU: "pb.gz"}, wantErr: true},
+		{name: "path separator", exts: map[ProfileType]string{ProfileCPU: ".cpu/pb"}, wantErr: true},
+		{name: "only dot", exts: map[ProfileType]string{ProfileCPU: "."}, wantErr: true},
+		{name: "unknown type", exts: map[ProfileType]string{"threads": ".pb.gz"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := base
+			cfg.FileExtensions = tt.exts
+			if err := cfg.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfigValidation_ReplayDir(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "cpu-001.pprof")
+	if err := os.WriteFile(file, []byte("cpu"), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		dir     string
+		wantErr bool
+	}{
+		{name: "directory", dir: dir},
+		{name: "missing", dir: filepath.Join(dir, "missing"), wantErr: true},
+		{name: "file", dir: file, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{
+				APIKey:      "test-key",
+				IngestURL:   "https://api.pprofio.com",
+				Storage:     &HTTPStorage{URL: "https://api.pprofio.com/upload", APIKey: "test-key"},
+				ServiceName: "test-service",
+				ReplayDir:   tt.dir,
+			}
+			if err := cfg.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfigValidation_MinCPUUsage(t *testing.T) {
+	for _, usage := range []float64{-0.1, 1.5} {
+		cfg := Config{
+			APIKey:      "test-key",
+			IngestURL:   "https://api.pprofio.com",
+			Storage:     &HTTPStorage{URL: "https://api.pprofio.com/upload", APIKey: "test-key"},
+			ServiceName: "test-service",
+			MinCPUUsage: usage,
+		}
+		if err := cfg.validate(); err == nil {
+			t.Errorf("validate() with MinCPUUsage %v should return error", usage)
+		}
+	}
+}
+
+func TestConfigValidation_UploadWorkers(t *testing.T) {
+	cfg := Config{
+		APIKey:        "test-key",
+		IngestURL:     "https://api.pprofio.com",
+		Storage:       &HTTPStorage{URL: "https://api.pprofio.com/upload", APIKey: "test-key"},
+		ServiceName:   "test-service",
+		UploadWorkers: -1,
+	}
+	if err := cfg.validate(); err == nil {
+		t.Error("validate() with negative UploadWorkers should return error")
+	}
+}
+
+func TestConfigValidation_ProxyURL(t *testing.T) {
+	for _, proxy := range []string{"ftp://proxy:21", "http://", "://bad"} {
+		cfg := Config{
+			APIKey:      "test-key",
+			IngestURL:   "https://api.pprofio.com",
+			Storage:     &HTTPStorage{URL: "https://api.pprofio.com/upload", APIKey: "test-key"},
+			ServiceName: "test-service",
+			ProxyURL:    proxy,
+		}
+		if err := cfg.validate(); err == nil {
+			t.Errorf("validate() with ProxyURL %q should return error", proxy)
+		}
+	}
+}
+
+func TestConfigValidation_ProfileOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options map[ProfileType]ProfileOptions
+		wantErr bool
+	}{
+		{"goroutine rate", map[ProfileType]ProfileOptions{ProfileGoroutine: {SampleRate: 15 * time.Second}}, false},
+		{"cpu rate and duration", map[ProfileType]ProfileOptions{ProfileCPU: {SampleRate: 5 * time.Second, Duration: 2 * time.Second}}, false},
+		{"cpu duration above rate", map[ProfileType]ProfileOptions{ProfileCPU: {SampleRate: 5 * time.Second, Duration: 10 * time.Second}}, true},
+		{"cpu rate below global duration", map[ProfileType]ProfileOptions{ProfileCPU: {SampleRate: 5 * time.Second}}, true},
+		{"cpu duration above global rate", map[ProfileType]ProfileOptions{ProfileCPU: {Duration: 2 * time.Minute}}, true},
+		{"unknown type", map[ProfileType]ProfileOptions{"threads": {SampleRate: time.Second}}, true},
+		{"negative rate", map[ProfileType]ProfileOptions{ProfileMemory: {SampleRate: -time.Second}}, true},
+		{"duration on snapshot type", map[ProfileType]ProfileOptions{ProfileGoroutine: {Duration: time.Second}}, true},
+		{"trace rate below minimum", map[ProfileType]ProfileOptions{ProfileTrace: {SampleRate: time.Second}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{
+				APIKey:         "test-key",
+				IngestURL:      "https://api.pprofio.com",
+				Storage:        &HTTPStorage{URL: "https://api.pprofio.com/upload", APIKey: "test-key"},
+				ServiceName:    "test-service",
+				EnableCPU:      true,
+				ProfileOptions: tt.options,
+			}
+			if err := cfg.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfigValidation_RetentionByTrigger(t *testing.T) {
+	cfg := Config{
+		APIKey:             "test-key",
+		IngestURL:          "https://api.pprofio.com",
+		Storage:            &HTTPStorage{URL: "https://api.pprofio.com/upload", APIKey: "test-key"},
+		ServiceName:        "test-service",
+		RetentionByTrigger: map[string]string{"deploy": "30d"},
+	}
+	if err := cfg.validate(); err == nil {
+		t.Error("validate() with unknown RetentionByTrigger trigger should return error")
+	}
+}
+
+func TestConfigValidation_CPUDuration(t *testing.T) {
+	cfg := Config{
+		APIKey:          "test-key",
+		IngestURL:       "https://api.pprofio.com",
+		Storage:         &HTTPStorage{URL: "https://api.pprofio.com/upload", APIKey: "test-key"},
+		ServiceName:     "test-service",
+		SampleRate:      5 * time.Second,
+		ProfileDuration: 10 * time.Second,
+		EnableCPU:       true,
+	}
+	if err := cfg.validate(); err == nil {
+		t.Error("validate() with ProfileDuration above SampleRate should return error")
+	}
+
+	// Without CPU profiles the duration only bounds traces
+	cfg.EnableCPU = false
+	cfg.EnableGoroutine = true
+	if err := cfg.validate(); err != nil {
+		t.Errorf("validate() without CPU profiles error = %v", err)
+	}
+}
+
+func TestConfigValidation_ProfileOptionsSnapshots(t *testing.T) {
+	cfg := Config{
+		APIKey:      "test-key",
+		IngestURL:   "https://api.pprofio.com",
+		Storage:     &HTTPStorage{URL: "https://api.pprofio.com/upload", APIKey: "test-key"},
+		ServiceName: "test-service",
+		Snapshots:   true,
+		ProfileOptions: map[ProfileType]ProfileOptions{
+			ProfileGoroutine: {SampleRate: 15 * time.Second},
+		},
+	}
+	if err := cfg.validate(); err == nil {
+		t.Error("validate() with a ProfileOptions SampleRate and Snapshots should return error")
+	}
+
+	cfg.ProfileOptions = map[ProfileType]ProfileOptions{ProfileCPU: {Duration: 5 * time.Second}}
+	if err := cfg.validate(); err != nil {
+		t.Errorf("validate() with a ProfileOptions Duration and Snapshots error = %v", err)
+	}
+}
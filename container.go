@@ -0,0 +1,41 @@
+package pprofio
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"runtime"
+)
+
+// cgroupPath is replaceable in tests
+var cgroupPath = "/proc/self/cgroup"
+
+// containerIDPattern matches the 64 hex character IDs used by Docker,
+// containerd and CRI-O in cgroup paths
+var containerIDPattern = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// detectContainerID returns the ID of the container the process runs in, or
+// an empty string when it is not containerized or not running on Linux.
+func detectContainerID() string {
+	if runtime.GOOS != "linux" {
+		return ""
+	}
+	return readContainerID(cgroupPath)
+}
+
+// readContainerID parses a /proc/<pid>/cgroup file for a container ID.
+func readContainerID(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if id := containerIDPattern.FindString(scanner.Text()); id != "" {
+			return id
+		}
+	}
+	return ""
+}
@@ -0,0 +1,91 @@
+package pprofio
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+const testContainerID = "3f4c0d6a9b2e8f1c7d5a4b3e2f1a0c9d8e7f6a5b4c3d2e1f0a9b8c7d6e5f4a3b"
+
+func writeCgroupFile(t *testing.T, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "cgroup")
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatalf("Failed to write cgroup file: %v", err)
+	}
+	return path
+}
+
+func TestReadContainerID(t *testing.T) {
+	testCases := []struct {
+		name     string
+		contents string
+		want     string
+	}{
+		{
+			name:     "cgroup v1 docker",
+			contents: "12:memory:/docker/" + testContainerID + "\n11:cpu:/docker/" + testContainerID + "\n",
+			want:     testContainerID,
+		},
+		{
+			name:     "cgroup v2 systemd scope",
+			contents: "0::/system.slice/docker-" + testContainerID + ".scope\n",
+			want:     testContainerID,
+		},
+		{
+			name:     "kubernetes containerd",
+			contents: "0::/kubepods/burstable/pod1234/cri-containerd-" + testContainerID + ".scope\n",
+			want:     testContainerID,
+		},
+		{
+			name:     "not containerized",
+			contents: "0::/user.slice/user-1000.slice/session-2.scope\n",
+			want:     "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := readContainerID(writeCgroupFile(t, tc.contents)); got != tc.want {
+				t.Errorf("readContainerID() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	if got := readContainerID(filepath.Join(t.TempDir(), "missing")); got != "" {
+		t.Errorf("readContainerID() of missing file = %q, want empty", got)
+	}
+}
+
+func TestContainerMetadataTag(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("container detection only runs on Linux")
+	}
+
+	original := cgroupPath
+	defer func() { cgroupPath = original }()
+	cgroupPath = writeCgroupFile(t, "0::/system.slice/docker-"+testContainerID+".scope\n")
+
+	p, err := newProfiler(Config{
+		APIKey:                   "test-key",
+		IngestURL:                "https://api.pprofio.com",
+		Storage:                  &captureStorage{},
+		ServiceName:              "test-service",
+		Tags:                     map[string]string{"env": "test"},
+		IncludeContainerMetadata: true,
+	})
+	if err != nil {
+		t.Fatalf("newProfiler() error = %v", err)
+	}
+
+	tags := p.profileTags()
+	if tags["container_id"] != testContainerID {
+		t.Errorf("container_id tag = %q, want %q", tags["container_id"], testContainerID)
+	}
+	if tags["env"] != "test" {
+		t.Errorf("env tag = %q, want %q", tags["env"], "test")
+	}
+}
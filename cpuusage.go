@@ -0,0 +1,71 @@
+package pprofio
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// cpuUsageGate decides whether a scheduled CPU profile is worth collecting
+// from the process CPU time used since the previous check.
+type cpuUsageGate struct {
+	mu        sync.Mutex
+	threshold float64
+	numCPU    int
+	lastCPU   time.Duration
+	lastWall  time.Time
+
+	// cpuTime and now are replaceable in tests
+	cpuTime func() (time.Duration, bool)
+	now     func() time.Time
+}
+
+func newCPUUsageGate(threshold float64) *cpuUsageGate {
+	g := &cpuUsageGate{
+		threshold: threshold,
+		numCPU:    runtime.NumCPU(),
+		cpuTime:   processCPUTime,
+		now:       time.Now,
+	}
+	g.lastCPU, _ = g.cpuTime()
+	g.lastWall = g.now()
+	return g
+}
+
+// busy reports whether the process used at least the threshold fraction of
+// all CPUs since the previous call, and starts a new measurement window.
+func (g *cpuUsageGate) busy() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	cpu, ok := g.cpuTime()
+	if !ok {
+		return true
+	}
+	now := g.now()
+
+	usedCPU := cpu - g.lastCPU
+	elapsed := now.Sub(g.lastWall)
+	g.lastCPU, g.lastWall = cpu, now
+	if elapsed <= 0 {
+		return true
+	}
+
+	usage := float64(usedCPU) / (float64(elapsed) * float64(g.numCPU))
+	return usage >= g.threshold
+}
+
+// cpuBusyEnough reports whether a scheduled collection of the given type may
+// run under MinCPUUsage, counting a skip in the profiler stats when the
+// process is too idle for a useful CPU profile.
+func (p *Profiler) cpuBusyEnough(profileType profileType) bool {
+	if profileType != profileTypeCPU || p.cpuUsage == nil || p.cpuUsage.busy() {
+		return true
+	}
+
+	p.statsMu.Lock()
+	p.stats.IdleCPUSkips++
+	p.statsMu.Unlock()
+	p.config.Logger.Debugf("Skipped cpu profile: CPU usage below %v", p.config.MinCPUUsage)
+	return false
+}
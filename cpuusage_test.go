@@ -0,0 +1,104 @@
+package pprofio
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeCPUClock drives a cpuUsageGate with scripted CPU and wall time
+type fakeCPUClock struct {
+	cpu  time.Duration
+	wall time.Time
+}
+
+func (c *fakeCPUClock) install(g *cpuUsageGate) {
+	g.cpuTime = func() (time.Duration, bool) { return c.cpu, true }
+	g.now = func() time.Time { return c.wall }
+	g.lastCPU, g.lastWall = c.cpu, c.wall
+}
+
+// advance moves wall time forward by elapsed while the process uses usage
+// of all numCPU CPUs
+func (c *fakeCPUClock) advance(elapsed time.Duration, usage float64, numCPU int) {
+	c.wall = c.wall.Add(elapsed)
+	c.cpu += time.Duration(float64(elapsed) * usage * float64(numCPU))
+}
+
+func TestCPUUsageGate(t *testing.T) {
+	g := newCPUUsageGate(0.5)
+	g.numCPU = 4
+	clock := &fakeCPUClock{wall: time.Unix(0, 0)}
+	clock.install(g)
+
+	clock.advance(time.Minute, 0.1, 4)
+	if g.busy() {
+		t.Error("busy() at 10% usage should be false with a 50% threshold")
+	}
+
+	clock.advance(time.Minute, 0.75, 4)
+	if !g.busy() {
+		t.Error("busy() at 75% usage should be true with a 50% threshold")
+	}
+
+	// Each call measures only the window since the previous one
+	clock.advance(time.Minute, 0.2, 4)
+	if g.busy() {
+		t.Error("busy() should not carry over usage from an earlier window")
+	}
+
+	g.cpuTime = func() (time.Duration, bool) { return 0, false }
+	if !g.busy() {
+		t.Error("busy() should not skip when CPU time is unavailable")
+	}
+}
+
+func TestMinCPUUsage(t *testing.T) {
+	metadataServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer metadataServer.Close()
+
+	storage := &captureStorage{}
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       metadataServer.URL,
+		Storage:         storage,
+		ServiceName:     "test-service",
+		EnableCPU:       true,
+		ProfileDuration: 10 * time.Millisecond,
+		MinCPUUsage:     0.5,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	numCPU := p.cpuUsage.numCPU
+	clock := &fakeCPUClock{wall: time.Unix(0, 0)}
+	clock.install(p.cpuUsage)
+	ctx := context.Background()
+
+	clock.advance(time.Minute, 0.1, numCPU)
+	p.collectScheduled(ctx, profileTypeCPU)
+	if n := storage.count(); n != 0 {
+		t.Fatalf("Uploaded %d CPU profiles while idle, want 0", n)
+	}
+	if skips := p.Stats().IdleCPUSkips; skips != 1 {
+		t.Errorf("Stats().IdleCPUSkips = %d, want 1", skips)
+	}
+
+	clock.advance(time.Minute, 0.9, numCPU)
+	p.collectScheduled(ctx, profileTypeCPU)
+	if n := storage.count(); n != 1 {
+		t.Errorf("Uploaded %d CPU profiles while busy, want 1", n)
+	}
+
+	// Other profile types are never gated
+	clock.advance(time.Minute, 0, numCPU)
+	p.collectScheduled(ctx, profileTypeGoroutine)
+	if n := storage.count(); n != 2 {
+		t.Errorf("Uploaded %d profiles after a goroutine collection, want 2", n)
+	}
+}
@@ -0,0 +1,71 @@
+package pprofio
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+)
+
+// notifyCrash returns a channel receiving the crash signals FlushOnCrash
+// handles. It is registered before the watcher starts so a crash right after
+// Start is not missed.
+func notifyCrash() chan os.Signal {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGSEGV, syscall.SIGABRT)
+	return signals
+}
+
+// watchCrashSignals writes the most recent profiles to FallbackDir when the
+// process receives SIGSEGV or SIGABRT, then re-raises the signal with default
+// handling restored so the process dies as it would have without the
+// profiler. Faults raised by the Go runtime itself, such as a nil pointer
+// dereference, are not delivered here; this is best effort for signals sent
+// by cgo code or other processes.
+func (p *Profiler) watchCrashSignals(ctx context.Context, signals chan os.Signal) {
+	defer p.wg.Done()
+	defer signal.Stop(signals)
+
+	select {
+	case sig := <-signals:
+		if _, err := p.flushOnCrash(); err != nil {
+			p.config.Logger.Errorf("Error writing crash profiles: %v", err)
+		}
+
+		signal.Stop(signals)
+		if proc, err := os.FindProcess(os.Getpid()); err == nil {
+			proc.Signal(sig)
+		}
+	case <-p.stopCh:
+	case <-ctx.Done():
+	}
+}
+
+// flushOnCrash writes the profiles retained for support bundles to
+// FallbackDir and returns the paths written. Nothing is collected: the
+// profiles are already in memory, so the dying process only has to write
+// them out.
+func (p *Profiler) flushOnCrash() ([]string, error) {
+	profiles := p.recentProfiles()
+	paths := make([]string, 0, len(profiles))
+
+	prefix := "crash_"
+	if p.config.ServiceName != "" {
+		prefix = sanitizeFileName(p.config.ServiceName) + "_" + prefix
+	}
+
+	var firstErr error
+	for _, profile := range profiles {
+		path := filepath.Join(p.config.FallbackDir, prefix+profile.File)
+		if err := os.WriteFile(path, profile.data, 0644); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to write %s profile: %w", profile.Type, err)
+			}
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return paths, firstErr
+}
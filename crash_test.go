@@ -0,0 +1,69 @@
+package pprofio
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFlushOnCrashWritesRecentProfiles(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       server.URL,
+		Storage:         &captureStorage{},
+		ServiceName:     "test-service",
+		EnableGoroutine: true,
+		FlushOnCrash:    true,
+		FallbackDir:     dir,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if results := p.Flush(context.Background(), ProfileGoroutine); results[0].Err != nil {
+		t.Fatalf("Flush() error = %v", results[0].Err)
+	}
+
+	paths, err := p.flushOnCrash()
+	if err != nil {
+		t.Fatalf("flushOnCrash() error = %v", err)
+	}
+
+	want := filepath.Join(dir, "test-service_crash_goroutine.pprof")
+	if len(paths) != 1 || paths[0] != want {
+		t.Fatalf("flushOnCrash() = %v, want [%s]", paths, want)
+	}
+	data, err := os.ReadFile(want)
+	if err != nil {
+		t.Fatalf("failed to read crash profile: %v", err)
+	}
+	if len(data) == 0 {
+		t.Error("crash profile is empty")
+	}
+}
+
+func TestFallbackDirDefault(t *testing.T) {
+	p, err := New(Config{
+		APIKey:       "test-key",
+		IngestURL:    "https://api.pprofio.com",
+		Storage:      &captureStorage{},
+		ServiceName:  "test-service",
+		FlushOnCrash: true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if want := filepath.Join(os.TempDir(), "pprofio-crash"); p.config.FallbackDir != want {
+		t.Errorf("FallbackDir = %q, want %q", p.config.FallbackDir, want)
+	}
+}
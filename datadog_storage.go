@@ -0,0 +1,222 @@
+package pprofio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultDatadogSite is the Datadog site used when DatadogStorage.Site is empty
+const DefaultDatadogSite = "datadoghq.com"
+
+// datadogAttachments names the multipart file field for each profile type,
+// as the Go tracer's profiler does
+var datadogAttachments = map[ProfileType]string{
+	ProfileCPU:       "cpu.pprof",
+	ProfileMemory:    "heap.pprof",
+	ProfileAllocs:    "heap.pprof",
+	ProfileGoroutine: "goroutines.pprof",
+	ProfileMutex:     "mutex.pprof",
+	ProfileBlock:     "block.pprof",
+}
+
+// datadogEvent is the JSON "event" part describing the attached profile
+type datadogEvent struct {
+	Attachments []string `json:"attachments"`
+	Tags        string   `json:"tags_profiler"`
+	Start       string   `json:"start"`
+	End         string   `json:"end"`
+	Family      string   `json:"family"`
+	Version     string   `json:"version"`
+}
+
+// DatadogStorage uploads profiles to Datadog's profiling intake without an
+// agent. Each upload is a multipart request with a JSON "event" part naming
+// the profile's time window and tags, and the profile itself attached under
+// the file name Datadog expects for its type (e.g. "cpu.pprof"). The service
+// name, Env and the profile's tags become Datadog tags.
+type DatadogStorage struct {
+	APIKey string
+
+	// Site selects the Datadog site, e.g. "datadoghq.eu". Defaults to
+	// DefaultDatadogSite.
+	Site string
+
+	// URL overrides the intake URL derived from Site, e.g. to send through
+	// a local agent or proxy
+	URL string
+
+	// Env is sent as the env tag
+	Env string
+
+	Client *http.Client
+}
+
+// NewDatadogStorage creates a storage uploading to the intake of the given
+// Datadog site, or DefaultDatadogSite when site is empty
+func NewDatadogStorage(apiKey, site, env string) *DatadogStorage {
+	return &DatadogStorage{
+		APIKey: apiKey,
+		Site:   site,
+		Env:    env,
+		Client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// intakeURL returns the URL profiles are posted to
+func (s *DatadogStorage) intakeURL() string {
+	if s.URL != "" {
+		return s.URL
+	}
+	site := s.Site
+	if site == "" {
+		site = DefaultDatadogSite
+	}
+	return "https://intake.profile." + site + "/api/v2/profile"
+}
+
+// Upload posts the profile to the intake and returns its response
+func (s *DatadogStorage) Upload(ctx context.Context, filePath string) (UploadResult, error) {
+	if s.APIKey == "" {
+		return UploadResult{}, errors.New("APIKey is required")
+	}
+	intakeURL := s.intakeURL()
+	parsedURL, err := url.Parse(intakeURL)
+	if err != nil {
+		return UploadResult{}, fmt.Errorf("invalid URL: %w", err)
+	}
+	if parsedURL.Scheme != "https" && !isLoopback(parsedURL) {
+		return UploadResult{}, errors.New("HTTPS is required for secure uploads")
+	}
+
+	t, ok := ProfileTypeFromUploadContext(ctx)
+	if !ok {
+		t = ProfileType(profileTypeFromPath(filePath))
+	}
+	attachment, ok := datadogAttachments[t]
+	if !ok {
+		return UploadResult{}, fmt.Errorf("%s profiles are not supported by Datadog", t)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return UploadResult{}, fmt.Errorf("failed to read profile file: %w", err)
+	}
+
+	start, end := profileWindow(data)
+	event := datadogEvent{
+		Attachments: []string{attachment},
+		Tags:        s.tags(ctx),
+		Start:       start.UTC().Format(time.RFC3339),
+		End:         end.UTC().Format(time.RFC3339),
+		Family:      "go",
+		Version:     "4",
+	}
+
+	body, contentType, err := datadogBody(event, attachment, data)
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, intakeURL, body)
+	if err != nil {
+		return UploadResult{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("DD-API-KEY", s.APIKey)
+	req.Header.Set("DD-EVP-ORIGIN", "pprofio")
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return UploadResult{}, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return UploadResult{}, responseError("unexpected status code", resp)
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return UploadResult{}, fmt.Errorf("failed to read response: %w", err)
+	}
+	result := decodeUploadResponse(string(respBody))
+	result.Type = string(t)
+	result.Size = int64(len(data))
+	return result, nil
+}
+
+// tags returns the comma-separated Datadog tags for a profile: service, env,
+// language and the profile's tags in key order.
+func (s *DatadogStorage) tags(ctx context.Context) string {
+	tags := []string{"language:go"}
+	if service, ok := ServiceNameFromUploadContext(ctx); ok && service != "" {
+		tags = append(tags, "service:"+service)
+	}
+	if s.Env != "" {
+		tags = append(tags, "env:"+s.Env)
+	}
+
+	profileTags, _ := TagsFromUploadContext(ctx)
+	keys := make([]string, 0, len(profileTags))
+	for k := range profileTags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		tags = append(tags, datadogTag(k)+":"+datadogTag(profileTags[k]))
+	}
+	return strings.Join(tags, ",")
+}
+
+// datadogTag replaces the comma that separates tags
+func datadogTag(s string) string {
+	return strings.ReplaceAll(s, ",", "_")
+}
+
+// datadogBody builds the multipart body with the event and profile parts
+func datadogBody(event datadogEvent, attachment string, data []byte) (io.Reader, string, error) {
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", `form-data; name="event"; filename="event.json"`)
+	header.Set("Content-Type", "application/json")
+	part, err := form.CreatePart(header)
+	if err == nil {
+		_, err = part.Write(eventJSON)
+	}
+	if err == nil {
+		part, err = form.CreateFormFile(attachment, attachment)
+	}
+	if err == nil {
+		_, err = part.Write(data)
+	}
+	if err == nil {
+		err = form.Close()
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create multipart body: %w", err)
+	}
+	return &body, form.FormDataContentType(), nil
+}
@@ -0,0 +1,110 @@
+package pprofio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDatadogStorageUpload(t *testing.T) {
+	var apiKey string
+	var event datadogEvent
+	var eventType string
+	var attached []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiKey = r.Header.Get("DD-API-KEY")
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		eventFile, eventHeader, err := r.FormFile("event")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		eventType = eventHeader.Header.Get("Content-Type")
+		json.NewDecoder(eventFile).Decode(&event)
+
+		profileFile, _, err := r.FormFile("cpu.pprof")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		attached, _ = io.ReadAll(profileFile)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	start := time.Unix(1700000000, 0)
+	path := writeTestProfile(t, start, 10*time.Second)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+
+	storage := NewDatadogStorage("dd-key", "", "prod")
+	storage.URL = server.URL
+	ctx := withUploadContext(context.Background(), "checkout", map[string]string{"version": "1.2.3"}, ProfileCPU)
+
+	result, err := storage.Upload(ctx, path)
+	if err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	if result.Type != "cpu" || result.Size != int64(len(data)) {
+		t.Errorf("Upload() = %+v", result)
+	}
+
+	if apiKey != "dd-key" {
+		t.Errorf("DD-API-KEY = %q, want %q", apiKey, "dd-key")
+	}
+	if eventType != "application/json" {
+		t.Errorf("event part Content-Type = %q, want application/json", eventType)
+	}
+	want := datadogEvent{
+		Attachments: []string{"cpu.pprof"},
+		Tags:        "language:go,service:checkout,env:prod,version:1.2.3",
+		Start:       "2023-11-14T22:13:20Z",
+		End:         "2023-11-14T22:13:30Z",
+		Family:      "go",
+		Version:     "4",
+	}
+	if got, _ := json.Marshal(event); !bytes.Equal(got, mustMarshal(t, want)) {
+		t.Errorf("event = %s, want %s", got, mustMarshal(t, want))
+	}
+	if !bytes.Equal(attached, data) {
+		t.Error("Attached profile does not match the profile file")
+	}
+}
+
+func TestDatadogStorageIntakeURL(t *testing.T) {
+	if got := NewDatadogStorage("key", "", "").intakeURL(); got != "https://intake.profile.datadoghq.com/api/v2/profile" {
+		t.Errorf("intakeURL() = %q", got)
+	}
+	if got := NewDatadogStorage("key", "datadoghq.eu", "").intakeURL(); got != "https://intake.profile.datadoghq.eu/api/v2/profile" {
+		t.Errorf("intakeURL() for datadoghq.eu = %q", got)
+	}
+}
+
+func TestDatadogStorageRejectsUnsupportedTypes(t *testing.T) {
+	path := writeTestProfile(t, time.Now(), 0)
+	ctx := withUploadContext(context.Background(), "checkout", nil, ProfileCustom)
+	if _, err := NewDatadogStorage("key", "", "").Upload(ctx, path); err == nil {
+		t.Error("Upload() of a custom profile should fail")
+	}
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	return data
+}
@@ -0,0 +1,119 @@
+package pprofio
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"runtime/pprof"
+
+	"github.com/google/pprof/profile"
+)
+
+type deltaProfileKey struct{}
+
+// withDeltaProfile records whether the cumulative profile collected under
+// ctx was written as a delta, so its metadata reports that collection's
+// flag even when uploaded later from the upload queue.
+func withDeltaProfile(ctx context.Context, delta bool) context.Context {
+	return context.WithValue(ctx, deltaProfileKey{}, delta)
+}
+
+// deltaProfileFromContext returns the flag set by withDeltaProfile, and
+// whether one was set.
+func deltaProfileFromContext(ctx context.Context) (delta bool, ok bool) {
+	delta, ok = ctx.Value(deltaProfileKey{}).(bool)
+	return delta, ok
+}
+
+// collectCumulative collects a mutex or block profile written by write,
+// marking the upload context with whether it is a delta.
+func (p *Profiler) collectCumulative(ctx context.Context, profileType profileType, write func(io.Writer) (bool, error)) (CollectionResult, error) {
+	var delta bool
+	profile, release, err := p.writeCollected(profileType, func(w io.Writer) error {
+		var err error
+		delta, err = write(w)
+		return err
+	})
+	if err != nil {
+		return CollectionResult{}, err
+	}
+	defer release()
+
+	if p.config.DeltaProfiles {
+		ctx = withDeltaProfile(ctx, delta)
+	}
+	return p.uploadCollected(ctx, profile, profileType)
+}
+
+// writeCumulativeProfile writes the named cumulative profile (mutex or block)
+// to w and reports whether it wrote a delta. With DeltaProfiles enabled, it
+// writes only the change since the previous collection of that type, unless
+// no base exists or a full profile was requested.
+func (p *Profiler) writeCumulativeProfile(w io.Writer, profileType profileType) (bool, error) {
+	if !p.config.DeltaProfiles {
+		return false, pprof.Lookup(string(profileType)).WriteTo(w, 0)
+	}
+
+	var buf bytes.Buffer
+	if err := pprof.Lookup(string(profileType)).WriteTo(&buf, 0); err != nil {
+		return false, err
+	}
+
+	current, err := profile.Parse(&buf)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse %s profile: %w", profileType, err)
+	}
+
+	p.deltaMu.Lock()
+	base := p.deltaBase[profileType]
+	if p.deltaNeedFull[profileType] {
+		base = nil
+		delete(p.deltaNeedFull, profileType)
+	}
+	p.deltaBase[profileType] = current.Copy()
+	p.deltaMu.Unlock()
+
+	if base == nil {
+		return false, current.Write(w)
+	}
+
+	// Subtract the base the same way net/http/pprof computes delta profiles
+	base.Scale(-1)
+	delta, err := profile.Merge([]*profile.Profile{base, current})
+	if err != nil {
+		return false, fmt.Errorf("failed to compute %s delta: %w", profileType, err)
+	}
+	delta.TimeNanos = current.TimeNanos
+	delta.DurationNanos = current.TimeNanos - base.TimeNanos
+
+	return true, delta.Write(w)
+}
+
+// requestFullProfile makes the next collection of profileType a full profile,
+// used when the server reports it has lost the delta base.
+func (p *Profiler) requestFullProfile(profileType profileType) {
+	p.deltaMu.Lock()
+	defer p.deltaMu.Unlock()
+
+	p.deltaNeedFull[profileType] = true
+}
+
+// deltaLost requests a full profile when the cumulative profile collected
+// under ctx never reached the server, since the next delta would otherwise be
+// taken against a base the server does not have and that interval's samples
+// would be lost.
+func (p *Profiler) deltaLost(ctx context.Context, profileType profileType) {
+	if _, ok := deltaProfileFromContext(ctx); ok {
+		p.requestFullProfile(profileType)
+	}
+}
+
+// deltaMetadata reports whether the profile collected under ctx is a delta.
+func (p *Profiler) deltaMetadata(ctx context.Context) map[string]string {
+	delta, ok := deltaProfileFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	return map[string]string{"delta": fmt.Sprintf("%t", delta)}
+}
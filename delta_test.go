@@ -0,0 +1,177 @@
+package pprofio
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// needFullStorage asks for a full profile in response to one chosen upload.
+type needFullStorage struct {
+	mu         sync.Mutex
+	uploads    int
+	needFullOn int
+}
+
+func (s *needFullStorage) Upload(ctx context.Context, filePath string) (UploadResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.uploads++
+	needFull := s.uploads == s.needFullOn
+	return UploadResult{ProfileURL: fmt.Sprintf("https://storage.pprofio.com/%d.pprof", s.uploads), NeedFull: needFull}, nil
+}
+
+func TestDeltaProfilesNeedFull(t *testing.T) {
+	var mu sync.Mutex
+	var deltas []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var metadata map[string]string
+		json.NewDecoder(r.Body).Decode(&metadata)
+
+		mu.Lock()
+		deltas = append(deltas, metadata["delta"])
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	p, err := newProfiler(Config{
+		APIKey:        "test-key",
+		IngestURL:     server.URL,
+		Storage:       &needFullStorage{needFullOn: 2},
+		ServiceName:   "test-service",
+		EnableMutex:   true,
+		DeltaProfiles: true,
+	})
+	if err != nil {
+		t.Fatalf("newProfiler() error = %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		if _, err := p.collectMutex(context.Background()); err != nil {
+			t.Fatalf("collectMutex() error = %v", err)
+		}
+	}
+
+	// First upload has no base, the second is a delta the server rejects,
+	// the third is the requested full profile and the fourth a delta again
+	want := []string{"false", "true", "false", "true"}
+	if len(deltas) != len(want) {
+		t.Fatalf("Received %d metadata payloads, want %d", len(deltas), len(want))
+	}
+	for i := range want {
+		if deltas[i] != want[i] {
+			t.Errorf("upload %d delta = %q, want %q", i+1, deltas[i], want[i])
+		}
+	}
+}
+
+// failingStorage fails one chosen upload.
+type failingStorage struct {
+	mu      sync.Mutex
+	uploads int
+	failOn  int
+}
+
+func (s *failingStorage) Upload(ctx context.Context, filePath string) (UploadResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.uploads++
+	if s.uploads == s.failOn {
+		return UploadResult{}, errors.New("ingest unavailable")
+	}
+	return UploadResult{ProfileURL: fmt.Sprintf("https://storage.pprofio.com/%d.pprof", s.uploads)}, nil
+}
+
+// deltaMetadataServer records the delta flag of each metadata payload.
+func deltaMetadataServer(t *testing.T) (*httptest.Server, func() []string) {
+	t.Helper()
+
+	var mu sync.Mutex
+	var deltas []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var metadata map[string]string
+		json.NewDecoder(r.Body).Decode(&metadata)
+
+		mu.Lock()
+		deltas = append(deltas, metadata["delta"])
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), deltas...)
+	}
+}
+
+func TestDeltaProfilesFailedUpload(t *testing.T) {
+	server, deltas := deltaMetadataServer(t)
+
+	p, err := newProfiler(Config{
+		APIKey:        "test-key",
+		IngestURL:     server.URL,
+		Storage:       &failingStorage{failOn: 2},
+		ServiceName:   "test-service",
+		EnableMutex:   true,
+		DeltaProfiles: true,
+	})
+	if err != nil {
+		t.Fatalf("newProfiler() error = %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		_, err := p.collectMutex(context.Background())
+		if (err != nil) != (i == 1) {
+			t.Fatalf("collectMutex() %d error = %v", i+1, err)
+		}
+	}
+
+	// The failed delta never reached the server, so the next upload is a
+	// full profile rather than a delta against the lost one's base
+	want := []string{"false", "false", "true"}
+	if got := deltas(); !reflect.DeepEqual(got, want) {
+		t.Errorf("delta flags = %v, want %v", got, want)
+	}
+}
+
+func TestDeltaFlagCarriedWithQueuedProfile(t *testing.T) {
+	server, deltas := deltaMetadataServer(t)
+
+	p, err := newProfiler(Config{
+		APIKey:          "test-key",
+		IngestURL:       server.URL,
+		Storage:         &captureStorage{},
+		ServiceName:     "test-service",
+		EnableMutex:     true,
+		DeltaProfiles:   true,
+		UploadQueueSize: 1,
+	})
+	if err != nil {
+		t.Fatalf("newProfiler() error = %v", err)
+	}
+
+	// A full profile waits in the queue while a later delta is uploaded
+	if _, err := p.collectMutex(withQueuedUpload(context.Background(), true)); err != nil {
+		t.Fatalf("collectMutex() queued error = %v", err)
+	}
+	if _, err := p.collectMutex(context.Background()); err != nil {
+		t.Fatalf("collectMutex() error = %v", err)
+	}
+	p.drainUploadQueue()
+
+	want := []string{"true", "false"}
+	if got := deltas(); !reflect.DeepEqual(got, want) {
+		t.Errorf("delta flags = %v, want %v", got, want)
+	}
+}
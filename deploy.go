@@ -0,0 +1,95 @@
+package pprofio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// deployTagPrefix namespaces tags read from DeployMetadataFile
+const deployTagPrefix = "deploy."
+
+// readDeployMetadata reads a JSON object from path and returns its fields as
+// tags under deployTagPrefix. Strings are used as-is; other values keep
+// their JSON encoding.
+func readDeployMetadata(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read deploy metadata file: %w", err)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("failed to parse deploy metadata file: %w", err)
+	}
+
+	tags := make(map[string]string, len(fields))
+	for k, raw := range fields {
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			tags[deployTagPrefix+k] = s
+			continue
+		}
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, raw); err != nil {
+			return nil, fmt.Errorf("failed to parse deploy metadata field %q: %w", k, err)
+		}
+		tags[deployTagPrefix+k] = compact.String()
+	}
+	return tags, nil
+}
+
+// loadDeployMetadata replaces the deploy tags with the contents of
+// DeployMetadataFile.
+func (p *Profiler) loadDeployMetadata() error {
+	tags, err := readDeployMetadata(p.config.DeployMetadataFile)
+	if err != nil {
+		return err
+	}
+
+	p.deployMu.Lock()
+	p.deployTags = tags
+	p.deployMu.Unlock()
+	return nil
+}
+
+// deployMetadata returns the tags read from DeployMetadataFile
+func (p *Profiler) deployMetadata() map[string]string {
+	p.deployMu.Lock()
+	defer p.deployMu.Unlock()
+	return p.deployTags
+}
+
+// notifyReload returns a channel receiving SIGHUP, registered before Start
+// returns so an early signal does not terminate the process.
+func notifyReload() chan os.Signal {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	return signals
+}
+
+// watchDeployMetadata re-reads DeployMetadataFile on every SIGHUP, keeping
+// the previous tags if the file cannot be read.
+func (p *Profiler) watchDeployMetadata(ctx context.Context, signals chan os.Signal) {
+	defer p.wg.Done()
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-signals:
+			if err := p.loadDeployMetadata(); err != nil {
+				p.config.Logger.Errorf("Error reloading deploy metadata: %v", err)
+				continue
+			}
+			p.config.Logger.Debugf("Reloaded deploy metadata from %s", p.config.DeployMetadataFile)
+		case <-p.stopCh:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package pprofio
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDeployMetadataFile(t *testing.T) {
+	var mu sync.Mutex
+	var metadata map[string]string
+	ingest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		metadata = nil
+		json.NewDecoder(r.Body).Decode(&metadata)
+	}))
+	defer ingest.Close()
+
+	path := filepath.Join(t.TempDir(), "deploy.json")
+	writeDeploy := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+	writeDeploy(`{"commit": "abc123", "build_number": 42, "deploy_time": "2024-05-01T12:00:00Z"}`)
+
+	p, err := New(Config{
+		APIKey:             "test-key",
+		IngestURL:          ingest.URL,
+		SampleRate:         time.Hour,
+		Storage:            &captureStorage{},
+		ServiceName:        "test-service",
+		Tags:               map[string]string{"env": "prod"},
+		EnableGoroutine:    true,
+		DeployMetadataFile: path,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	flush := func() map[string]string {
+		t.Helper()
+		if results := p.Flush(context.Background(), ProfileGoroutine); results[0].Err != nil {
+			t.Fatalf("Flush() error = %v", results[0].Err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return metadata
+	}
+
+	got := flush()
+	want := map[string]string{
+		"deploy.commit":       "abc123",
+		"deploy.build_number": "42",
+		"deploy.deploy_time":  "2024-05-01T12:00:00Z",
+		"env":                 "prod",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("metadata[%q] = %q, want %q", k, got[k], v)
+		}
+	}
+
+	// A redeploy rewrites the file; the profiler picks it up on reload
+	writeDeploy(`{"commit": "def456"}`)
+	if err := p.loadDeployMetadata(); err != nil {
+		t.Fatalf("loadDeployMetadata() error = %v", err)
+	}
+	got = flush()
+	if got["deploy.commit"] != "def456" {
+		t.Errorf("metadata[deploy.commit] after reload = %q, want %q", got["deploy.commit"], "def456")
+	}
+	if _, ok := got["deploy.build_number"]; ok {
+		t.Error("Fields missing from the reloaded file should be dropped")
+	}
+}
+
+func TestDeployMetadataFileInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deploy.json")
+	if err := os.WriteFile(path, []byte(`["not", "an", "object"]`), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	for _, file := range []string{path, filepath.Join(t.TempDir(), "missing.json")} {
+		_, err := New(Config{
+			APIKey:             "test-key",
+			IngestURL:          "https://api.pprofio.com",
+			ServiceName:        "test-service",
+			DeployMetadataFile: file,
+		})
+		if err == nil {
+			t.Errorf("New() with DeployMetadataFile %s should return error", file)
+		}
+	}
+}
@@ -62,16 +62,121 @@ Basic usage:
 The Config struct allows you to customize the profiler's behavior:
 
   - APIKey: Your Pprofio API key for authentication
+  - APIKeyFile: Read the API key from a file, e.g. a mounted secret
+  - TLSConfig, ClientCertFile, ClientKeyFile: Mutual TLS for uploads and metadata
+  - HTTPClient, HTTPTimeout: Client or timeout for uploads and metadata (default: 30s uploads, 10s metadata)
+  - ProxyURL: HTTP or SOCKS5 proxy for uploads and metadata (default: HTTP_PROXY/HTTPS_PROXY)
+  - Headers: Extra HTTP headers for uploads and metadata; the profiler's own headers take precedence
+  - DeployMetadataFile: Tag profiles with the fields of a deploy.json as "deploy.*", re-read on SIGHUP
   - IngestURL: The Pprofio API endpoint (usually https://api.pprofio.com)
   - SampleRate: How often to collect profiles (default: 60s)
+  - ProfileOptions: Per-type SampleRate and Duration overrides (e.g. goroutines every 15s); SampleRate overrides cannot be combined with Snapshots
+  - MemoryInterval: Minimum time between scheduled memory profiles, which force a GC (default: 30s)
   - ProfileDuration: Length of each sample (default: 10s for CPU/mutex/block)
-  - Storage: Choose HTTPStorage, FileStorage, or custom implementation
+  - Storage: Choose HTTPStorage, FileStorage, PyroscopeStorage, DatadogStorage, or custom implementation
+  - UploadPathByType: Per-type ingest paths for the default HTTPStorage (e.g. "/cpu")
   - ServiceName: Identifier for your application
-  - Tags: Additional metadata (e.g., "env=prod", "version=1.2.3")
+  - Tags: Additional metadata (e.g., "env=prod", "version=1.2.3"); "version"
+    defaults to the main module version from the binary's build info
   - MemProfileRate: Controls memory profiling detail (default: 4096)
+  - DisableMemSampling: Set runtime.MemProfileRate to 0 while running, since a zero MemProfileRate means the default
   - MutexFraction: Controls mutex profiling frequency (default: 5)
+  - MutexLockNames: Friendly lock names attached to mutex profile metadata
   - BlockProfileRate: Controls block profiling frequency (default: 100)
   - EnableCPU, EnableMemory, etc.: Toggle specific profile types
+  - Profiles: Name the profile types to collect (e.g. "cpu", "heap"), overriding the Enable* flags
+  - TransactionalUploads: Reserve, upload and complete each profile so failures leave no orphans
+  - CaptureOnShutdown, ShutdownTimeout: Flush a final profile set on SIGTERM/SIGINT within a bounded time
+  - ReraiseShutdownSignal: Re-raise SIGTERM/SIGINT after that flush, when the profiler is the only handler
+  - FlushOnCrash, FallbackDir: Best-effort write of the latest profiles to disk on SIGSEGV/SIGABRT
+  - UploadWorkers: Bound on concurrent compressions and uploads; above one, Flush collects types in parallel
+  - UploadQueueSize: Queue scheduled uploads in memory so slow ingest does not delay collection; drops the oldest when full
+  - MaxUploadsPerHour: Cap on scheduled uploads per sliding hour to bound ingest cost
+  - MinCPUUsage: Skip scheduled CPU profiles while the process is mostly idle
+  - DisableCompression: Upload plain protobuf profiles, decompressing gzipped ones (for debugging)
+  - Snapshots: Collect all types together and post a per-cycle manifest to /snapshot
+  - BlockEvents: Keep only block samples of the given kinds (e.g. "chan", "mutex")
+  - FileExtensions: Name stored profiles with a per-type suffix (e.g. ".cpu.pb.gz")
+  - GoroutineLabels: Keep only goroutines carrying the given pprof labels
+  - DeltaProfiles: Upload mutex/block profiles as deltas between collections
+  - Scoped: Never change global runtime profiling rates (for use inside libraries)
+  - MaxSpanNames: Cap on distinct span names per flush; the rest are bucketed as "pprofio.other"
+  - Logger: Receives internal logs, including one summary line per snapshot cycle or Flush;
+    a StructuredLogger (such as NewSlogLogger on Go 1.21+) gets per-collection fields
+  - DisableUnderTest: Keep the API usable but collect nothing (for tests and -race runs)
+  - IncludeContainerMetadata: Tag profiles with the container ID on Linux
+  - RetentionByTrigger: Retention tag per trigger for server-side TTL (default: "incident" for manual/signal flushes)
+  - MaxTags: Upper bound on tags per profile; the first N by key are kept
+  - RedactTags: Tag keys whose values are sent as SHA-256 hashes (for PII)
+  - KeepTempFiles, DebugDir: Keep the most recent uploaded profiles on disk for debugging
+  - EnableTrace, TraceInterval: Collect runtime execution traces on a separate, slower cadence (default 10m)
+  - EnableAllocs: Collect the allocs profile alongside (or instead of) the heap profile
+  - HeapProfileMode: Collect the inuse heap profile, the allocs profile, or both
+  - HeapDefaultSampleType: Default view for heap profiles (e.g. "alloc_space")
+  - HeapGCEveryN: Force a GC before only every Nth memory profile to reduce GC load
+  - HeapBaselineDelta: Also upload each cycle's heap change since a baseline taken at Start, for leak detection
+  - ReplayDir: Upload the profiles in a directory on the normal cadence instead of sampling, for testing ingest
+
+# On-demand Collection
+
+Flush collects and uploads profiles immediately, outside the sampling schedule,
+and reports the outcome of each one. Pass ProfileType values to select types:
+
+	results := p.Flush(ctx, pprofio.ProfileCPU, pprofio.ProfileGoroutine)
+	for _, r := range results {
+		log.Printf("%s: %s (%d bytes) err=%v", r.Type, r.URL, r.SizeBytes, r.Err)
+	}
+
+With no types, Flush also uploads the spans received so far when
+EnableCustom is set, so a custom-only profiler can be flushed on demand.
+
+Handler exposes the same on-demand collection over HTTP for ops tooling.
+GET /pprofio/collect?type=cpu collects one enabled profile type and responds
+with its URL as JSON:
+
+	go http.ListenAndServe("localhost:6061", p.Handler())
+
+For support tickets, GET /pprofio/bundle.zip on the same handler downloads the
+most recent profile of each type together with a metadata.json manifest. Once
+Handler has been called, the latest profile per type other than trace is kept
+in memory for this purpose.
+
+Drain waits for in-flight uploads to finish without stopping collection.
+
+Stats reports the profiler's own counters: profiles collected, uploaded and
+failed, bytes uploaded and spans flushed, in total and per profile type with
+the time of the last upload:
+
+	stats := p.Stats()
+	last := stats.Types[pprofio.ProfileCPU].LastUploadTime
+
+The promcollector subpackage publishes the same counters, plus time spent
+collecting, as Prometheus metrics:
+
+	prometheus.MustRegister(promcollector.New(p))
+
+Events delivers one CollectionEvent per collection for reactive tooling. The
+channel is bounded and drops the oldest events if the consumer falls behind:
+
+	go func() {
+		for e := range p.Events() {
+			log.Printf("%s at %s: %d bytes err=%v", e.Type, e.Time, e.SizeBytes, e.Err)
+		}
+	}()
+
+For a plain callback, Config.OnUpload is called on its own goroutine after
+every upload attempt with the Storage result or error.
+
+Pause suspends scheduled collection, for example during a heavy batch window,
+without stopping the profiler or restoring runtime settings. Resume restarts
+the schedule:
+
+	p.Pause()
+	runBatch()
+	p.Resume()
+
+UpdateSampleRate changes the collection interval of a running profiler, for
+example to sample more often under load, without a Stop/Start cycle.
 
 # Custom Instrumentation
 
@@ -84,6 +189,47 @@ To ensure spans are collected, attach the profiler to your context:
 
 	ctx = pprofio.WithProfiler(ctx, p)
 
+Each span gets a random ID, returned by span.ID(). Profiles whose collection
+begins while spans are active carry their IDs, comma-separated, in the
+"span_id" metadata field, so a profile can be correlated with the spans it
+covers.
+
+Spans report their duration in nanoseconds by default. To report another
+measurement, set a value and its unit, which is carried in the custom
+profile's sample type and metadata:
+
+	span.SetValue(int64(len(payload)), "bytes")
+
+To make some operations count for more, weight the span. Weighted spans add a
+"weighted_" sample value per unit, in which unweighted spans count once:
+
+	span.SetWeight(requestCost)
+
+To see custom spans in an OpenTelemetry tracing UI as well, set SpanTracer to
+the otelbridge subpackage's tracer. Each span then also starts an
+OpenTelemetry span with the same name, its tags as attributes, and the
+context returned by StartSpan carries it:
+
+	cfg.SpanTracer = otelbridge.New(otel.GetTracerProvider())
+
+To attribute CPU samples to a request, tenant or endpoint, run the work under
+Do. Samples taken while fn runs carry the labels in collected CPU profiles:
+
+	pprofio.Do(ctx, map[string]string{"tenant": tenant}, func(ctx context.Context) {
+		process(ctx)
+	})
+
+Every CPU sample is also labeled with the service name ("service") and the
+configured tags, so aggregate views can split by service or environment.
+Labels set by the application under the same keys take precedence.
+
+HTTP handlers can be wrapped so each request becomes a span. Supply a RouteFunc
+returning the matched route template to avoid one span name per raw path:
+
+	handler = p.Middleware(handler, func(r *http.Request) string {
+		return routeTemplate(r) // e.g. "/users/{id}"
+	})
+
 # Custom Storage
 
 Implement the Storage interface to create your own storage backend:
@@ -92,10 +238,67 @@ Implement the Storage interface to create your own storage backend:
 		// Your fields here
 	}
 
-	func (s *MyStorage) Upload(ctx context.Context, filePath string) (string, error) {
-		// Your implementation here
+	func (s *MyStorage) Upload(ctx context.Context, filePath string) (pprofio.UploadResult, error) {
+		// Store the file, then report where it went
+		return pprofio.UploadResult{ProfileURL: url}, nil
 	}
 
+Storages that also implement StreamStorage receive memory, allocs,
+goroutine, mutex and block profiles through UploadStream, written straight
+from memory without a temp file. HTTPStorage does. CPU profiles and traces
+are always collected into a temp file and passed to Upload.
+
+FileStorage names each profile "<service>_<type>_<timestamp>_<seq><ext>" so
+successive profiles never overwrite each other. It keeps every profile it
+writes unless MaxFiles, MaxAge or MaxBytes is set, in which case the oldest
+profiles are pruned after each upload. MaxBytes bounds the directory's total
+size for small container disks. Only files with exactly that name format,
+and an extension FileStorage writes for the type, are removed.
+
+PyroscopeStorage uploads profiles to a Grafana Pyroscope server's /ingest
+endpoint. The application name is built from the service name, the
+Pyroscope profile type and the profile's tags, e.g.
+"checkout.cpu{env=prod}":
+
+	cfg.Storage = pprofio.NewPyroscopeStorage("https://pyroscope.example.com")
+
+DatadogStorage sends profiles to Datadog's profiling intake with the
+DD-API-KEY header, tagged with the service name, env and profile tags:
+
+	cfg.Storage = pprofio.NewDatadogStorage(os.Getenv("DD_API_KEY"), "datadoghq.eu", "prod")
+
+For a queryable local history, the boltstorage subpackage stores profiles in
+an embedded bbolt database with List and Get accessors.
+
+On Azure, the azurestorage subpackage uploads each profile as a gzip-encoded
+block blob, authenticating with a connection string or managed identity:
+
+	storage, err := azurestorage.New("https://acct.blob.core.windows.net", "profiles", "api",
+		azurestorage.WithManagedIdentity(""))
+
+For backends that accept OTLP logs but not profiles, the otlplogs subpackage
+exports each profile as a log record whose body is the base64-encoded profile:
+
+	storage, err := otlplogs.New("http://otel-collector:4318", "checkout")
+
+To stream profiles over a single long-lived connection, the wsstorage
+subpackage sends each one as a binary WebSocket message framed with a JSON
+header, reconnecting when the connection drops:
+
+	storage, err := wsstorage.New("wss://ingest.example.com/stream")
+
+The context passed to Upload carries the service name and the profile's tags
+and type, available through ServiceNameFromUploadContext, TagsFromUploadContext
+and ProfileTypeFromUploadContext:
+
+	tags, _ := pprofio.TagsFromUploadContext(ctx)
+	tenant := tags["tenant"]
+
+Each profile also gets a correlation ID, sent as the CorrelationIDHeader on
+both its upload and metadata requests, recorded in its metadata and logged at
+debug level for each step, so an orphaned profile can be traced through
+both. Custom storages can read it with CorrelationIDFromUploadContext.
+
 # Performance Considerations
 
 The profiler is designed to have minimal impact (<1% CPU) on your application:
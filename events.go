@@ -0,0 +1,47 @@
+package pprofio
+
+import "time"
+
+// eventBufferSize bounds the Events channel. When it is full the oldest event
+// is dropped so a slow consumer never blocks collection.
+const eventBufferSize = 64
+
+// CollectionEvent reports one profile collection, scheduled or on demand
+type CollectionEvent struct {
+	CollectionResult
+
+	// Time is when the collection started
+	Time time.Time
+}
+
+// Events returns a channel receiving an event for every profile collected,
+// including custom span profiles. With UploadQueueSize set, a scheduled
+// profile's event is sent once its queued upload completes or is dropped,
+// and its Duration includes the time spent queued. The channel is buffered;
+// if the consumer falls behind, the oldest events are dropped. It is never
+// closed.
+func (p *Profiler) Events() <-chan CollectionEvent {
+	return p.events
+}
+
+// emitEvent publishes a collection outcome without blocking, dropping the
+// oldest buffered event when the channel is full.
+func (p *Profiler) emitEvent(result CollectionResult, start time.Time) {
+	event := CollectionEvent{CollectionResult: result, Time: start}
+
+	p.eventsMu.Lock()
+	defer p.eventsMu.Unlock()
+
+	for {
+		select {
+		case p.events <- event:
+			return
+		default:
+		}
+
+		select {
+		case <-p.events:
+		default:
+		}
+	}
+}
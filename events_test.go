@@ -0,0 +1,138 @@
+package pprofio
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEventsReportEachCollection(t *testing.T) {
+	metadataServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer metadataServer.Close()
+
+	storage := &failingTypeStorage{fail: ProfileMutex}
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       metadataServer.URL,
+		Storage:         storage,
+		ServiceName:     "test-service",
+		EnableGoroutine: true,
+		EnableMemory:    true,
+		EnableMutex:     true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	events := p.Events()
+	before := time.Now()
+	p.Flush(context.Background())
+
+	got := make(map[ProfileType]CollectionEvent)
+	for i := 0; i < 3; i++ {
+		select {
+		case event := <-events:
+			got[event.Type] = event
+		case <-time.After(time.Second):
+			t.Fatalf("received %d events, want 3", len(got))
+		}
+	}
+
+	for _, pt := range []ProfileType{ProfileGoroutine, ProfileMemory} {
+		event, ok := got[pt]
+		if !ok {
+			t.Fatalf("no event for %s", pt)
+		}
+		if event.Err != nil || event.SizeBytes == 0 || event.URL == "" {
+			t.Errorf("%s event = %+v", pt, event)
+		}
+		if event.Time.Before(before) {
+			t.Errorf("%s event time %v precedes the flush", pt, event.Time)
+		}
+	}
+	if event := got[ProfileMutex]; event.Err == nil {
+		t.Error("mutex event should carry the upload error")
+	}
+}
+
+func TestEventsDropOldest(t *testing.T) {
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       "https://api.pprofio.com",
+		ServiceName:     "test-service",
+		EnableGoroutine: true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	errDropped := errors.New("dropped")
+	for i := 0; i < eventBufferSize+5; i++ {
+		result := CollectionResult{Type: ProfileGoroutine, SizeBytes: int64(i)}
+		if i < 5 {
+			result.Err = errDropped
+		}
+		p.emitEvent(result, time.Now())
+	}
+
+	events := p.Events()
+	if len(events) != eventBufferSize {
+		t.Fatalf("buffered events = %d, want %d", len(events), eventBufferSize)
+	}
+	if first := <-events; first.SizeBytes != 5 || first.Err != nil {
+		t.Errorf("oldest remaining event = %+v, want the sixth", first)
+	}
+}
+
+func TestOnUpload(t *testing.T) {
+	ingest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ingest.Close()
+
+	type call struct {
+		profileType string
+		result      UploadResult
+		err         error
+	}
+	calls := make(chan call, 2)
+
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       ingest.URL,
+		SampleRate:      time.Hour,
+		Storage:         &failingTypeStorage{fail: ProfileMutex},
+		ServiceName:     "test-service",
+		EnableGoroutine: true,
+		EnableMutex:     true,
+		OnUpload: func(profileType string, result UploadResult, err error) {
+			calls <- call{profileType, result, err}
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	results := p.Flush(context.Background(), ProfileGoroutine, ProfileMutex)
+
+	got := make(map[string]call)
+	for i := 0; i < 2; i++ {
+		select {
+		case c := <-calls:
+			got[c.profileType] = c
+		case <-time.After(time.Second):
+			t.Fatalf("OnUpload fired %d times, want 2", i)
+		}
+	}
+
+	goroutine := got[string(ProfileGoroutine)]
+	if goroutine.err != nil || goroutine.result.ProfileURL == "" || goroutine.result.ProfileURL != results[0].URL {
+		t.Errorf("OnUpload(goroutine) = %+v, want URL %q and no error", goroutine, results[0].URL)
+	}
+	if got[string(ProfileMutex)].err == nil {
+		t.Error("OnUpload(mutex) should receive the storage error")
+	}
+}
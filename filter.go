@@ -0,0 +1,134 @@
+package pprofio
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"runtime/pprof"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// blockEventFuncs maps block event categories to the runtime and sync
+// functions that record them in block profile stacks
+var blockEventFuncs = map[string][]string{
+	"chan":      {"runtime.chansend", "runtime.chanrecv"},
+	"select":    {"runtime.selectgo", "runtime.block"},
+	"mutex":     {"sync.(*Mutex).Lock", "sync.(*RWMutex).Lock", "sync.(*RWMutex).RLock"},
+	"cond":      {"sync.(*Cond).Wait"},
+	"waitgroup": {"sync.(*WaitGroup).Wait"},
+}
+
+// writeBlockProfile writes the block profile to w, keeping only samples
+// from the configured BlockEvents categories when any are set, and reports
+// whether it is a delta.
+func (p *Profiler) writeBlockProfile(w io.Writer) (bool, error) {
+	var delta bool
+	err := p.filterBlockProfile(w, func(w io.Writer) error {
+		var err error
+		delta, err = p.writeCumulativeProfile(w, profileTypeBlock)
+		return err
+	})
+	return delta, err
+}
+
+// filterBlockProfile writes the block profile produced by write to w, keeping
+// only samples of the configured BlockEvents.
+func (p *Profiler) filterBlockProfile(w io.Writer, write func(io.Writer) error) error {
+	if len(p.config.BlockEvents) == 0 {
+		return write(w)
+	}
+
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		return err
+	}
+
+	prof, err := profile.Parse(&buf)
+	if err != nil {
+		return fmt.Errorf("failed to parse block profile: %w", err)
+	}
+
+	filterSamplesByFunc(prof, blockEventPrefixes(p.config.BlockEvents))
+	return prof.Write(w)
+}
+
+// blockEventPrefixes returns the function name prefixes for the categories.
+func blockEventPrefixes(categories []string) []string {
+	var prefixes []string
+	for _, c := range categories {
+		prefixes = append(prefixes, blockEventFuncs[c]...)
+	}
+	return prefixes
+}
+
+// writeGoroutineProfile writes the goroutine profile to w, keeping only
+// goroutines carrying every label in GoroutineLabels when any are set.
+func (p *Profiler) writeGoroutineProfile(w io.Writer) error {
+	if len(p.config.GoroutineLabels) == 0 {
+		return pprof.Lookup(string(profileTypeGoroutine)).WriteTo(w, 0)
+	}
+
+	var buf bytes.Buffer
+	if err := pprof.Lookup(string(profileTypeGoroutine)).WriteTo(&buf, 0); err != nil {
+		return err
+	}
+
+	prof, err := profile.Parse(&buf)
+	if err != nil {
+		return fmt.Errorf("failed to parse goroutine profile: %w", err)
+	}
+
+	filterSamplesByLabels(prof, p.config.GoroutineLabels)
+	return prof.Write(w)
+}
+
+// filterSamplesByLabels keeps only samples carrying every key/value pair in
+// selector.
+func filterSamplesByLabels(prof *profile.Profile, selector map[string]string) {
+	kept := prof.Sample[:0]
+	for _, sample := range prof.Sample {
+		if sampleHasLabels(sample, selector) {
+			kept = append(kept, sample)
+		}
+	}
+	prof.Sample = kept
+}
+
+func sampleHasLabels(sample *profile.Sample, selector map[string]string) bool {
+	for k, v := range selector {
+		if !sample.HasLabel(k, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// filterSamplesByFunc keeps only samples with a stack frame whose function
+// name starts with one of prefixes.
+func filterSamplesByFunc(prof *profile.Profile, prefixes []string) {
+	kept := prof.Sample[:0]
+	for _, sample := range prof.Sample {
+		if sampleHasFunc(sample, prefixes) {
+			kept = append(kept, sample)
+		}
+	}
+	prof.Sample = kept
+}
+
+func sampleHasFunc(sample *profile.Sample, prefixes []string) bool {
+	for _, loc := range sample.Location {
+		for _, line := range loc.Line {
+			if line.Function == nil {
+				continue
+			}
+			for _, prefix := range prefixes {
+				if strings.HasPrefix(line.Function.Name, prefix) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
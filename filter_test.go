@@ -0,0 +1,147 @@
+package pprofio
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+func TestBlockProfileEventFilter(t *testing.T) {
+	metadataServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer metadataServer.Close()
+
+	runtime.SetBlockProfileRate(1)
+	defer runtime.SetBlockProfileRate(0)
+
+	// Block on a channel receive and on a contended mutex
+	ch := make(chan struct{})
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		close(ch)
+	}()
+	<-ch
+
+	var mu sync.Mutex
+	mu.Lock()
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		mu.Unlock()
+	}()
+	mu.Lock()
+	mu.Unlock()
+
+	storage := &captureStorage{}
+	p, err := newProfiler(Config{
+		APIKey:      "test-key",
+		IngestURL:   metadataServer.URL,
+		Storage:     storage,
+		ServiceName: "test-service",
+		EnableBlock: true,
+		BlockEvents: []string{"chan"},
+	})
+	if err != nil {
+		t.Fatalf("newProfiler() error = %v", err)
+	}
+
+	if _, err := p.collectBlock(context.Background()); err != nil {
+		t.Fatalf("collectBlock() error = %v", err)
+	}
+
+	prof, err := profile.ParseData(storage.uploads[0])
+	if err != nil {
+		t.Fatalf("Failed to parse uploaded profile: %v", err)
+	}
+
+	if len(prof.Sample) == 0 {
+		t.Fatal("Filtered block profile should keep the channel samples")
+	}
+
+	chanFuncs := blockEventPrefixes([]string{"chan"})
+	mutexFuncs := blockEventPrefixes([]string{"mutex"})
+	for _, sample := range prof.Sample {
+		if !sampleHasFunc(sample, chanFuncs) {
+			t.Error("Filtered block profile contains a non-channel sample")
+		}
+		if sampleHasFunc(sample, mutexFuncs) {
+			t.Error("Filtered block profile contains a mutex sample")
+		}
+	}
+}
+
+func TestGoroutineLabelFilter(t *testing.T) {
+	metadataServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer metadataServer.Close()
+
+	release := make(chan struct{})
+	var started sync.WaitGroup
+	park := func(pool string, n int) {
+		for i := 0; i < n; i++ {
+			started.Add(1)
+			go Do(context.Background(), map[string]string{"pool": pool}, func(ctx context.Context) {
+				started.Done()
+				<-release
+			})
+		}
+	}
+	park("worker", 3)
+	park("other", 2)
+	started.Wait()
+	defer close(release)
+
+	storage := &captureStorage{}
+	p, err := newProfiler(Config{
+		APIKey:          "test-key",
+		IngestURL:       metadataServer.URL,
+		Storage:         storage,
+		ServiceName:     "test-service",
+		EnableGoroutine: true,
+		GoroutineLabels: map[string]string{"pool": "worker"},
+	})
+	if err != nil {
+		t.Fatalf("newProfiler() error = %v", err)
+	}
+
+	if _, err := p.collectGoroutine(context.Background()); err != nil {
+		t.Fatalf("collectGoroutine() error = %v", err)
+	}
+
+	prof, err := profile.ParseData(storage.uploads[0])
+	if err != nil {
+		t.Fatalf("Failed to parse uploaded profile: %v", err)
+	}
+
+	var goroutines int64
+	for _, sample := range prof.Sample {
+		if !sample.HasLabel("pool", "worker") {
+			t.Errorf("Filtered goroutine profile contains a sample labeled %v", sample.Label)
+		}
+		goroutines += sample.Value[0]
+	}
+	if goroutines != 3 {
+		t.Errorf("Filtered goroutine profile has %d goroutines, want 3", goroutines)
+	}
+}
+
+func TestConfigValidation_BlockEvents(t *testing.T) {
+	cfg := Config{
+		APIKey:      "test-key",
+		IngestURL:   "https://api.pprofio.com",
+		Storage:     &HTTPStorage{URL: "https://api.pprofio.com/upload", APIKey: "test-key"},
+		ServiceName: "test-service",
+		BlockEvents: []string{"chan", "io"},
+	}
+
+	if err := cfg.validate(); err == nil {
+		t.Error("validate() with unknown block event category should return error")
+	}
+}
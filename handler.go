@@ -0,0 +1,101 @@
+package pprofio
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// CollectPath is the route served by Handler for on-demand collection
+const CollectPath = "/pprofio/collect"
+
+// collectResponse is the JSON body returned by the collect route
+type collectResponse struct {
+	Type       ProfileType `json:"type"`
+	ProfileID  string      `json:"profile_id,omitempty"`
+	ProfileURL string      `json:"profile_url,omitempty"`
+	SizeBytes  int64       `json:"size_bytes"`
+	DurationMS int64       `json:"duration_ms"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// Handler returns an http.Handler serving CollectPath, which collects and
+// uploads one profile immediately, e.g. GET /pprofio/collect?type=cpu, and
+// responds with the profile's URL as JSON. Only enabled profile types may be
+// requested; others are rejected with 400. It also serves BundlePath, a zip
+// of the most recent profile per type with a metadata.json manifest, for
+// support tickets; profiles are kept for it from the first call to Handler
+// on, and traces are left out. Mount it on an internal listener, since it
+// performs no authentication.
+func (p *Profiler) Handler() http.Handler {
+	p.recentMu.Lock()
+	p.keepRecent = true
+	p.recentMu.Unlock()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(CollectPath, p.serveCollect)
+	mux.HandleFunc(BundlePath, p.serveBundle)
+	return mux
+}
+
+func (p *Profiler) serveCollect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	t := ProfileType(r.URL.Query().Get("type"))
+	if t == "" {
+		writeJSONError(w, http.StatusBadRequest, "type is required")
+		return
+	}
+	if !p.collectable(t) {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("profile type %q is unknown or not enabled", t))
+		return
+	}
+
+	results := p.Flush(r.Context(), t)
+	if len(results) == 0 {
+		writeJSONError(w, http.StatusServiceUnavailable, "collection is disabled")
+		return
+	}
+
+	result := results[0]
+	response := collectResponse{
+		Type:       result.Type,
+		ProfileID:  result.ProfileID,
+		ProfileURL: result.URL,
+		SizeBytes:  result.SizeBytes,
+		DurationMS: result.Duration.Milliseconds(),
+	}
+	status := http.StatusOK
+	if result.Err != nil {
+		response.Error = result.Err.Error()
+		status = http.StatusBadGateway
+	}
+	writeJSON(w, status, response)
+}
+
+// collectable reports whether t is a profile type enabled for collection.
+func (p *Profiler) collectable(t ProfileType) bool {
+	if t == profileTypeTrace {
+		return p.config.EnableTrace
+	}
+	for _, enabled := range p.enabledProfileTypes() {
+		if enabled == t {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
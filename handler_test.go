@@ -0,0 +1,204 @@
+package pprofio
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandlerCollect(t *testing.T) {
+	metadataServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer metadataServer.Close()
+
+	storage := &failingTypeStorage{fail: ProfileMutex}
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       metadataServer.URL,
+		Storage:         storage,
+		ServiceName:     "test-service",
+		EnableGoroutine: true,
+		EnableMutex:     true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	handler := p.Handler()
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		wantStatus int
+	}{
+		{name: "enabled type", method: http.MethodGet, target: "/pprofio/collect?type=goroutine", wantStatus: http.StatusOK},
+		{name: "post", method: http.MethodPost, target: "/pprofio/collect?type=goroutine", wantStatus: http.StatusOK},
+		{name: "upload fails", method: http.MethodGet, target: "/pprofio/collect?type=mutex", wantStatus: http.StatusBadGateway},
+		{name: "disabled type", method: http.MethodGet, target: "/pprofio/collect?type=cpu", wantStatus: http.StatusBadRequest},
+		{name: "unknown type", method: http.MethodGet, target: "/pprofio/collect?type=threads", wantStatus: http.StatusBadRequest},
+		{name: "missing type", method: http.MethodGet, target: "/pprofio/collect", wantStatus: http.StatusBadRequest},
+		{name: "wrong method", method: http.MethodDelete, target: "/pprofio/collect?type=goroutine", wantStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+
+			var body collectResponse
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			switch tt.wantStatus {
+			case http.StatusOK:
+				if body.Type != ProfileGoroutine || body.ProfileURL == "" || body.SizeBytes == 0 {
+					t.Errorf("response = %+v", body)
+				}
+			default:
+				if body.Error == "" {
+					t.Error("error response has no error message")
+				}
+			}
+		})
+	}
+}
+
+func TestHandlerBundle(t *testing.T) {
+	metadataServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer metadataServer.Close()
+
+	storage := &captureStorage{}
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       metadataServer.URL,
+		Storage:         storage,
+		ServiceName:     "test-service",
+		ProfileDuration: 10 * time.Millisecond,
+		EnableCPU:       true,
+		EnableGoroutine: true,
+		EnableMutex:     true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	handler := p.Handler()
+	// Collect twice so the bundle must hold only the latest per type
+	for i := 0; i < 2; i++ {
+		for _, r := range p.Flush(context.Background()) {
+			if r.Err != nil {
+				t.Fatalf("Flush(%s) error = %v", r.Type, r.Err)
+			}
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, BundlePath, nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("GET %s = %d %s", BundlePath, rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	body := rec.Body.Bytes()
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("Bundle is not a zip: %v", err)
+	}
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("Open(%s) error = %v", f.Name, err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = data
+	}
+
+	storage.mu.Lock()
+	latestGoroutine := storage.uploads[len(storage.uploads)-2]
+	storage.mu.Unlock()
+	for _, name := range []string{"cpu.pprof", "goroutine.pprof", "mutex.pprof", "metadata.json"} {
+		if len(files[name]) == 0 {
+			t.Errorf("Bundle is missing %s: has %d files", name, len(files))
+		}
+	}
+	if !bytes.Equal(files["goroutine.pprof"], latestGoroutine) {
+		t.Error("Bundle goroutine profile is not the most recent one")
+	}
+
+	var manifest bundleManifest
+	if err := json.Unmarshal(files["metadata.json"], &manifest); err != nil {
+		t.Fatalf("Failed to decode manifest: %v", err)
+	}
+	if manifest.Service != "test-service" || len(manifest.Profiles) != 3 {
+		t.Errorf("manifest = %+v, want 3 profiles for test-service", manifest)
+	}
+	for _, profile := range manifest.Profiles {
+		if profile.SizeBytes != len(files[profile.File]) {
+			t.Errorf("manifest lists %s at %d bytes, bundle holds %d", profile.File, profile.SizeBytes, len(files[profile.File]))
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, BundlePath, nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST %s = %d, want %d", BundlePath, rec.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestRecentProfilesKeptOnlyForBundlesAndCrashes(t *testing.T) {
+	metadataServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer metadataServer.Close()
+
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       metadataServer.URL,
+		Storage:         &captureStorage{},
+		ServiceName:     "test-service",
+		ProfileDuration: 10 * time.Millisecond,
+		EnableGoroutine: true,
+		EnableTrace:     true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	flush := func() {
+		t.Helper()
+		for _, r := range p.Flush(context.Background(), ProfileGoroutine, ProfileTrace) {
+			if r.Err != nil {
+				t.Fatalf("Flush(%s) error = %v", r.Type, r.Err)
+			}
+		}
+	}
+
+	// Without the bundle route or FlushOnCrash, nothing is held in memory
+	flush()
+	if recent := p.recentProfiles(); len(recent) != 0 {
+		t.Fatalf("recentProfiles() = %d profiles before Handler, want none", len(recent))
+	}
+
+	p.Handler()
+	flush()
+	recent := p.recentProfiles()
+	if len(recent) != 1 || recent[0].Type != ProfileGoroutine {
+		t.Errorf("recentProfiles() = %+v, want only the goroutine profile", recent)
+	}
+}
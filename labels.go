@@ -0,0 +1,92 @@
+package pprofio
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"runtime/pprof"
+	"sort"
+
+	"github.com/google/pprof/profile"
+)
+
+// Do calls fn with a context carrying the given pprof labels. CPU samples
+// taken while fn runs, including in goroutines it starts with that context,
+// carry the labels in the profiles collected by the profiler, so they can
+// be filtered by request, tenant or endpoint.
+func Do(ctx context.Context, labels map[string]string, fn func(ctx context.Context)) {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, 2*len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k, labels[k])
+	}
+
+	pprof.Do(ctx, pprof.Labels(pairs...), fn)
+}
+
+// serviceLabel is the CPU sample label carrying ServiceName
+const serviceLabel = "service"
+
+// writeCPUProfile records a CPU profile over its profile duration and writes it to
+// w with the service name and tags added as labels on every sample. The
+// labels are applied to the recorded profile rather than set with pprof.Do,
+// so goroutines of the host application are never relabeled.
+func (p *Profiler) writeCPUProfile(ctx context.Context, w io.Writer) error {
+	labels := p.cpuProfileLabels()
+	if len(labels) == 0 {
+		if err := pprof.StartCPUProfile(w); err != nil {
+			return fmt.Errorf("failed to start CPU profile: %w", err)
+		}
+		p.waitProfileDuration(ctx, profileTypeCPU)
+		pprof.StopCPUProfile()
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := pprof.StartCPUProfile(&buf); err != nil {
+		return fmt.Errorf("failed to start CPU profile: %w", err)
+	}
+	p.waitProfileDuration(ctx, profileTypeCPU)
+	pprof.StopCPUProfile()
+
+	prof, err := profile.Parse(&buf)
+	if err != nil {
+		return fmt.Errorf("failed to parse CPU profile: %w", err)
+	}
+	addSampleLabels(prof, labels)
+	return prof.Write(w)
+}
+
+// cpuProfileLabels returns the labels added to every CPU sample.
+func (p *Profiler) cpuProfileLabels() map[string]string {
+	tags := p.profileTags()
+	labels := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		labels[k] = v
+	}
+	if p.config.ServiceName != "" {
+		labels[serviceLabel] = p.config.ServiceName
+	}
+	return labels
+}
+
+// addSampleLabels adds labels to every sample in prof. A label the
+// application already set on a sample under the same key is kept.
+func addSampleLabels(prof *profile.Profile, labels map[string]string) {
+	for _, sample := range prof.Sample {
+		if sample.Label == nil {
+			sample.Label = make(map[string][]string, len(labels))
+		}
+		for k, v := range labels {
+			if _, ok := sample.Label[k]; !ok {
+				sample.Label[k] = []string{v}
+			}
+		}
+	}
+}
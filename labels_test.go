@@ -0,0 +1,134 @@
+package pprofio
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+func TestDoLabelsCPUSamples(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	storage := &captureStorage{}
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       server.URL,
+		ProfileDuration: 300 * time.Millisecond,
+		Storage:         storage,
+		ServiceName:     "test-service",
+		EnableCPU:       true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Do(context.Background(), map[string]string{"tenant": "acme", "endpoint": "/checkout"}, func(ctx context.Context) {
+			deadline := time.Now().Add(250 * time.Millisecond)
+			x := 0
+			for time.Now().Before(deadline) {
+				x++
+			}
+			_ = x
+		})
+	}()
+
+	if _, err := p.collectCPU(context.Background()); err != nil {
+		t.Fatalf("collectCPU() error = %v", err)
+	}
+	<-done
+
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	if len(storage.uploads) != 1 {
+		t.Fatalf("Expected 1 upload, got %d", len(storage.uploads))
+	}
+	prof, err := profile.Parse(bytes.NewReader(storage.uploads[0]))
+	if err != nil {
+		t.Fatalf("Failed to parse CPU profile: %v", err)
+	}
+
+	for _, sample := range prof.Sample {
+		tenant := sample.Label["tenant"]
+		endpoint := sample.Label["endpoint"]
+		if len(tenant) == 1 && tenant[0] == "acme" && len(endpoint) == 1 && endpoint[0] == "/checkout" {
+			return
+		}
+	}
+	t.Errorf("No CPU sample carries the labels from Do across %d samples", len(prof.Sample))
+}
+
+func TestCPUSamplesLabeledWithServiceAndTags(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	storage := &captureStorage{}
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       server.URL,
+		ProfileDuration: 300 * time.Millisecond,
+		Storage:         storage,
+		ServiceName:     "test-service",
+		Tags:            map[string]string{"env": "prod"},
+		EnableCPU:       true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	// The application's own env label must survive
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Do(context.Background(), map[string]string{"env": "canary"}, func(ctx context.Context) {
+			deadline := time.Now().Add(250 * time.Millisecond)
+			x := 0
+			for time.Now().Before(deadline) {
+				x++
+			}
+			_ = x
+		})
+	}()
+
+	if _, err := p.collectCPU(context.Background()); err != nil {
+		t.Fatalf("collectCPU() error = %v", err)
+	}
+	<-done
+
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	prof, err := profile.Parse(bytes.NewReader(storage.uploads[0]))
+	if err != nil {
+		t.Fatalf("Failed to parse CPU profile: %v", err)
+	}
+	if len(prof.Sample) == 0 {
+		t.Fatal("CPU profile has no samples")
+	}
+
+	var canary bool
+	for _, sample := range prof.Sample {
+		if got := sample.Label[serviceLabel]; len(got) != 1 || got[0] != "test-service" {
+			t.Fatalf("sample service label = %v, want [test-service]", got)
+		}
+		env := sample.Label["env"]
+		if len(env) != 1 {
+			t.Fatalf("sample env label = %v", env)
+		}
+		canary = canary || env[0] == "canary"
+	}
+	if !canary {
+		t.Error("application label env=canary was overwritten by the env tag")
+	}
+}
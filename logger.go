@@ -0,0 +1,101 @@
+package pprofio
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Logger receives the profiler's internal log output. Set Config.Logger to
+// route it into an existing logging setup, for example log/slog:
+//
+//	type slogLogger struct{ l *slog.Logger }
+//
+//	func (s slogLogger) Debugf(format string, args ...interface{}) {
+//		s.l.Debug(fmt.Sprintf(format, args...))
+//	}
+//
+//	func (s slogLogger) Errorf(format string, args ...interface{}) {
+//		s.l.Error(fmt.Sprintf(format, args...))
+//	}
+//
+//	cfg.Logger = slogLogger{slog.Default()}
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// stderrLogger is the default Logger. It writes errors to stderr and
+// discards debug output.
+type stderrLogger struct{}
+
+func (stderrLogger) Debugf(format string, args ...interface{}) {}
+
+func (stderrLogger) Errorf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+}
+
+// Field is a key/value pair attached to a structured log event
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// StructuredLogger is an optional extension of Logger. When Config.Logger
+// implements it, collection and upload events are logged as a message with
+// fields (profile type, duration, bytes uploaded, attempt count) instead of
+// formatted text. NewSlogLogger returns one backed by log/slog.
+type StructuredLogger interface {
+	Logger
+	Debug(msg string, fields ...Field)
+	Error(msg string, fields ...Field)
+}
+
+// collectionFields returns the structured fields describing a collection.
+func collectionFields(result CollectionResult) []Field {
+	fields := []Field{
+		{Key: "profile_type", Value: string(result.Type)},
+		{Key: "duration", Value: result.Duration},
+		{Key: "bytes", Value: result.SizeBytes},
+		{Key: "attempts", Value: result.Attempts},
+	}
+	if result.Err != nil {
+		fields = append(fields, Field{Key: "error", Value: result.Err.Error()})
+	}
+	return fields
+}
+
+// logCollection logs a collection and its upload at debug level.
+func (p *Profiler) logCollection(result CollectionResult) {
+	if logger, ok := p.config.Logger.(StructuredLogger); ok {
+		logger.Debug("profile collected", collectionFields(result)...)
+		return
+	}
+	p.config.Logger.Debugf("profile collected type=%s duration=%s bytes=%d attempts=%d err=%v",
+		result.Type, result.Duration.Round(time.Millisecond), result.SizeBytes, result.Attempts, result.Err)
+}
+
+// logCollectionError logs a failed scheduled collection at error level.
+func (p *Profiler) logCollectionError(profileType ProfileType, err error) {
+	if logger, ok := p.config.Logger.(StructuredLogger); ok {
+		logger.Error("profile collection failed",
+			Field{Key: "profile_type", Value: string(profileType)},
+			Field{Key: "error", Value: err.Error()})
+		return
+	}
+	p.config.Logger.Errorf("Error collecting %s profile: %v", profileType, err)
+}
+
+// logCorrelation logs a step of a profile's two-step upload at debug level
+// with the correlation ID shared by its upload and metadata requests.
+func (p *Profiler) logCorrelation(ctx context.Context, msg, profileURL string) {
+	id, _ := CorrelationIDFromUploadContext(ctx)
+	if logger, ok := p.config.Logger.(StructuredLogger); ok {
+		logger.Debug(msg,
+			Field{Key: "correlation_id", Value: id},
+			Field{Key: "profile_url", Value: profileURL})
+		return
+	}
+	p.config.Logger.Debugf("%s correlation_id=%s profile_url=%s", msg, id, profileURL)
+}
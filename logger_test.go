@@ -0,0 +1,109 @@
+package pprofio
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// has reports whether a line logged at error level contains substr
+func (l *captureLogger) has(substr string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range l.errors {
+		if strings.Contains(line, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLoggerReceivesCollectionErrors(t *testing.T) {
+	logger := &captureLogger{}
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       "http://localhost",
+		SampleRate:      time.Hour,
+		Storage:         &failingTypeStorage{fail: ProfileGoroutine},
+		ServiceName:     "test-service",
+		EnableGoroutine: true,
+		EnableCustom:    true,
+		Logger:          logger,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	// The initial scheduled collection fails to upload
+	waitFor(t, func() bool { return logger.has("Error collecting goroutine profile: ") })
+
+	// Spans flushed on Stop fail to register metadata with the unreachable
+	// ingest API
+	_, span := StartSpan(WithProfiler(context.Background(), p), "handle_request")
+	span.End()
+	p.Stop()
+
+	if !logger.has("Error processing spans: ") {
+		logger.mu.Lock()
+		defer logger.mu.Unlock()
+		t.Errorf("Span errors not routed to Logger: %v", logger.errors)
+	}
+}
+
+func TestUploadCorrelationID(t *testing.T) {
+	var mu sync.Mutex
+	headers := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		headers[r.URL.Path] = r.Header.Get(CorrelationIDHeader)
+		mu.Unlock()
+		if r.URL.Path == "/upload" {
+			w.Write([]byte("https://storage.pprofio.com/p1.pprof"))
+		}
+	}))
+	defer server.Close()
+
+	logger := &captureLogger{}
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       server.URL,
+		SampleRate:      time.Hour,
+		ServiceName:     "test-service",
+		EnableGoroutine: true,
+		Logger:          logger,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if results := p.Flush(context.Background(), ProfileGoroutine); results[0].Err != nil {
+		t.Fatalf("Flush() error = %v", results[0].Err)
+	}
+
+	mu.Lock()
+	id := headers["/upload"]
+	metadataID := headers["/metadata"]
+	mu.Unlock()
+	if id == "" || metadataID != id {
+		t.Fatalf("Correlation IDs: upload %q, metadata %q, want the same non-empty ID", id, metadataID)
+	}
+
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	for _, step := range []string{"profile uploaded", "metadata sent"} {
+		want := step + " correlation_id=" + id + " profile_url=https://storage.pprofio.com/p1.pprof"
+		found := false
+		for _, line := range logger.debugs {
+			found = found || line == want
+		}
+		if !found {
+			t.Errorf("Debug log missing %q in %v", want, logger.debugs)
+		}
+	}
+}
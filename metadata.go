@@ -4,19 +4,48 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// readBuildInfo is replaceable in tests
+var readBuildInfo = debug.ReadBuildInfo
+
+// buildVersion returns the main module version embedded in the binary, or an
+// empty string when it is unavailable or a development build.
+func buildVersion() string {
+	info, ok := readBuildInfo()
+	if !ok || info == nil {
+		return ""
+	}
+
+	version := info.Main.Version
+	if version == "(devel)" {
+		return ""
+	}
+	return version
+}
+
 // metadataClient handles sending profile metadata to the ingest API
 type metadataClient struct {
 	ingestURL string
 	apiKey    string
+	env       string
 	client    *http.Client
 	retries   int
+	headers   map[string]string
+
+	// maxBackoff caps the wait between attempts, including waits requested
+	// with Retry-After
+	maxBackoff time.Duration
 }
 
 func newMetadataClient(ingestURL, apiKey string) *metadataClient {
@@ -25,51 +54,76 @@ func newMetadataClient(ingestURL, apiKey string) *metadataClient {
 		apiKey:    apiKey,
 		client:    &http.Client{Timeout: 10 * time.Second},
 		retries:   3,
+
+		maxBackoff: DefaultMaxBackoff,
 	}
 }
 
 func (m *metadataClient) sendMetadata(ctx context.Context, metadata map[string]string) error {
+	return m.post(ctx, "/metadata", metadata)
+}
+
+// post sends body as JSON to path under the ingest URL, retrying failures.
+func (m *metadataClient) post(ctx context.Context, path string, body interface{}) error {
+	return m.postJSON(ctx, path, body, nil)
+}
+
+// postJSON is post that also decodes the JSON response into out, if non-nil.
+func (m *metadataClient) postJSON(ctx context.Context, path string, body, out interface{}) error {
 	// Validate URL
 	parsedURL, err := url.Parse(m.ingestURL)
 	if err != nil {
 		return fmt.Errorf("invalid ingest URL: %w", err)
 	}
-	// Skip HTTPS check in tests if running a localhost/127.0.0.1 URL
-	if parsedURL.Scheme != "https" && !strings.Contains(parsedURL.Host, "localhost") &&
-		!strings.Contains(parsedURL.Host, "127.0.0.1") {
+	// Plain HTTP is only allowed for loopback hosts or an explicit local env
+	if parsedURL.Scheme != "https" && m.env != "local" && !isLoopback(parsedURL) {
 		return fmt.Errorf("HTTPS is required for ingest URL")
 	}
 
-	// Marshal metadata to JSON
-	payload, err := json.Marshal(metadata)
+	// Marshal body to JSON
+	payload, err := json.Marshal(body)
 	if err != nil {
-		return fmt.Errorf("failed to marshal metadata: %w", err)
+		return fmt.Errorf("failed to marshal %s: %w", strings.TrimPrefix(path, "/"), err)
 	}
 
 	// Send with retries
 	var lastErr error
 	for attempt := 0; attempt < m.retries; attempt++ {
-		if err := m.sendRequest(ctx, payload); err != nil {
+		if err := m.sendRequest(ctx, path, payload, out); err != nil {
 			lastErr = err
-			// Exponential backoff
-			backoffMs := (1 << uint(attempt)) * 100
-			time.Sleep(time.Duration(backoffMs) * time.Millisecond)
+			if attempt == m.retries-1 {
+				break
+			}
+			// Exponential backoff, or longer if the server asked us to wait
+			backoff := time.Duration(1<<uint(attempt)) * baseBackoff
+			var retryAfter time.Duration
+			var ra *retryAfterError
+			if errors.As(err, &ra) {
+				retryAfter = ra.wait
+			}
+			if err := sleepContext(ctx, retryDelay(backoff, retryAfter, m.maxBackoff)); err != nil {
+				return fmt.Errorf("failed to send %s: %w", strings.TrimPrefix(path, "/"), err)
+			}
 			continue
 		}
 		return nil
 	}
 
-	return fmt.Errorf("failed to send metadata after %d attempts: %w", m.retries, lastErr)
+	return fmt.Errorf("failed to send %s after %d attempts: %w", strings.TrimPrefix(path, "/"), m.retries, lastErr)
 }
 
-func (m *metadataClient) sendRequest(ctx context.Context, payload []byte) error {
-	req, err := http.NewRequestWithContext(ctx, "POST", m.ingestURL+"/metadata", bytes.NewReader(payload))
+func (m *metadataClient) sendRequest(ctx context.Context, path string, payload []byte, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", m.ingestURL+path, bytes.NewReader(payload))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
 
+	setHeaders(req.Header, m.headers)
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+m.apiKey)
+	if id, ok := CorrelationIDFromUploadContext(ctx); ok {
+		req.Header.Set(CorrelationIDHeader, id)
+	}
 
 	resp, err := m.client.Do(req)
 	if err != nil {
@@ -77,15 +131,142 @@ func (m *metadataClient) sendRequest(ctx context.Context, payload []byte) error
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusTooManyRequests {
+		err := responseError("unexpected status code", resp)
+		if wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			return &retryAfterError{err: err, wait: wait}
+		}
+		return err
+	}
+
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return responseError("unexpected status code", resp)
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
 	}
 
 	return nil
 }
 
+// newIngestClient creates a metadata client for the profiler's ingest API
+func (p *Profiler) newIngestClient() *metadataClient {
+	client := newMetadataClient(p.config.IngestURL, p.config.APIKey)
+	client.env = p.config.Env
+	client.headers = p.config.Headers
+	if p.ingestHTTPClient != nil {
+		client.client = p.ingestHTTPClient
+	}
+	return client
+}
+
 // Update the Profiler to use the metadata client
 func (p *Profiler) sendMetadata(ctx context.Context, metadata map[string]string) error {
-	client := newMetadataClient(p.config.IngestURL, p.config.APIKey)
-	return client.sendMetadata(ctx, metadata)
+	return p.newIngestClient().sendMetadata(ctx, metadata)
+}
+
+// queueMetadata keeps metadata whose send failed for a later retry,
+// dropping the oldest entry when the queue is full.
+func (p *Profiler) queueMetadata(metadata map[string]string) {
+	p.metadataMu.Lock()
+	defer p.metadataMu.Unlock()
+
+	if len(p.pendingMetadata) >= maxPendingMetadata {
+		p.pendingMetadata = p.pendingMetadata[1:]
+	}
+	p.pendingMetadata = append(p.pendingMetadata, metadata)
+}
+
+// retryPendingMetadata resends queued metadata for profiles that were
+// already uploaded, keeping any that still fail.
+func (p *Profiler) retryPendingMetadata(ctx context.Context) {
+	p.metadataMu.Lock()
+	pending := p.pendingMetadata
+	p.pendingMetadata = nil
+	p.metadataMu.Unlock()
+
+	var failed []map[string]string
+	for i, metadata := range pending {
+		if err := p.sendMetadata(withCorrelationID(ctx, metadata["correlation_id"]), metadata); err != nil {
+			// The ingest API is still unavailable, so keep the rest for later
+			failed = append(failed, pending[i:]...)
+			break
+		}
+	}
+	if len(failed) == 0 {
+		return
+	}
+
+	p.metadataMu.Lock()
+	defer p.metadataMu.Unlock()
+	p.pendingMetadata = append(failed, p.pendingMetadata...)
+	if extra := len(p.pendingMetadata) - maxPendingMetadata; extra > 0 {
+		p.pendingMetadata = p.pendingMetadata[extra:]
+	}
+}
+
+// typeMetadata returns metadata annotations that only apply to the given
+// profile type.
+func (p *Profiler) typeMetadata(profileType string) map[string]string {
+	if profileType != string(profileTypeMutex) {
+		return nil
+	}
+
+	// A negative rate reads the current fraction without changing it
+	annotations := map[string]string{
+		"mutex_fraction": strconv.Itoa(runtime.SetMutexProfileFraction(-1)),
+	}
+
+	if len(p.config.MutexLockNames) > 0 {
+		if names, err := json.Marshal(p.config.MutexLockNames); err == nil {
+			annotations["mutex_lock_names"] = string(names)
+		}
+	}
+
+	return annotations
+}
+
+// profileTags returns the tags attached to every profile, bounded by MaxTags
+// and with RedactTags values hashed. Configured tags take precedence over
+// container and deploy metadata.
+func (p *Profiler) profileTags() map[string]string {
+	deploy := p.deployMetadata()
+	if p.containerID == "" && len(deploy) == 0 {
+		return p.redactTags(limitTags(p.config.Tags, p.config.MaxTags))
+	}
+
+	tags := make(map[string]string, len(p.config.Tags)+len(deploy)+1)
+	for k, v := range deploy {
+		tags[k] = v
+	}
+	for k, v := range p.config.Tags {
+		tags[k] = v
+	}
+	if _, ok := tags["container_id"]; !ok && p.containerID != "" {
+		tags["container_id"] = p.containerID
+	}
+	return p.redactTags(limitTags(tags, p.config.MaxTags))
+}
+
+// limitTags keeps the first max tags in key order so the retained subset is
+// deterministic. A max of zero or less means no limit.
+func limitTags(tags map[string]string, max int) map[string]string {
+	if max <= 0 || len(tags) <= max {
+		return tags
+	}
+
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	limited := make(map[string]string, max)
+	for _, k := range keys[:max] {
+		limited[k] = tags[k]
+	}
+	return limited
 }
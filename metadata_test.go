@@ -2,8 +2,14 @@ package pprofio
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sync"
 	"testing"
 	"time"
 )
@@ -157,3 +163,376 @@ func TestProfilerSendMetadata(t *testing.T) {
 		t.Error("Metadata was not received by the server")
 	}
 }
+
+func TestLimitTags(t *testing.T) {
+	tags := map[string]string{
+		"region":  "eu-west-1",
+		"env":     "prod",
+		"version": "1.2.3",
+		"az":      "a",
+		"team":    "core",
+	}
+
+	limited := limitTags(tags, 3)
+	want := map[string]string{"az": "a", "env": "prod", "region": "eu-west-1"}
+
+	if len(limited) != len(want) {
+		t.Fatalf("limitTags() kept %d tags, want %d", len(limited), len(want))
+	}
+	for k, v := range want {
+		if limited[k] != v {
+			t.Errorf("limitTags()[%q] = %q, want %q", k, limited[k], v)
+		}
+	}
+
+	if got := limitTags(tags, 0); len(got) != len(tags) {
+		t.Errorf("limitTags() with no limit kept %d tags, want %d", len(got), len(tags))
+	}
+}
+
+func TestUploadProfileEnforcesMaxTags(t *testing.T) {
+	var received map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	p, err := newProfiler(Config{
+		APIKey:      "test-key",
+		IngestURL:   server.URL,
+		Storage:     &captureStorage{},
+		ServiceName: "test-service",
+		Tags:        map[string]string{"b": "2", "a": "1", "c": "3"},
+		MaxTags:     2,
+	})
+	if err != nil {
+		t.Fatalf("newProfiler() error = %v", err)
+	}
+
+	tmpFile, err := os.CreateTemp("", "memory.pprof")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := p.uploadProfile(context.Background(), tmpFile.Name(), "memory"); err != nil {
+		t.Fatalf("uploadProfile() error = %v", err)
+	}
+
+	if received["a"] != "1" || received["b"] != "2" {
+		t.Errorf("Expected tags a and b to be kept, got %v", received)
+	}
+	if _, ok := received["c"]; ok {
+		t.Error("Tag c should have been dropped by MaxTags")
+	}
+}
+
+func TestNewDefaultsVersionFromBuildInfo(t *testing.T) {
+	original := readBuildInfo
+	defer func() { readBuildInfo = original }()
+
+	readBuildInfo = func() (*debug.BuildInfo, bool) {
+		return &debug.BuildInfo{Main: debug.Module{Path: "example.com/app", Version: "v1.4.2"}}, true
+	}
+
+	p, err := New(Config{
+		APIKey:      "test-key",
+		IngestURL:   "https://api.pprofio.com",
+		ServiceName: "test-service",
+		Tags:        map[string]string{"env": "test"},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if p.config.Tags["version"] != "v1.4.2" {
+		t.Errorf("version tag = %q, want %q", p.config.Tags["version"], "v1.4.2")
+	}
+
+	p, err = New(Config{
+		APIKey:      "test-key",
+		IngestURL:   "https://api.pprofio.com",
+		ServiceName: "test-service",
+		Tags:        map[string]string{"version": "custom"},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if p.config.Tags["version"] != "custom" {
+		t.Errorf("version tag = %q, want user-provided %q", p.config.Tags["version"], "custom")
+	}
+}
+
+func TestMutexProfileMetadataAnnotations(t *testing.T) {
+	var received map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	previous := runtime.SetMutexProfileFraction(7)
+	defer runtime.SetMutexProfileFraction(previous)
+
+	lockNames := map[string]string{"cache.(*Store).mu": "store cache lock"}
+	p, err := newProfiler(Config{
+		APIKey:         "test-key",
+		IngestURL:      server.URL,
+		Storage:        &captureStorage{},
+		ServiceName:    "test-service",
+		EnableMutex:    true,
+		MutexLockNames: lockNames,
+	})
+	if err != nil {
+		t.Fatalf("newProfiler() error = %v", err)
+	}
+
+	if _, err := p.collectMutex(context.Background()); err != nil {
+		t.Fatalf("collectMutex() error = %v", err)
+	}
+
+	if received["mutex_fraction"] != "7" {
+		t.Errorf("mutex_fraction = %q, want %q", received["mutex_fraction"], "7")
+	}
+
+	var names map[string]string
+	if err := json.Unmarshal([]byte(received["mutex_lock_names"]), &names); err != nil {
+		t.Fatalf("mutex_lock_names is not valid JSON: %v", err)
+	}
+	if names["cache.(*Store).mu"] != "store cache lock" {
+		t.Errorf("mutex_lock_names = %v, want %v", names, lockNames)
+	}
+}
+
+func TestTriggerMetadata(t *testing.T) {
+	var mu sync.Mutex
+	var triggers []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var metadata map[string]string
+		json.NewDecoder(r.Body).Decode(&metadata)
+
+		mu.Lock()
+		triggers = append(triggers, metadata["trigger"])
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       server.URL,
+		SampleRate:      time.Minute,
+		Storage:         &captureStorage{},
+		ServiceName:     "test-service",
+		EnableGoroutine: true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	p.Flush(context.Background())
+
+	// Starting collects once immediately on the schedule
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(triggers)
+		mu.Unlock()
+		if n >= 2 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	p.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(triggers) != 2 {
+		t.Fatalf("Received %d metadata payloads, want 2", len(triggers))
+	}
+	if triggers[0] != "manual" {
+		t.Errorf("Flush trigger = %q, want %q", triggers[0], "manual")
+	}
+	if triggers[1] != "scheduled" {
+		t.Errorf("Scheduled trigger = %q, want %q", triggers[1], "scheduled")
+	}
+}
+
+func TestRetentionMetadata(t *testing.T) {
+	var mu sync.Mutex
+	retention := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var metadata map[string]string
+		json.NewDecoder(r.Body).Decode(&metadata)
+
+		mu.Lock()
+		retention[metadata["trigger"]] = metadata["retention"]
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name          string
+		byTrigger     map[string]string
+		wantManual    string
+		wantScheduled string
+	}{
+		{"defaults", nil, RetentionIncident, RetentionDefault},
+		{"configured", map[string]string{"manual": "90d", "scheduled": "7d"}, "90d", "7d"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			retention = map[string]string{}
+			mu.Unlock()
+
+			p, err := New(Config{
+				APIKey:             "test-key",
+				IngestURL:          server.URL,
+				SampleRate:         time.Minute,
+				Storage:            &captureStorage{},
+				ServiceName:        "test-service",
+				EnableGoroutine:    true,
+				RetentionByTrigger: tt.byTrigger,
+			})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			p.Flush(context.Background())
+			if err := p.Start(context.Background()); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			waitFor(t, func() bool {
+				mu.Lock()
+				defer mu.Unlock()
+				_, ok := retention["scheduled"]
+				return ok
+			})
+			p.Stop()
+
+			mu.Lock()
+			defer mu.Unlock()
+			if got := retention["manual"]; got != tt.wantManual {
+				t.Errorf("Flush retention = %q, want %q", got, tt.wantManual)
+			}
+			if got := retention["scheduled"]; got != tt.wantScheduled {
+				t.Errorf("Scheduled retention = %q, want %q", got, tt.wantScheduled)
+			}
+		})
+	}
+}
+
+func TestFailedMetadataRetriedWithoutReupload(t *testing.T) {
+	var mu sync.Mutex
+	var requests int
+	var registered []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		// Fail every attempt of the first two sends
+		requests++
+		if requests <= 6 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var metadata map[string]string
+		json.NewDecoder(r.Body).Decode(&metadata)
+		registered = append(registered, metadata["profile_url"])
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	storage := &captureStorage{}
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       server.URL,
+		Storage:         storage,
+		ServiceName:     "test-service",
+		EnableGoroutine: true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	result, err := p.collectGoroutine(context.Background())
+	if err == nil {
+		t.Fatal("Expected metadata failure to be reported")
+	}
+
+	// First retry still fails, the second registers the profile
+	p.retryPendingMetadata(context.Background())
+	p.retryPendingMetadata(context.Background())
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(registered) != 1 || registered[0] != result.URL {
+		t.Errorf("Registered profile URLs = %v, want [%s]", registered, result.URL)
+	}
+
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	if len(storage.uploads) != 1 {
+		t.Errorf("Expected 1 upload, got %d", len(storage.uploads))
+	}
+
+	p.metadataMu.Lock()
+	defer p.metadataMu.Unlock()
+	if len(p.pendingMetadata) != 0 {
+		t.Errorf("Expected empty metadata queue, got %d entries", len(p.pendingMetadata))
+	}
+}
+
+func TestMetadataClientHonorsRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		header func() string
+	}{
+		{"seconds", func() string { return "1" }},
+		{"http-date", func() string { return time.Now().Add(2 * time.Second).UTC().Format(http.TimeFormat) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, gap := retryAfterServer(t, tt.header)
+			client := newMetadataClient(server.URL, "test-key")
+
+			if err := client.sendMetadata(context.Background(), map[string]string{"profile_id": "p1"}); err != nil {
+				t.Fatalf("sendMetadata() error = %v", err)
+			}
+			if d := gap(); d < 900*time.Millisecond {
+				t.Errorf("Retried after %v, want at least the Retry-After delay", d)
+			}
+		})
+	}
+}
+
+func TestMetadataClientBackoffReturnsOnCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	client := newMetadataClient(server.URL, "test-key")
+	client.maxBackoff = time.Minute
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	err := client.sendMetadata(ctx, map[string]string{"profile_id": "p1"})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("sendMetadata() error = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("sendMetadata() returned after %v, want prompt return on cancel", elapsed)
+	}
+}
@@ -0,0 +1,38 @@
+package pprofio
+
+import (
+	"context"
+	"net/http"
+	"runtime/pprof"
+)
+
+// RouteFunc returns the route template that matched r (e.g. "/users/{id}"),
+// or an empty string if it is unknown. Implementations typically ask the
+// router in use for the matched pattern.
+type RouteFunc func(r *http.Request) string
+
+// Middleware wraps next so that each request is recorded as a custom span and
+// its CPU samples carry an "http_route" pprof label.
+//
+// When route is non-nil and returns a template, that template is used as the
+// span name and label instead of the raw URL path, keeping cardinality low
+// for paths containing IDs.
+func (p *Profiler) Middleware(next http.Handler, route RouteFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := ""
+		if route != nil {
+			name = route(r)
+		}
+		if name == "" {
+			name = r.URL.Path
+		}
+
+		ctx := WithProfiler(r.Context(), p)
+		ctx, span := StartSpan(ctx, name, "method", r.Method, "http_route", name)
+		defer span.End()
+
+		pprof.Do(ctx, pprof.Labels("http_route", name), func(ctx context.Context) {
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	})
+}
@@ -0,0 +1,61 @@
+package pprofio
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"runtime/pprof"
+	"strings"
+	"testing"
+)
+
+func TestMiddlewareUsesRouteTemplate(t *testing.T) {
+	p, err := newProfiler(Config{
+		APIKey:       "test-key",
+		IngestURL:    "https://api.pprofio.com",
+		Storage:      &captureStorage{},
+		ServiceName:  "test-service",
+		EnableCustom: true,
+	})
+	if err != nil {
+		t.Fatalf("newProfiler() error = %v", err)
+	}
+
+	route := func(r *http.Request) string {
+		if strings.HasPrefix(r.URL.Path, "/users/") {
+			return "/users/{id}"
+		}
+		return ""
+	}
+
+	var labels []string
+	handler := p.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		label, _ := pprof.Label(r.Context(), "http_route")
+		labels = append(labels, label)
+	}), route)
+
+	for _, path := range []string{"/users/1", "/users/2"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	for i, label := range labels {
+		if label != "/users/{id}" {
+			t.Errorf("request %d http_route label = %q, want %q", i, label, "/users/{id}")
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		span := <-p.spanCh
+		if span.Name != "/users/{id}" {
+			t.Errorf("span %d name = %q, want %q", i, span.Name, "/users/{id}")
+		}
+		if span.Tags["http_route"] != "/users/{id}" {
+			t.Errorf("span %d http_route tag = %q, want %q", i, span.Tags["http_route"], "/users/{id}")
+		}
+	}
+
+	// Requests without a template fall back to the raw path
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+	if span := <-p.spanCh; span.Name != "/health" {
+		t.Errorf("fallback span name = %q, want %q", span.Name, "/health")
+	}
+}
@@ -0,0 +1,68 @@
+// Package otelbridge mirrors pprofio custom spans as OpenTelemetry spans, so
+// they show up in a tracing UI next to the rest of a request's trace.
+//
+//	cfg.SpanTracer = otelbridge.New(otel.GetTracerProvider())
+//
+// Each span started with pprofio.StartSpan on a context attached with
+// pprofio.WithProfiler starts an OpenTelemetry span with the same name and
+// start time, carrying its tags as attributes. The context returned by
+// StartSpan holds the OpenTelemetry span, so spans nest as usual. End ends it
+// at the same time.
+package otelbridge
+
+import (
+	"context"
+	"sort"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/pprofio/pprofio"
+)
+
+// instrumentationName identifies the bridge's tracer
+const instrumentationName = "github.com/pprofio/pprofio/otelbridge"
+
+// Tracer is a pprofio.SpanTracer starting OpenTelemetry spans
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+// New returns a Tracer creating spans with a tracer from tp.
+func New(tp trace.TracerProvider) *Tracer {
+	return &Tracer{tracer: tp.Tracer(instrumentationName)}
+}
+
+// StartSpan implements pprofio.SpanTracer.
+func (t *Tracer) StartSpan(ctx context.Context, span *pprofio.Span) (context.Context, func()) {
+	ctx, otelSpan := t.tracer.Start(ctx, span.Name,
+		trace.WithTimestamp(span.Start),
+		trace.WithAttributes(tagAttributes(span.Tags)...))
+
+	return ctx, func() {
+		if span.Unit != "" {
+			otelSpan.SetAttributes(
+				attribute.Int64("pprofio.value", span.Value),
+				attribute.String("pprofio.unit", span.Unit))
+		}
+		if span.Weight != 0 {
+			otelSpan.SetAttributes(attribute.Float64("pprofio.weight", span.Weight))
+		}
+		otelSpan.End(trace.WithTimestamp(span.Start.Add(span.Duration)))
+	}
+}
+
+// tagAttributes converts span tags to attributes in key order
+func tagAttributes(tags map[string]string) []attribute.KeyValue {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	attrs := make([]attribute.KeyValue, 0, len(keys))
+	for _, k := range keys {
+		attrs = append(attrs, attribute.String(k, tags[k]))
+	}
+	return attrs
+}
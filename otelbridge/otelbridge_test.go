@@ -0,0 +1,93 @@
+package otelbridge
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/pprofio/pprofio"
+)
+
+func newProfiler(t *testing.T, tracer pprofio.SpanTracer) *pprofio.Profiler {
+	t.Helper()
+	p, err := pprofio.New(pprofio.Config{
+		APIKey:       "test-key",
+		IngestURL:    "http://localhost",
+		SampleRate:   time.Hour,
+		Storage:      &pprofio.FileStorage{Directory: t.TempDir()},
+		ServiceName:  "test-service",
+		EnableCustom: true,
+		SpanTracer:   tracer,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return p
+}
+
+func TestSpansRecordedInOpenTelemetry(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer tp.Shutdown(context.Background())
+
+	p := newProfiler(t, New(tp))
+	ctx := pprofio.WithProfiler(context.Background(), p)
+
+	ctx, parent := pprofio.StartSpan(ctx, "handle_request", "route", "/checkout")
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		t.Fatal("StartSpan() context should carry the OpenTelemetry span")
+	}
+	_, child := pprofio.StartSpan(ctx, "query_db")
+	child.SetValue(42, "rows")
+	child.End()
+	parent.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("Recorded %d spans, want 2", len(spans))
+	}
+	childStub, parentStub := spans[0], spans[1]
+
+	if parentStub.Name != "handle_request" {
+		t.Errorf("Span name = %q, want %q", parentStub.Name, "handle_request")
+	}
+	if !hasAttribute(parentStub.Attributes, attribute.String("route", "/checkout")) {
+		t.Errorf("Span attributes = %v, want route tag", parentStub.Attributes)
+	}
+	if !parentStub.StartTime.Equal(parent.Start) || !parentStub.EndTime.Equal(parent.Start.Add(parent.Duration)) {
+		t.Errorf("Span times = %v-%v, want %v for %v", parentStub.StartTime, parentStub.EndTime, parent.Start, parent.Duration)
+	}
+
+	if childStub.Parent.SpanID() != parentStub.SpanContext.SpanID() {
+		t.Error("Nested pprofio span should be a child of the enclosing OpenTelemetry span")
+	}
+	if !hasAttribute(childStub.Attributes, attribute.Int64("pprofio.value", 42)) ||
+		!hasAttribute(childStub.Attributes, attribute.String("pprofio.unit", "rows")) {
+		t.Errorf("Span attributes = %v, want value and unit", childStub.Attributes)
+	}
+}
+
+func TestSpansWithoutTracerUnchanged(t *testing.T) {
+	p := newProfiler(t, nil)
+	ctx := pprofio.WithProfiler(context.Background(), p)
+
+	spanCtx, span := pprofio.StartSpan(ctx, "handle_request")
+	span.End()
+	if spanCtx != ctx {
+		t.Error("StartSpan() without a SpanTracer should return the context unchanged")
+	}
+}
+
+func hasAttribute(attrs []attribute.KeyValue, want attribute.KeyValue) bool {
+	for _, attr := range attrs {
+		if attr == want {
+			return true
+		}
+	}
+	return false
+}
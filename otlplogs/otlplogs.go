@@ -0,0 +1,199 @@
+// Package otlplogs provides a pprofio Storage that exports each profile as an
+// OTLP log record, for observability backends that accept OTLP logs but have
+// no profiles pipeline.
+//
+// Records are sent with the OTLP/HTTP JSON encoding, so the package needs no
+// dependencies beyond the standard library.
+package otlplogs
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pprofio/pprofio"
+)
+
+// DefaultPath is the OTLP/HTTP logs endpoint path
+const DefaultPath = "/v1/logs"
+
+// Storage exports profiles as OTLP log records. The record body holds the
+// base64-encoded profile; the service, profile type, size and tags are
+// carried as attributes.
+type Storage struct {
+	// Endpoint is the full logs URL, e.g. http://collector:4318/v1/logs
+	Endpoint string
+
+	// ServiceName is reported as the service.name resource attribute
+	ServiceName string
+
+	// Headers are added to every request, e.g. for backend authentication
+	Headers map[string]string
+
+	Client *http.Client
+}
+
+// New creates a Storage that posts to the collector at baseURL
+func New(baseURL, serviceName string) (*Storage, error) {
+	if baseURL == "" {
+		return nil, errors.New("baseURL is required")
+	}
+	if serviceName == "" {
+		return nil, errors.New("serviceName is required")
+	}
+
+	return &Storage{
+		Endpoint:    strings.TrimSuffix(baseURL, "/") + DefaultPath,
+		ServiceName: serviceName,
+		Client:      &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// The types below mirror the OTLP JSON encoding of ExportLogsServiceRequest
+
+type exportRequest struct {
+	ResourceLogs []resourceLogs `json:"resourceLogs"`
+}
+
+type resourceLogs struct {
+	Resource  resource    `json:"resource"`
+	ScopeLogs []scopeLogs `json:"scopeLogs"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeLogs struct {
+	Scope      scope       `json:"scope"`
+	LogRecords []logRecord `json:"logRecords"`
+}
+
+type scope struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type logRecord struct {
+	TimeUnixNano         string     `json:"timeUnixNano"`
+	ObservedTimeUnixNano string     `json:"observedTimeUnixNano"`
+	SeverityNumber       int        `json:"severityNumber"`
+	SeverityText         string     `json:"severityText"`
+	Body                 anyValue   `json:"body"`
+	Attributes           []keyValue `json:"attributes"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+// severityInfo is the OTLP SeverityNumber for INFO
+const severityInfo = 9
+
+// Upload exports the profile as a single log record and returns the endpoint
+// it was sent to
+func (s *Storage) Upload(ctx context.Context, filePath string) (pprofio.UploadResult, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return pprofio.UploadResult{}, fmt.Errorf("failed to read profile file: %w", err)
+	}
+
+	payload, err := json.Marshal(s.exportRequest(ctx, filePath, data, time.Now()))
+	if err != nil {
+		return pprofio.UploadResult{}, fmt.Errorf("failed to marshal log record: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.Endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return pprofio.UploadResult{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.Headers {
+		req.Header.Set(k, v)
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return pprofio.UploadResult{}, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return pprofio.UploadResult{}, fmt.Errorf("unexpected status code: %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return pprofio.UploadResult{ProfileURL: s.Endpoint, Size: int64(len(data))}, nil
+}
+
+// exportRequest wraps the profile in a log record with its attributes
+func (s *Storage) exportRequest(ctx context.Context, filePath string, data []byte, now time.Time) exportRequest {
+	profileType := string(pprofio.UploadProfileType(ctx, filePath))
+
+	attributes := []keyValue{
+		stringAttr("profile.type", profileType),
+		stringAttr("profile.format", "pprof"),
+		stringAttr("profile.encoding", "base64"),
+		intAttr("profile.size", int64(len(data))),
+	}
+
+	tags, _ := pprofio.TagsFromUploadContext(ctx)
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		attributes = append(attributes, stringAttr(k, tags[k]))
+	}
+
+	timestamp := strconv.FormatInt(now.UnixNano(), 10)
+	body := base64.StdEncoding.EncodeToString(data)
+
+	return exportRequest{
+		ResourceLogs: []resourceLogs{{
+			Resource: resource{Attributes: []keyValue{stringAttr("service.name", s.ServiceName)}},
+			ScopeLogs: []scopeLogs{{
+				Scope: scope{Name: "github.com/pprofio/pprofio", Version: pprofio.Version},
+				LogRecords: []logRecord{{
+					TimeUnixNano:         timestamp,
+					ObservedTimeUnixNano: timestamp,
+					SeverityNumber:       severityInfo,
+					SeverityText:         "INFO",
+					Body:                 anyValue{StringValue: &body},
+					Attributes:           attributes,
+				}},
+			}},
+		}},
+	}
+}
+
+func stringAttr(key, value string) keyValue {
+	return keyValue{Key: key, Value: anyValue{StringValue: &value}}
+}
+
+// intAttr encodes an int attribute, which OTLP JSON represents as a string
+func intAttr(key string, value int64) keyValue {
+	encoded := strconv.FormatInt(value, 10)
+	return keyValue{Key: key, Value: anyValue{IntValue: &encoded}}
+}
@@ -0,0 +1,114 @@
+package otlplogs
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/google/pprof/profile"
+
+	"github.com/pprofio/pprofio"
+)
+
+func TestStorage_ExportsProfileAsLogRecord(t *testing.T) {
+	var mu sync.Mutex
+	var requests []exportRequest
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == DefaultPath {
+			var req exportRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			mu.Lock()
+			requests = append(requests, req)
+			auth = r.Header.Get("Authorization")
+			mu.Unlock()
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	storage, err := New(server.URL, "checkout")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	storage.Headers = map[string]string{"Authorization": "Bearer otlp-token"}
+
+	p, err := pprofio.New(pprofio.Config{
+		APIKey:          "test-key",
+		IngestURL:       server.URL,
+		Storage:         storage,
+		ServiceName:     "checkout",
+		Tags:            map[string]string{"env": "prod"},
+		EnableGoroutine: true,
+	})
+	if err != nil {
+		t.Fatalf("pprofio.New() error = %v", err)
+	}
+
+	results := p.Flush(context.Background())
+	if len(results) != 1 || results[0].Err != nil {
+		t.Fatalf("Flush() = %+v", results)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) != 1 {
+		t.Fatalf("Expected 1 export request, got %d", len(requests))
+	}
+	if auth != "Bearer otlp-token" {
+		t.Errorf("Authorization = %q, want %q", auth, "Bearer otlp-token")
+	}
+
+	rl := requests[0].ResourceLogs[0]
+	if attrs := attributeMap(rl.Resource.Attributes); attrs["service.name"] != "checkout" {
+		t.Errorf("service.name = %q, want %q", attrs["service.name"], "checkout")
+	}
+
+	record := rl.ScopeLogs[0].LogRecords[0]
+	attrs := attributeMap(record.Attributes)
+	for key, want := range map[string]string{
+		"profile.type":     "goroutine",
+		"profile.format":   "pprof",
+		"profile.encoding": "base64",
+		"env":              "prod",
+	} {
+		if attrs[key] != want {
+			t.Errorf("attribute %s = %q, want %q", key, attrs[key], want)
+		}
+	}
+
+	if record.Body.StringValue == nil {
+		t.Fatal("Log record body is empty")
+	}
+	data, err := base64.StdEncoding.DecodeString(*record.Body.StringValue)
+	if err != nil {
+		t.Fatalf("Body is not base64: %v", err)
+	}
+	if attrs["profile.size"] == "" {
+		t.Error("Missing profile.size attribute")
+	}
+	if _, err := profile.Parse(bytes.NewReader(data)); err != nil {
+		t.Errorf("Body does not decode to a pprof profile: %v", err)
+	}
+}
+
+func attributeMap(kvs []keyValue) map[string]string {
+	attrs := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		switch {
+		case kv.Value.StringValue != nil:
+			attrs[kv.Key] = *kv.Value.StringValue
+		case kv.Value.IntValue != nil:
+			attrs[kv.Key] = *kv.Value.IntValue
+		}
+	}
+	return attrs
+}
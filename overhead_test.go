@@ -0,0 +1,80 @@
+package pprofio
+
+import (
+	"context"
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pprofio/pprofio/overheadtest"
+)
+
+// defaultOverheadThresholds are loose enough for shared CI runners; set
+// PPROFIO_MAX_CPU_OVERHEAD or PPROFIO_MAX_ALLOC_OVERHEAD to tighten them
+var defaultOverheadThresholds = overheadtest.Thresholds{
+	MaxCPUOverhead:   0.25,
+	MaxAllocOverhead: 0.25,
+}
+
+// overheadWorkload hashes and allocates for a fixed amount of work
+func overheadWorkload() {
+	var sink [][]byte
+	data := make([]byte, 4096)
+	for i := 0; i < 20000; i++ {
+		sum := sha256.Sum256(data)
+		data[i%len(data)] = sum[0]
+		if i%10 == 0 {
+			sink = append(sink, make([]byte, 256))
+		}
+	}
+	_ = sink
+}
+
+func TestCollectionOverhead(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping overhead measurement in short mode")
+	}
+
+	thresholds, err := overheadtest.ThresholdsFromEnv(defaultOverheadThresholds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	result, err := overheadtest.Run(overheadtest.Options{
+		Workload: overheadWorkload,
+		Start: func() (func(), error) {
+			p, err := New(Config{
+				APIKey:          "test-key",
+				IngestURL:       server.URL,
+				Storage:         discardStorage{},
+				ServiceName:     "overhead-test",
+				SampleRate:      time.Hour,
+				ProfileDuration: time.Hour,
+				EnableCPU:       true,
+				EnableGoroutine: true,
+			})
+			if err != nil {
+				return nil, err
+			}
+			if err := p.Start(context.Background()); err != nil {
+				return nil, err
+			}
+			return p.Stop, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	t.Log(result)
+	if err := result.Check(thresholds); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+
+package overheadtest
+
+import "time"
+
+// processCPUTime is unavailable on this platform, so Measure falls back to
+// wall time
+func processCPUTime() time.Duration {
+	return 0
+}
@@ -0,0 +1,180 @@
+// Package overheadtest measures the CPU and allocation overhead an agent adds
+// to a fixed workload, so tests can guard the profiler's low-overhead promise
+// against regressions.
+//
+// The workload is run alternately without and with the agent and the fastest
+// run of each is compared, which filters out most scheduling noise. Thresholds
+// can be loosened through environment variables on noisy CI machines.
+package overheadtest
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// Environment variables overriding the thresholds passed to ThresholdsFromEnv
+const (
+	EnvMaxCPUOverhead   = "PPROFIO_MAX_CPU_OVERHEAD"
+	EnvMaxAllocOverhead = "PPROFIO_MAX_ALLOC_OVERHEAD"
+)
+
+// DefaultRuns is the number of runs with and without the agent
+const DefaultRuns = 5
+
+// Measurement is the cost of one run of the workload
+type Measurement struct {
+	Wall   time.Duration
+	CPU    time.Duration
+	Allocs uint64
+	Bytes  uint64
+}
+
+// Result compares the fastest runs of the workload without and with the agent
+type Result struct {
+	Baseline Measurement
+	Profiled Measurement
+}
+
+// CPUOverhead is the fraction of CPU time added by the agent
+func (r Result) CPUOverhead() float64 {
+	return overhead(float64(r.Baseline.CPU), float64(r.Profiled.CPU))
+}
+
+// AllocOverhead is the fraction of heap allocations added by the agent
+func (r Result) AllocOverhead() float64 {
+	return overhead(float64(r.Baseline.Allocs), float64(r.Profiled.Allocs))
+}
+
+func (r Result) String() string {
+	return fmt.Sprintf("cpu %v -> %v (%+.2f%%), allocs %d -> %d (%+.2f%%)",
+		r.Baseline.CPU, r.Profiled.CPU, r.CPUOverhead()*100,
+		r.Baseline.Allocs, r.Profiled.Allocs, r.AllocOverhead()*100)
+}
+
+// Check returns an error if either overhead exceeds its threshold. A
+// threshold of zero or less is not checked.
+func (r Result) Check(t Thresholds) error {
+	if t.MaxCPUOverhead > 0 && r.CPUOverhead() > t.MaxCPUOverhead {
+		return fmt.Errorf("CPU overhead %.2f%% exceeds %.2f%%: %s", r.CPUOverhead()*100, t.MaxCPUOverhead*100, r)
+	}
+	if t.MaxAllocOverhead > 0 && r.AllocOverhead() > t.MaxAllocOverhead {
+		return fmt.Errorf("allocation overhead %.2f%% exceeds %.2f%%: %s", r.AllocOverhead()*100, t.MaxAllocOverhead*100, r)
+	}
+	return nil
+}
+
+func overhead(baseline, profiled float64) float64 {
+	if baseline <= 0 {
+		return 0
+	}
+	return (profiled - baseline) / baseline
+}
+
+// Thresholds bounds the overhead allowed by Check, as fractions (0.05 = 5%)
+type Thresholds struct {
+	MaxCPUOverhead   float64
+	MaxAllocOverhead float64
+}
+
+// ThresholdsFromEnv returns the given thresholds, overridden by
+// EnvMaxCPUOverhead and EnvMaxAllocOverhead when set.
+func ThresholdsFromEnv(defaults Thresholds) (Thresholds, error) {
+	t := defaults
+	for env, field := range map[string]*float64{
+		EnvMaxCPUOverhead:   &t.MaxCPUOverhead,
+		EnvMaxAllocOverhead: &t.MaxAllocOverhead,
+	} {
+		value := os.Getenv(env)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return defaults, fmt.Errorf("invalid %s: %w", env, err)
+		}
+		*field = parsed
+	}
+	return t, nil
+}
+
+// Options configures Run
+type Options struct {
+	// Workload is the fixed amount of work measured in each run
+	Workload func()
+
+	// Start starts the agent and returns a function stopping it. The agent
+	// runs only around the profiled runs.
+	Start func() (stop func(), err error)
+
+	// Runs is the number of runs with and without the agent, defaulting to
+	// DefaultRuns
+	Runs int
+}
+
+// Run measures the workload alternately without and with the agent and
+// returns the fastest run of each.
+func Run(opts Options) (Result, error) {
+	if opts.Workload == nil || opts.Start == nil {
+		return Result{}, errors.New("Workload and Start are required")
+	}
+	runs := opts.Runs
+	if runs <= 0 {
+		runs = DefaultRuns
+	}
+
+	// Warm up so one-off costs do not land on the first baseline run
+	opts.Workload()
+
+	var result Result
+	for i := 0; i < runs; i++ {
+		baseline := Measure(opts.Workload)
+
+		stop, err := opts.Start()
+		if err != nil {
+			return Result{}, fmt.Errorf("failed to start agent: %w", err)
+		}
+		profiled := Measure(opts.Workload)
+		stop()
+
+		if i == 0 || baseline.CPU < result.Baseline.CPU {
+			result.Baseline = baseline
+		}
+		if i == 0 || profiled.CPU < result.Profiled.CPU {
+			result.Profiled = profiled
+		}
+	}
+	return result, nil
+}
+
+// Measure runs fn once and reports its wall time, the process CPU time spent
+// meanwhile, and the heap allocations made by the whole process.
+func Measure(fn func()) Measurement {
+	runtime.GC()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	startCPU := processCPUTime()
+	start := time.Now()
+
+	fn()
+
+	wall := time.Since(start)
+	cpu := processCPUTime() - startCPU
+	runtime.ReadMemStats(&after)
+
+	// Without a CPU clock, wall time is the best available approximation
+	if cpu <= 0 {
+		cpu = wall
+	}
+
+	return Measurement{
+		Wall:   wall,
+		CPU:    cpu,
+		Allocs: after.Mallocs - before.Mallocs,
+		Bytes:  after.TotalAlloc - before.TotalAlloc,
+	}
+}
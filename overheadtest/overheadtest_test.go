@@ -0,0 +1,79 @@
+package overheadtest
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	result := Result{
+		Baseline: Measurement{CPU: 100 * time.Millisecond, Allocs: 1000},
+		Profiled: Measurement{CPU: 103 * time.Millisecond, Allocs: 1200},
+	}
+
+	if err := result.Check(Thresholds{MaxCPUOverhead: 0.05, MaxAllocOverhead: 0.25}); err != nil {
+		t.Errorf("Check() error = %v", err)
+	}
+	if err := result.Check(Thresholds{MaxCPUOverhead: 0.01}); err == nil {
+		t.Error("Check() should fail when CPU overhead exceeds the threshold")
+	}
+	if err := result.Check(Thresholds{MaxAllocOverhead: 0.1}); err == nil {
+		t.Error("Check() should fail when allocation overhead exceeds the threshold")
+	}
+	if err := result.Check(Thresholds{}); err != nil {
+		t.Errorf("Check() with zero thresholds error = %v", err)
+	}
+}
+
+func TestThresholdsFromEnv(t *testing.T) {
+	t.Setenv(EnvMaxCPUOverhead, "0.2")
+
+	got, err := ThresholdsFromEnv(Thresholds{MaxCPUOverhead: 0.05, MaxAllocOverhead: 0.1})
+	if err != nil {
+		t.Fatalf("ThresholdsFromEnv() error = %v", err)
+	}
+	if got.MaxCPUOverhead != 0.2 || got.MaxAllocOverhead != 0.1 {
+		t.Errorf("ThresholdsFromEnv() = %+v", got)
+	}
+
+	t.Setenv(EnvMaxAllocOverhead, "lots")
+	if _, err := ThresholdsFromEnv(Thresholds{}); err == nil {
+		t.Error("ThresholdsFromEnv() should reject an invalid value")
+	}
+}
+
+func TestRunStartsAgentAroundProfiledRuns(t *testing.T) {
+	var running bool
+	var profiledRuns, baselineRuns int
+
+	_, err := Run(Options{
+		Runs: 3,
+		Workload: func() {
+			if running {
+				profiledRuns++
+			} else {
+				baselineRuns++
+			}
+		},
+		Start: func() (func(), error) {
+			running = true
+			return func() { running = false }, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	// One extra baseline run warms up the workload
+	if profiledRuns != 3 || baselineRuns != 4 {
+		t.Errorf("profiled runs = %d, baseline runs = %d, want 3 and 4", profiledRuns, baselineRuns)
+	}
+
+	_, err = Run(Options{
+		Workload: func() {},
+		Start:    func() (func(), error) { return nil, errors.New("no agent") },
+	})
+	if err == nil {
+		t.Error("Run() should return the error from Start")
+	}
+}
@@ -0,0 +1,67 @@
+package pprofio
+
+import (
+	"context"
+	"time"
+)
+
+// Pause stops scheduled collections until Resume is called, keeping the
+// profiler's goroutines and runtime settings in place. A collection already
+// in progress, such as a CPU profile, is allowed to finish. Flush and custom
+// spans are unaffected.
+func (p *Profiler) Pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.scheduleMu.Lock()
+	defer p.scheduleMu.Unlock()
+
+	if p.resumeCh == nil {
+		p.resumeCh = make(chan struct{})
+	}
+}
+
+// Resume restarts scheduled collections after Pause. Each collection loop
+// starts a fresh interval from the moment of resuming.
+func (p *Profiler) Resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.scheduleMu.Lock()
+	defer p.scheduleMu.Unlock()
+
+	if p.resumeCh != nil {
+		close(p.resumeCh)
+		p.resumeCh = nil
+	}
+}
+
+// pauseState returns a channel closed on Resume, or nil when not paused.
+// Collection loops read it under scheduleMu rather than mu, which Stop holds
+// while waiting for them to exit.
+func (p *Profiler) pauseState() <-chan struct{} {
+	p.scheduleMu.Lock()
+	defer p.scheduleMu.Unlock()
+	return p.resumeCh
+}
+
+// waitWhilePaused blocks a collection loop while the profiler is paused,
+// then resets its ticker so the cadence restarts cleanly. It reports false
+// if the loop should exit instead.
+func (p *Profiler) waitWhilePaused(ctx context.Context, ticker *time.Ticker, interval time.Duration) bool {
+	resumed := p.pauseState()
+	if resumed == nil {
+		return true
+	}
+
+	ticker.Stop()
+	select {
+	case <-resumed:
+		ticker.Reset(interval)
+		return true
+	case <-p.stopCh:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,99 @@
+package pprofio
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func (s *captureStorage) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.uploads)
+}
+
+func TestPauseResume(t *testing.T) {
+	metadataServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer metadataServer.Close()
+
+	storage := &captureStorage{}
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       metadataServer.URL,
+		SampleRate:      10 * time.Millisecond,
+		Storage:         storage,
+		ServiceName:     "test-service",
+		EnableGoroutine: true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer p.Stop()
+
+	waitFor(t, func() bool { return storage.count() > 0 })
+
+	p.Pause()
+	// Let a collection that was already running finish
+	time.Sleep(30 * time.Millisecond)
+	paused := storage.count()
+
+	time.Sleep(100 * time.Millisecond)
+	if got := storage.count(); got != paused {
+		t.Fatalf("%d profiles uploaded while paused", got-paused)
+	}
+
+	p.Resume()
+	waitFor(t, func() bool { return storage.count() > paused })
+}
+
+func TestPauseBeforeStartSkipsInitialCollection(t *testing.T) {
+	metadataServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer metadataServer.Close()
+
+	storage := &captureStorage{}
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       metadataServer.URL,
+		SampleRate:      10 * time.Millisecond,
+		Storage:         storage,
+		ServiceName:     "test-service",
+		EnableGoroutine: true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	p.Pause()
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	p.Stop()
+
+	if got := storage.count(); got != 0 {
+		t.Errorf("%d profiles uploaded while paused", got)
+	}
+}
+
+// waitFor polls cond until it holds or a second passes.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -6,14 +6,60 @@ package pprofio
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"net/http"
+	"os"
 	"runtime"
+	"strings"
+	"sync"
 	"time"
 )
 
 // Version is the current package version
 const Version = "0.1.0"
 
+// The Go runtime supports a single CPU profile at a time, so only one running
+// profiler in the process may collect CPU profiles.
+var (
+	cpuOwnerMu sync.Mutex
+	cpuOwner   *Profiler
+)
+
+// claimCPU registers p as the process's CPU-profiling profiler.
+func (p *Profiler) claimCPU() error {
+	cpuOwnerMu.Lock()
+	defer cpuOwnerMu.Unlock()
+
+	if cpuOwner != nil && cpuOwner != p {
+		return fmt.Errorf("CPU profiling is already active in profiler for service %q; "+
+			"only one profiler per process can enable CPU profiling", cpuOwner.config.ServiceName)
+	}
+	cpuOwner = p
+	return nil
+}
+
+// releaseCPU gives up CPU profiling ownership if p holds it.
+func (p *Profiler) releaseCPU() {
+	cpuOwnerMu.Lock()
+	defer cpuOwnerMu.Unlock()
+
+	if cpuOwner == p {
+		cpuOwner = nil
+	}
+}
+
+// checkScoped rejects CPU profiles and traces on scoped profilers: both
+// start process-wide profiling, which would make the host application's own
+// StartCPUProfile or trace.Start fail.
+func (p *Profiler) checkScoped(t ProfileType) error {
+	if p.config.Scoped && (t == ProfileCPU || t == ProfileTrace) {
+		return fmt.Errorf("scoped profilers cannot collect %s profiles, which start process-wide profiling", t)
+	}
+	return nil
+}
+
 // New creates a new profiler with the provided configuration.
 // It returns an error if the configuration is invalid.
 func New(config Config) (*Profiler, error) {
@@ -34,19 +80,73 @@ func New(config Config) (*Profiler, error) {
 		config.BlockProfileRate = DefaultBlockProfileRate
 	}
 
+	if config.APIKeyFile != "" {
+		key, err := os.ReadFile(config.APIKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read API key file: %w", err)
+		}
+		config.APIKey = strings.TrimSpace(string(key))
+	}
+
+	if config.ClientCertFile != "" || config.ClientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.ClientCertFile, config.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		if config.TLSConfig == nil {
+			config.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		} else {
+			config.TLSConfig = config.TLSConfig.Clone()
+		}
+		config.TLSConfig.Certificates = append(config.TLSConfig.Certificates, cert)
+	}
+
+	// Default the version tag to the module version from build info
+	if _, ok := config.Tags["version"]; !ok {
+		if version := buildVersion(); version != "" {
+			tags := make(map[string]string, len(config.Tags)+1)
+			for k, v := range config.Tags {
+				tags[k] = v
+			}
+			tags["version"] = version
+			config.Tags = tags
+		}
+	}
+
 	// Create stdout storage if OutputToStdout is enabled
 	if config.OutputToStdout {
 		config.Storage = NewStdoutStorage()
 	} else if config.Storage == nil && config.APIKey != "" && config.IngestURL != "" {
 		// Create HTTP storage if not provided and not in stdout mode
-		config.Storage = NewHTTPStorage(config.IngestURL+"/upload", config.APIKey, config.Env)
+		storage := NewHTTPStorage(config.IngestURL+"/upload", config.APIKey, config.Env)
+		storage.DisableCompression = config.DisableCompression
+		if config.HTTPClient != nil {
+			storage.Client = config.HTTPClient
+		} else if config.HTTPTimeout > 0 {
+			storage.Client = &http.Client{Timeout: config.HTTPTimeout}
+		}
+		storage.TLSConfig = config.TLSConfig
+		storage.ProxyURL = config.ProxyURL
+		storage.Headers = config.Headers
+		if len(config.UploadPathByType) > 0 {
+			storage.URLByType = make(map[ProfileType]string, len(config.UploadPathByType))
+			for t, path := range config.UploadPathByType {
+				storage.URLByType[t] = config.IngestURL + path
+			}
+		}
+		config.Storage = storage
 	}
 
-	// Enable CPU and Memory by default if nothing is enabled
-	if !config.EnableCPU && !config.EnableMemory && !config.EnableGoroutine &&
-		!config.EnableMutex && !config.EnableBlock && !config.EnableCustom {
-		config.EnableCPU = true
-		config.EnableMemory = true
+	// Enable CPU and Memory by default if nothing is enabled. Scoped profilers
+	// cannot touch global runtime state, so they default to goroutines.
+	if !config.EnableCPU && !config.EnableMemory && !config.EnableAllocs && !config.EnableGoroutine &&
+		!config.EnableMutex && !config.EnableBlock && !config.EnableCustom && !config.EnableTrace {
+		if config.Scoped {
+			config.EnableGoroutine = true
+		} else {
+			config.EnableCPU = true
+			config.EnableMemory = true
+		}
 	}
 
 	return newProfiler(config)
@@ -67,62 +167,121 @@ func (p *Profiler) start(ctx context.Context) error {
 		return fmt.Errorf("profiler already started")
 	}
 
-	// Store original runtime settings before configuring
-	p.originalMemProfileRate = runtime.MemProfileRate
-	// Note: runtime doesn't provide getters for mutex and block rates,
-	// so we store reasonable defaults to restore
-	p.originalMutexFraction = 0    // Default is disabled
-	p.originalBlockProfileRate = 0 // Default is disabled
+	// Collection is a no-op under test, so leave runtime state untouched
+	if p.config.DisableUnderTest {
+		p.initialized = true
+		return nil
+	}
+
+	// Replayed profiles are read from disk, so the runtime is left alone
+	replaying := p.config.ReplayDir != ""
 
-	// Configure runtime settings
-	if p.config.EnableMemory {
-		runtime.MemProfileRate = p.config.MemProfileRate
+	if p.config.EnableCPU && !replaying {
+		if err := p.claimCPU(); err != nil {
+			return err
+		}
 	}
 
-	if p.config.EnableMutex {
-		runtime.SetMutexProfileFraction(p.config.MutexFraction)
+	// Scoped profilers leave global runtime rates to the host application
+	if !p.config.Scoped && !replaying {
+		// Store original runtime settings before configuring
+		p.originalMemProfileRate = runtime.MemProfileRate
+
+		// Configure runtime settings, recording which ones were changed so
+		// Stop leaves rates set by the host application alone
+		p.setMemProfileRate = p.config.EnableMemory || p.config.EnableAllocs || p.config.DisableMemSampling
+		if p.setMemProfileRate {
+			runtime.MemProfileRate = p.memProfileRate()
+		}
+
+		// SetMutexProfileFraction returns the previous fraction
+		p.setMutexFraction = p.config.EnableMutex
+		if p.setMutexFraction {
+			p.originalMutexFraction = runtime.SetMutexProfileFraction(p.config.MutexFraction)
+		}
+
+		// The runtime has no getter for the block rate, so it is restored to
+		// disabled, and only when pprofio changed it
+		p.setBlockProfileRate = p.config.EnableBlock
+		if p.setBlockProfileRate {
+			p.originalBlockProfileRate = 0
+			runtime.SetBlockProfileRate(p.config.BlockProfileRate)
+		}
 	}
 
-	if p.config.EnableBlock {
-		runtime.SetBlockProfileRate(p.config.BlockProfileRate)
+	if p.config.HeapBaselineDelta && p.config.EnableMemory && !replaying {
+		if err := p.captureHeapBaseline(); err != nil {
+			p.config.Logger.Errorf("Error capturing heap baseline: %v", err)
+		}
+	}
+
+	if p.uploadQueue != nil {
+		for i := 0; i < p.uploadQueueWorkers(); i++ {
+			p.wg.Add(1)
+			go p.processUploadQueue(ctx)
+		}
 	}
 
 	// Start collection goroutines
-	if p.config.EnableCPU {
+	if p.config.Snapshots {
 		p.wg.Add(1)
-		go p.collectProfiles(ctx, profileTypeCPU)
+		go p.collectSnapshots(ctx)
+	} else {
+		for _, t := range p.enabledProfileTypes() {
+			p.wg.Add(1)
+			go p.collectProfiles(ctx, t)
+		}
 	}
 
-	if p.config.EnableMemory {
+	// Traces are large, so they run on their own cadence even in snapshot mode
+	if p.config.EnableTrace {
 		p.wg.Add(1)
-		go p.collectProfiles(ctx, profileTypeMemory)
+		go p.collectProfiles(ctx, profileTypeTrace)
 	}
 
-	if p.config.EnableGoroutine {
+	if p.config.EnableCustom {
+		if len(p.enabledProfileTypes()) == 0 && !p.config.EnableTrace {
+			p.config.Logger.Errorf("Warning: only custom profiling is enabled; spans are uploaded only when they end on a context attached with WithProfiler")
+		}
 		p.wg.Add(1)
-		go p.collectProfiles(ctx, profileTypeGoroutine)
+		go p.processCustomSpans(ctx)
 	}
 
-	if p.config.EnableMutex {
+	if p.config.CaptureOnShutdown {
 		p.wg.Add(1)
-		go p.collectProfiles(ctx, profileTypeMutex)
+		go p.watchShutdownSignals(ctx, notifyShutdown())
 	}
 
-	if p.config.EnableBlock {
-		p.wg.Add(1)
-		go p.collectProfiles(ctx, profileTypeBlock)
+	// The directory is created up front so a crash only has to write files
+	if p.config.FlushOnCrash {
+		if err := os.MkdirAll(p.config.FallbackDir, 0755); err != nil {
+			p.config.Logger.Errorf("Error creating crash profile directory: %v", err)
+		} else {
+			p.wg.Add(1)
+			go p.watchCrashSignals(ctx, notifyCrash())
+		}
 	}
 
-	if p.config.EnableCustom {
+	if p.config.DeployMetadataFile != "" {
 		p.wg.Add(1)
-		go p.processCustomSpans(ctx)
+		go p.watchDeployMetadata(ctx, notifyReload())
 	}
 
 	p.initialized = true
 	return nil
 }
 
+// memProfileRate returns the runtime.MemProfileRate the profiler sets.
+func (p *Profiler) memProfileRate() int {
+	if p.config.DisableMemSampling {
+		return 0
+	}
+	return p.config.MemProfileRate
+}
+
 // Stop ends profile collection and waits for any pending uploads to complete.
+// A profile being recorded is cut short and uploaded, and spans that ended
+// since the last flush are uploaded before Stop returns.
 func (p *Profiler) Stop() {
 	p.stop()
 }
@@ -146,22 +305,106 @@ func (p *Profiler) stop() {
 
 	p.wg.Wait()
 
+	// Collections cut short by the stop may have queued uploads after the
+	// queue workers exited
+	p.drainUploadQueue()
+
 	// Restore original runtime settings
-	runtime.MemProfileRate = p.originalMemProfileRate
-	runtime.SetMutexProfileFraction(p.originalMutexFraction)
-	runtime.SetBlockProfileRate(p.originalBlockProfileRate)
+	if !p.config.Scoped && !p.config.DisableUnderTest && p.config.ReplayDir == "" {
+		// A rate the host application changed during the run is left alone
+		if p.setMemProfileRate && runtime.MemProfileRate == p.memProfileRate() {
+			runtime.MemProfileRate = p.originalMemProfileRate
+		}
+		if p.setMutexFraction {
+			runtime.SetMutexProfileFraction(p.originalMutexFraction)
+		}
+		if p.setBlockProfileRate {
+			runtime.SetBlockProfileRate(p.originalBlockProfileRate)
+		}
+	}
 
+	p.releaseCPU()
 	p.initialized = false
 }
 
+// Flush immediately collects and uploads the given profile types, independent
+// of the sampling schedule, and reports the outcome of each collection.
+// With no types, every enabled profile type is flushed, including the spans
+// received so far when EnableCustom is set. Nothing is collected when
+// DisableUnderTest is set.
+func (p *Profiler) Flush(ctx context.Context, types ...ProfileType) []CollectionResult {
+	if p.config.DisableUnderTest {
+		return nil
+	}
+	if len(types) == 0 {
+		types = p.enabledProfileTypes()
+		if p.config.EnableCustom {
+			types = append(types, ProfileCustom)
+		}
+	}
+	results := make([]CollectionResult, 0, len(types))
+	ctx = withTrigger(ctx, triggerManual)
+	start := time.Now()
+
+	if p.config.UploadWorkers > 1 {
+		results = p.collectConcurrently(ctx, types)
+	} else {
+		for _, t := range types {
+			results = append(results, p.collectResult(ctx, t))
+		}
+	}
+	p.logCycleSummary(triggerManual, results, time.Since(start))
+
+	return results
+}
+
+// CollectTo collects a profile of the given type into f instead of a temp
+// file, without uploading it. This lets callers choose where profile bytes
+// land, e.g. a memfd or a preallocated file. CPU profiles and traces are
+// recorded for ProfileDuration, or until ctx is done; scoped profilers
+// reject them.
+func (p *Profiler) CollectTo(ctx context.Context, t ProfileType, f *os.File) error {
+	if f == nil {
+		return errors.New("file is required")
+	}
+	if err := p.checkScoped(t); err != nil {
+		return err
+	}
+	if err := p.writeProfile(ctx, t, f); err != nil {
+		return fmt.Errorf("failed to write %s profile: %w", t, err)
+	}
+	return nil
+}
+
+// Drain blocks until all pending uploads have completed or ctx is done.
+// Unlike Stop, it does not halt collection.
+func (p *Profiler) Drain(ctx context.Context) error {
+	p.uploadMu.Lock()
+	if p.pendingUploads == 0 {
+		p.uploadMu.Unlock()
+		return nil
+	}
+	idle := p.uploadsIdle
+	p.uploadMu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // StartSpan begins timing a custom span with the given name and optional tags.
 // Tags should be provided as alternating key-value pairs (e.g., "key1", "value1", "key2", "value2").
-// The span is automatically associated with the profiler if the context contains one.
+// The span is automatically associated with the profiler if the context contains one,
+// and is delivered to it when End is called.
 func StartSpan(ctx context.Context, name string, tags ...string) (context.Context, *Span) {
 	span := &Span{
 		Name:  name,
 		Start: time.Now(),
 		Tags:  make(map[string]string),
+		id:    newSpanID(),
 	}
 
 	// Convert tags slice to map
@@ -173,14 +416,12 @@ func StartSpan(ctx context.Context, name string, tags ...string) (context.Contex
 		}
 	}
 
-	// Check if we have a profiler in the context
+	// Spans started under a profiler are delivered to it by End
 	if prof, ok := ctx.Value(spanKey{}).(*Profiler); ok && prof != nil {
-		// Queue span for processing
-		select {
-		case prof.spanCh <- span:
-			// Span queued successfully
-		default:
-			// Channel full, log and continue
+		span.profiler = prof
+		prof.spanStarted(span)
+		if prof.config.SpanTracer != nil {
+			ctx, span.endTrace = prof.config.SpanTracer.StartSpan(ctx, span)
 		}
 	}
 
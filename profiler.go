@@ -1,27 +1,72 @@
 package pprofio
 
 import (
+	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"path/filepath"
 	"runtime"
 	"runtime/pprof"
+	"runtime/trace"
+	"sort"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/google/pprof/profile"
 )
 
-type profileType string
+// ProfileType identifies a kind of profile collected by the profiler
+type ProfileType string
 
 const (
-	profileTypeCPU       profileType = "cpu"
-	profileTypeMemory    profileType = "memory"
-	profileTypeGoroutine profileType = "goroutine"
-	profileTypeMutex     profileType = "mutex"
-	profileTypeBlock     profileType = "block"
-	profileTypeCustom    profileType = "custom"
+	ProfileCPU       ProfileType = "cpu"
+	ProfileMemory    ProfileType = "memory"
+	ProfileGoroutine ProfileType = "goroutine"
+	ProfileMutex     ProfileType = "mutex"
+	ProfileBlock     ProfileType = "block"
+	ProfileCustom    ProfileType = "custom"
+	ProfileAllocs    ProfileType = "allocs"
+	ProfileTrace     ProfileType = "trace"
 )
 
+// profileType is the internal name for ProfileType
+type profileType = ProfileType
+
+const (
+	profileTypeCPU       = ProfileCPU
+	profileTypeMemory    = ProfileMemory
+	profileTypeGoroutine = ProfileGoroutine
+	profileTypeMutex     = ProfileMutex
+	profileTypeBlock     = ProfileBlock
+	profileTypeCustom    = ProfileCustom
+	profileTypeAllocs    = ProfileAllocs
+	profileTypeTrace     = ProfileTrace
+)
+
+// maxDebugFiles bounds how many profiles KeepTempFiles retains in DebugDir
+const maxDebugFiles = 20
+
+// maxPendingMetadata bounds the queue of metadata awaiting a retry
+const maxPendingMetadata = 100
+
+// CollectionResult describes the outcome of collecting and uploading a single profile.
+type CollectionResult struct {
+	Type      ProfileType
+	ProfileID string
+	URL       string
+	SizeBytes int64
+	Duration  time.Duration
+	Err       error
+
+	// Attempts is the number of upload requests made, when the storage
+	// reports it
+	Attempts int
+}
+
 type Profiler struct {
 	config      Config
 	mu          sync.Mutex
@@ -30,10 +75,94 @@ type Profiler struct {
 	initialized bool
 	spanCh      chan *Span
 
+	// IDs of spans started under the profiler and not yet ended
+	activeSpansMu sync.Mutex
+	activeSpans   map[string]struct{}
+
+	// Spans received but not yet uploaded, shared by the flush loop and Flush
+	spansMu      sync.Mutex
+	pendingSpans map[string][]*Span
+
 	// Store original runtime values for restoration
 	originalMemProfileRate   int
 	originalMutexFraction    int
 	originalBlockProfileRate int
+	setMemProfileRate        bool
+	setMutexFraction         bool
+	setBlockProfileRate      bool
+
+	// Schedule state read by the collection loops. resumeCh is closed by
+	// Resume and nil while not paused; rateCh is closed and replaced by
+	// UpdateSampleRate.
+	scheduleMu sync.Mutex
+	resumeCh   chan struct{}
+	rateCh     chan struct{}
+
+	// lastSnapshotMemory is when a snapshot cycle last included a memory
+	// profile, for MemoryInterval
+	lastSnapshotMemory time.Time
+
+	eventsMu sync.Mutex
+	events   chan CollectionEvent
+
+	// Track pending uploads so Drain can wait for them
+	uploadMu       sync.Mutex
+	pendingUploads int
+	uploadsIdle    chan struct{}
+
+	// Delta profiling state per cumulative profile type
+	deltaMu       sync.Mutex
+	deltaBase     map[profileType]*profile.Profile
+	deltaNeedFull map[profileType]bool
+	heapBaseline  *profile.Profile
+
+	// Memory collections so far, for HeapGCEveryN
+	heapGCMu        sync.Mutex
+	heapCollections int
+
+	// Metadata for uploaded profiles whose registration failed, retried on
+	// later uploads so the profiles are not orphaned
+	metadataMu      sync.Mutex
+	pendingMetadata []map[string]string
+
+	// Most recent profile per type, served in support bundles and written
+	// on crashes. Profiles are only retained once Handler has mounted the
+	// bundle route or FlushOnCrash is set, so they are not held for nothing.
+	recentMu   sync.Mutex
+	recent     map[profileType]recentProfile
+	keepRecent bool
+
+	// Position of the next file to replay per type, when ReplayDir is set
+	replayMu   sync.Mutex
+	replayNext map[profileType]int
+
+	// Tags read from DeployMetadataFile
+	deployMu   sync.Mutex
+	deployTags map[string]string
+
+	// ingestHTTPClient carries HTTPClient, HTTPTimeout, TLSConfig and
+	// ProxyURL for metadata requests, shared so
+	// connections are reused
+	ingestHTTPClient *http.Client
+
+	// uploadSlots holds one token per UploadWorkers upload in progress;
+	// nil when uploads are unbounded
+	uploadSlots chan struct{}
+
+	// uploadQueue holds scheduled profiles awaiting upload when
+	// UploadQueueSize is set; queueMu serializes drop-oldest enqueues
+	queueMu     sync.Mutex
+	uploadQueue chan queuedUpload
+
+	budget      *uploadBudget
+	cpuUsage    *cpuUsageGate
+	containerID string
+
+	statsMu      sync.Mutex
+	stats        ProfilerStats
+	lastErrStage string
+	lastErr      error
+	lastErrAt    time.Time
 }
 
 // newProfiler is the internal constructor used by New
@@ -45,29 +174,116 @@ func newProfiler(config Config) (*Profiler, error) {
 	p := &Profiler{
 		config: config,
 		stopCh: make(chan struct{}),
+		rateCh: make(chan struct{}),
+		events: make(chan CollectionEvent, eventBufferSize),
 		spanCh: make(chan *Span, 1000), // Buffer for custom spans
+
+		pendingSpans: make(map[string][]*Span),
+
+		deltaBase:     make(map[profileType]*profile.Profile),
+		deltaNeedFull: make(map[profileType]bool),
+
+		replayNext: make(map[profileType]int),
+		recent:     make(map[profileType]recentProfile),
+		keepRecent: config.FlushOnCrash,
+	}
+
+	if config.IncludeContainerMetadata {
+		p.containerID = detectContainerID()
+	}
+
+	if config.MaxUploadsPerHour > 0 {
+		p.budget = newUploadBudget(config.MaxUploadsPerHour, time.Hour)
+	}
+
+	if config.DeployMetadataFile != "" {
+		if err := p.loadDeployMetadata(); err != nil {
+			return nil, err
+		}
+	}
+
+	p.ingestHTTPClient = ingestHTTPClient(config)
+
+	if config.UploadQueueSize > 0 {
+		p.uploadQueue = make(chan queuedUpload, config.UploadQueueSize)
+	}
+
+	if config.UploadWorkers > 0 {
+		p.uploadSlots = make(chan struct{}, config.UploadWorkers)
+	}
+
+	if config.MinCPUUsage > 0 {
+		p.cpuUsage = newCPUUsageGate(config.MinCPUUsage)
 	}
 
 	return p, nil
 }
 
+// enabledProfileTypes returns the runtime profile types turned on in the config.
+func (p *Profiler) enabledProfileTypes() []profileType {
+	var types []profileType
+	if p.config.EnableCPU {
+		types = append(types, profileTypeCPU)
+	}
+	if p.config.EnableMemory && p.config.HeapProfileMode != HeapProfileAlloc {
+		types = append(types, profileTypeMemory)
+	}
+	if p.config.EnableAllocs || (p.config.EnableMemory && p.config.HeapProfileMode != HeapProfileInuse) {
+		types = append(types, profileTypeAllocs)
+	}
+	if p.config.EnableGoroutine {
+		types = append(types, profileTypeGoroutine)
+	}
+	if p.config.EnableMutex {
+		types = append(types, profileTypeMutex)
+	}
+	if p.config.EnableBlock {
+		types = append(types, profileTypeBlock)
+	}
+	return types
+}
+
+// interval returns how often the given profile type is collected, taking
+// ProfileOptions into account. Execution
+// traces are large, so they follow their own, slower cadence, and memory
+// profiles force a GC, so they are collected at most every MemoryInterval.
+func (p *Profiler) interval(profileType profileType) time.Duration {
+	rate := p.config.ProfileOptions[profileType].SampleRate
+	if rate == 0 && profileType == profileTypeTrace {
+		return p.config.TraceInterval
+	}
+	if rate == 0 {
+		rate = p.sampleRate()
+	}
+	if profileType == profileTypeMemory && rate < p.config.MemoryInterval {
+		return p.config.MemoryInterval
+	}
+	return rate
+}
+
 func (p *Profiler) collectProfiles(ctx context.Context, profileType profileType) {
 	defer p.wg.Done()
 
-	ticker := time.NewTicker(p.config.SampleRate)
+	ticker := time.NewTicker(p.interval(profileType))
 	defer ticker.Stop()
+	rateChanged := p.rateChanged()
 
-	// Collect one profile immediately at startup
-	if err := p.collectProfile(ctx, profileType); err != nil {
-		fmt.Fprintf(os.Stderr, "Error collecting %s profile: %v\n", profileType, err)
+	// Collect one profile immediately at startup, unless the profiler was
+	// stopped or its context cancelled before the loop began
+	if p.pauseState() == nil && p.running(ctx) {
+		p.collectScheduled(ctx, profileType)
 	}
 
 	for {
 		select {
 		case <-ticker.C:
-			if err := p.collectProfile(ctx, profileType); err != nil {
-				fmt.Fprintf(os.Stderr, "Error collecting %s profile: %v\n", profileType, err)
+			if !p.waitWhilePaused(ctx, ticker, p.interval(profileType)) {
+				return
 			}
+			p.collectScheduled(ctx, profileType)
+		case <-rateChanged:
+			rateChanged = p.rateChanged()
+			ticker.Reset(p.interval(profileType))
 		case <-p.stopCh:
 			return
 		case <-ctx.Done():
@@ -76,37 +292,160 @@ func (p *Profiler) collectProfiles(ctx context.Context, profileType profileType)
 	}
 }
 
-func (p *Profiler) collectProfile(ctx context.Context, profileType profileType) error {
+// running reports whether collection loops should keep working: neither ctx
+// nor the profiler has been stopped.
+func (p *Profiler) running(ctx context.Context) bool {
+	select {
+	case <-p.stopCh:
+		return false
+	case <-ctx.Done():
+		return false
+	default:
+		return true
+	}
+}
+
+// collectScheduled runs one scheduled collection, unless the process is too
+// idle for a useful CPU profile or the upload budget is exhausted.
+func (p *Profiler) collectScheduled(ctx context.Context, profileType profileType) {
+	if !p.cpuBusyEnough(profileType) || !p.withinBudget() {
+		return
+	}
+
+	ctx = withTrigger(ctx, triggerScheduled)
+	if p.uploadQueue != nil {
+		ctx = withQueuedUpload(ctx, true)
+	}
+	if _, err := p.collectProfile(ctx, profileType); err != nil {
+		p.logCollectionError(profileType, err)
+	}
+}
+
+// collectProfile collects and uploads one profile, recording any failure for
+// LastError.
+func (p *Profiler) collectProfile(ctx context.Context, profileType profileType) (CollectionResult, error) {
+	start := time.Now()
+	if ids := p.activeSpanIDs(); len(ids) > 0 {
+		ctx = withSpanIDs(ctx, ids)
+	}
+	queued := queuedUploadFromContext(ctx)
+	if queued {
+		ctx = withCollectionStart(ctx, start)
+	}
+	result, err := p.runCollector(ctx, profileType)
+	p.recordError(err)
+
+	event := result
+	event.Type = profileType
+	event.Err = err
+	event.Duration = time.Since(start)
+	// A queued profile's event is emitted once its upload finishes, with the
+	// URL or upload error
+	if !queued || err != nil {
+		p.emitEvent(event, start)
+	}
+	p.logCollection(event)
+	p.recordCollectionTime(profileType, event.Duration)
+
+	return result, err
+}
+
+func (p *Profiler) runCollector(ctx context.Context, profileType profileType) (CollectionResult, error) {
+	if err := p.checkScoped(profileType); err != nil {
+		return CollectionResult{}, err
+	}
+	if p.config.ReplayDir != "" && profileType != profileTypeCustom {
+		return p.collectReplay(ctx, profileType)
+	}
+
 	switch profileType {
 	case profileTypeCPU:
 		return p.collectCPU(ctx)
 	case profileTypeMemory:
 		return p.collectMemory(ctx)
+	case profileTypeAllocs:
+		return p.collectAllocs(ctx)
+	case profileTypeTrace:
+		return p.collectTrace(ctx)
 	case profileTypeGoroutine:
 		return p.collectGoroutine(ctx)
 	case profileTypeMutex:
 		return p.collectMutex(ctx)
 	case profileTypeBlock:
 		return p.collectBlock(ctx)
+	case profileTypeCustom:
+		return p.collectCustom(ctx)
 	default:
-		return fmt.Errorf("unknown profile type: %s", profileType)
+		return CollectionResult{}, fmt.Errorf("unknown profile type: %s", profileType)
 	}
 }
 
-func (p *Profiler) collectCPU(ctx context.Context) error {
-	f, err := os.CreateTemp("", "cpu.pprof")
+func (p *Profiler) collectCPU(ctx context.Context) (CollectionResult, error) {
+	f, err := p.createTempFile(profileTypeCPU)
 	if err != nil {
-		return fmt.Errorf("failed to create temp file: %w", err)
+		return CollectionResult{}, fmt.Errorf("failed to create temp file: %w", err)
 	}
-	defer os.Remove(f.Name())
+	defer p.releaseTempFile(f.Name(), profileTypeCPU)
 
-	if err := pprof.StartCPUProfile(f); err != nil {
+	if err := p.writeCPUProfile(ctx, f); err != nil {
 		f.Close()
-		return fmt.Errorf("failed to start CPU profile: %w", err)
+		return CollectionResult{}, err
 	}
+	f.Close()
 
-	// Profile for the configured duration
-	profileCtx, cancel := context.WithTimeout(ctx, p.config.ProfileDuration)
+	return p.uploadProfile(ctx, f.Name(), string(profileTypeCPU))
+}
+
+// writeProfile writes a profile of the given type to w without uploading it.
+// Mutex and block profiles are always written in full so the delta base used
+// for uploads is left untouched.
+func (p *Profiler) writeProfile(ctx context.Context, profileType profileType, w io.Writer) error {
+	switch profileType {
+	case profileTypeCPU:
+		return p.writeCPUProfile(ctx, w)
+	case profileTypeTrace:
+		if err := trace.Start(w); err != nil {
+			return fmt.Errorf("failed to start trace: %w", err)
+		}
+		p.waitProfileDuration(ctx, profileTypeTrace)
+		trace.Stop()
+		return nil
+	case profileTypeMemory:
+		runtime.GC()
+		return p.writeHeapProfile(w)
+	case profileTypeGoroutine:
+		return p.writeGoroutineProfile(w)
+	case profileTypeAllocs, profileTypeMutex:
+		return pprof.Lookup(string(profileType)).WriteTo(w, 0)
+	case profileTypeBlock:
+		return p.filterBlockProfile(w, func(w io.Writer) error {
+			return pprof.Lookup(string(profileTypeBlock)).WriteTo(w, 0)
+		})
+	default:
+		return fmt.Errorf("unknown profile type: %s", profileType)
+	}
+}
+
+// profileDuration returns how long CPU profiles and traces of the given type
+// record, taking ProfileOptions into account.
+func (p *Profiler) profileDuration(profileType profileType) time.Duration {
+	if d := p.config.ProfileOptions[profileType].Duration; d > 0 {
+		return d
+	}
+	return p.config.ProfileDuration
+}
+
+// waitProfileDuration blocks for the type's profile duration or until the
+// profiler stops, leaving half of any caller deadline (e.g. a shutdown
+// timeout) for the upload that follows.
+func (p *Profiler) waitProfileDuration(ctx context.Context, profileType profileType) {
+	duration := p.profileDuration(profileType)
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := time.Until(deadline) / 2; remaining < duration {
+			duration = remaining
+		}
+	}
+	profileCtx, cancel := context.WithTimeout(ctx, duration)
 	defer cancel()
 
 	select {
@@ -115,128 +454,400 @@ func (p *Profiler) collectCPU(ctx context.Context) error {
 	case <-p.stopCh:
 		// Profiler is stopping
 	}
+}
+
+// collectTrace records a runtime execution trace over ProfileDuration.
+func (p *Profiler) collectTrace(ctx context.Context) (CollectionResult, error) {
+	f, err := p.createTempFile(profileTypeTrace)
+	if err != nil {
+		return CollectionResult{}, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer p.releaseTempFile(f.Name(), profileTypeTrace)
+
+	if err := trace.Start(f); err != nil {
+		f.Close()
+		return CollectionResult{}, fmt.Errorf("failed to start trace: %w", err)
+	}
 
-	pprof.StopCPUProfile()
+	p.waitProfileDuration(ctx, profileTypeTrace)
+
+	trace.Stop()
 	f.Close()
 
-	return p.uploadProfile(ctx, f.Name(), string(profileTypeCPU))
+	return p.uploadProfile(ctx, f.Name(), string(profileTypeTrace))
 }
 
-func (p *Profiler) collectMemory(ctx context.Context) error {
-	f, err := os.CreateTemp("", "memory.pprof")
-	if err != nil {
-		return fmt.Errorf("failed to create temp file: %w", err)
+func (p *Profiler) collectMemory(ctx context.Context) (CollectionResult, error) {
+	// Force garbage collection to get accurate memory profile
+	if p.heapGCDue() {
+		forceGC()
 	}
-	defer os.Remove(f.Name())
 
-	// Force garbage collection to get accurate memory profile
-	runtime.GC()
+	result, err := p.collectWritten(ctx, profileTypeMemory, p.writeHeapProfile)
+	if err != nil || !p.config.HeapBaselineDelta {
+		return result, err
+	}
 
-	if err := pprof.WriteHeapProfile(f); err != nil {
-		f.Close()
-		return fmt.Errorf("failed to write memory profile: %w", err)
+	if _, err := p.uploadHeapBaselineDelta(ctx); err != nil {
+		return result, fmt.Errorf("failed to upload heap baseline delta: %w", err)
 	}
+	return result, nil
+}
 
-	f.Close()
-	return p.uploadProfile(ctx, f.Name(), string(profileTypeMemory))
+// forceGC is replaceable in tests
+var forceGC = runtime.GC
+
+// heapGCDue reports whether this memory collection should force a GC under
+// HeapGCEveryN, and counts the collection.
+func (p *Profiler) heapGCDue() bool {
+	p.heapGCMu.Lock()
+	defer p.heapGCMu.Unlock()
+
+	due := p.config.HeapGCEveryN <= 1 || p.heapCollections%p.config.HeapGCEveryN == 0
+	p.heapCollections++
+	return due
 }
 
-func (p *Profiler) collectGoroutine(ctx context.Context) error {
-	f, err := os.CreateTemp("", "goroutine.pprof")
+// writeHeapProfile writes the heap profile to w. When HeapDefaultSampleType is
+// configured, the profile is rewritten so backends render that view by default.
+func (p *Profiler) writeHeapProfile(w io.Writer) error {
+	if p.config.HeapDefaultSampleType == "" {
+		return pprof.WriteHeapProfile(w)
+	}
+
+	var buf bytes.Buffer
+	if err := pprof.WriteHeapProfile(&buf); err != nil {
+		return err
+	}
+
+	prof, err := profile.Parse(&buf)
 	if err != nil {
-		return fmt.Errorf("failed to create temp file: %w", err)
+		return fmt.Errorf("failed to parse heap profile: %w", err)
 	}
-	defer os.Remove(f.Name())
 
-	if err := pprof.Lookup("goroutine").WriteTo(f, 0); err != nil {
-		f.Close()
-		return fmt.Errorf("failed to write goroutine profile: %w", err)
+	found := false
+	for _, st := range prof.SampleType {
+		if st.Type == p.config.HeapDefaultSampleType {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("heap profile has no sample type %q", p.config.HeapDefaultSampleType)
 	}
 
-	f.Close()
-	return p.uploadProfile(ctx, f.Name(), string(profileTypeGoroutine))
+	prof.DefaultSampleType = p.config.HeapDefaultSampleType
+	return prof.Write(w)
 }
 
-func (p *Profiler) collectMutex(ctx context.Context) error {
-	f, err := os.CreateTemp("", "mutex.pprof")
-	if err != nil {
-		return fmt.Errorf("failed to create temp file: %w", err)
+func (p *Profiler) collectAllocs(ctx context.Context) (CollectionResult, error) {
+	return p.collectWritten(ctx, profileTypeAllocs, func(w io.Writer) error {
+		return pprof.Lookup("allocs").WriteTo(w, 0)
+	})
+}
+
+func (p *Profiler) collectGoroutine(ctx context.Context) (CollectionResult, error) {
+	// debug=0 writes a gzip-compressed protobuf, which keeps profiles small
+	// and is uploaded by HTTPStorage without compressing it again
+	return p.collectWritten(ctx, profileTypeGoroutine, p.writeGoroutineProfile)
+}
+
+func (p *Profiler) collectMutex(ctx context.Context) (CollectionResult, error) {
+	return p.collectCumulative(ctx, profileTypeMutex, func(w io.Writer) (bool, error) {
+		return p.writeCumulativeProfile(w, profileTypeMutex)
+	})
+}
+
+func (p *Profiler) collectBlock(ctx context.Context) (CollectionResult, error) {
+	return p.collectCumulative(ctx, profileTypeBlock, p.writeBlockProfile)
+}
+
+// createTempFile creates the temp file a profile is collected into, named
+// with the type's configured FileExtensions suffix when set.
+func (p *Profiler) createTempFile(profileType profileType) (*os.File, error) {
+	pattern := string(profileType) + ".pprof"
+	if profileType == profileTypeTrace {
+		pattern = "trace.out"
+	}
+	if ext := p.config.FileExtensions[profileType]; ext != "" {
+		pattern = string(profileType) + "-*" + ext
 	}
-	defer os.Remove(f.Name())
+	return os.CreateTemp("", pattern)
+}
 
-	if err := pprof.Lookup("mutex").WriteTo(f, 0); err != nil {
-		f.Close()
-		return fmt.Errorf("failed to write mutex profile: %w", err)
+// debugTimeFormat is the timestamp layout in the names of kept profiles
+const debugTimeFormat = "20060102T150405.000000000"
+
+// profileExtension returns the file extension of a profile type: the
+// configured FileExtensions suffix, or ".pprof" (".out" for traces).
+func (p *Profiler) profileExtension(profileType profileType) string {
+	if ext := p.config.FileExtensions[profileType]; ext != "" {
+		return ext
 	}
+	if profileType == profileTypeTrace {
+		return ".out"
+	}
+	return ".pprof"
+}
 
-	f.Close()
-	return p.uploadProfile(ctx, f.Name(), string(profileTypeMutex))
+// releaseTempFile removes a collected temp profile, or moves it into DebugDir
+// under a descriptive name when KeepTempFiles is set.
+func (p *Profiler) releaseTempFile(path string, profileType profileType) {
+	if !p.config.KeepTempFiles {
+		os.Remove(path)
+		return
+	}
+
+	if err := os.MkdirAll(p.config.DebugDir, 0755); err != nil {
+		p.config.Logger.Errorf("Error creating debug directory: %v", err)
+		os.Remove(path)
+		return
+	}
+
+	name := fmt.Sprintf("%s-%s-%s%s", p.config.ServiceName, profileType, time.Now().Format(debugTimeFormat), p.profileExtension(profileType))
+	target := filepath.Join(p.config.DebugDir, name)
+	if err := moveFile(path, target); err != nil {
+		p.config.Logger.Errorf("Error keeping %s profile, left at %s: %v", profileType, path, err)
+		return
+	}
+
+	p.config.Logger.Debugf("Kept %s profile at %s", profileType, target)
+	p.pruneDebugDir(maxDebugFiles)
 }
 
-func (p *Profiler) collectBlock(ctx context.Context) error {
-	f, err := os.CreateTemp("", "block.pprof")
+// renameFile is replaceable in tests
+var renameFile = os.Rename
+
+// moveFile renames src to dst, copying it when they are on different
+// filesystems (e.g. a tmpfs TempDir). src is left in place if the copy fails.
+func moveFile(src, dst string) error {
+	if err := renameFile(src, dst); err == nil {
+		return nil
+	}
+
+	out, err := os.Create(dst)
 	if err != nil {
-		return fmt.Errorf("failed to create temp file: %w", err)
+		return fmt.Errorf("failed to create %s: %w", dst, err)
+	}
+	if err := copyFile(out, src); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return fmt.Errorf("failed to copy %s: %w", src, err)
 	}
-	defer os.Remove(f.Name())
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("failed to write %s: %w", dst, err)
+	}
+	return os.Remove(src)
+}
 
-	if err := pprof.Lookup("block").WriteTo(f, 0); err != nil {
-		f.Close()
-		return fmt.Errorf("failed to write block profile: %w", err)
+// pruneDebugDir removes the oldest kept profiles so at most max remain.
+// Only files named by releaseTempFile for this service are considered, so
+// anything else in DebugDir is left alone.
+func (p *Profiler) pruneDebugDir(max int) {
+	entries, err := os.ReadDir(p.config.DebugDir)
+	if err != nil {
+		return
 	}
 
-	f.Close()
-	return p.uploadProfile(ctx, f.Name(), string(profileTypeBlock))
+	var matches []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && p.isKeptProfile(entry.Name()) {
+			matches = append(matches, filepath.Join(p.config.DebugDir, entry.Name()))
+		}
+	}
+	if len(matches) <= max {
+		return
+	}
+
+	// Names start with the profile type, so order by mtime
+	sort.Slice(matches, func(i, j int) bool {
+		fi, errI := os.Stat(matches[i])
+		fj, errJ := os.Stat(matches[j])
+		if errI != nil || errJ != nil {
+			return matches[i] < matches[j]
+		}
+		return fi.ModTime().Before(fj.ModTime())
+	})
+
+	for _, path := range matches[:len(matches)-max] {
+		os.Remove(path)
+	}
 }
 
-func (p *Profiler) uploadProfile(ctx context.Context, filePath, profileType string) error {
-	// Upload the profile and parse the returned JSON response
-	uploadResp, err := p.config.Storage.Upload(ctx, filePath)
-	if err != nil {
-		return fmt.Errorf("failed to upload profile: %w", err)
+// isKeptProfile reports whether name is one releaseTempFile writes for this
+// service: "<service>-<type>-<timestamp><ext>".
+func (p *Profiler) isKeptProfile(name string) bool {
+	rest := strings.TrimPrefix(name, p.config.ServiceName+"-")
+	if rest == name {
+		return false
 	}
+	for _, t := range []profileType{
+		profileTypeCPU, profileTypeMemory, profileTypeAllocs, profileTypeGoroutine, profileTypeMutex, profileTypeBlock, profileTypeCustom,
+		profileTypeTrace,
+	} {
+		stamp := strings.TrimPrefix(rest, string(t)+"-")
+		if stamp == rest || len(stamp) < len(debugTimeFormat) {
+			continue
+		}
+		if _, err := time.Parse(debugTimeFormat, stamp[:len(debugTimeFormat)]); err != nil {
+			continue
+		}
+		if stamp[len(debugTimeFormat):] == p.profileExtension(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// beginUpload registers a pending upload that Drain must wait for.
+func (p *Profiler) beginUpload() {
+	p.uploadMu.Lock()
+	defer p.uploadMu.Unlock()
 
-	// Parse the JSON response
-	var response struct {
-		ProfileID  string `json:"profile_id"`
-		ProfileURL string `json:"profile_url"`
-		Type       string `json:"type"`
+	if p.pendingUploads == 0 {
+		p.uploadsIdle = make(chan struct{})
 	}
-	if err := json.Unmarshal([]byte(uploadResp), &response); err != nil {
-		return fmt.Errorf("failed to parse upload response: %w", err)
+	p.pendingUploads++
+}
+
+// endUpload marks a pending upload as complete, waking Drain when none remain.
+func (p *Profiler) endUpload() {
+	p.uploadMu.Lock()
+	defer p.uploadMu.Unlock()
+
+	p.pendingUploads--
+	if p.pendingUploads == 0 {
+		close(p.uploadsIdle)
 	}
+}
 
-	profileURL := response.ProfileURL
-	profileID := response.ProfileID
-	profileTypeFromResp := response.Type
+func (p *Profiler) uploadProfile(ctx context.Context, filePath, profileType string) (CollectionResult, error) {
+	return p.uploadCollected(ctx, profileFile(filePath), ProfileType(profileType))
+}
 
-	// Send metadata with the returned profile_url
-	metadata := map[string]string{
-		"profile_id":  profileID,
-		"profile_url": profileURL, // Use the returned URL instead of generated UUID
-		"service":     p.config.ServiceName,
-		"type":        profileTypeFromResp,
-		"timestamp":   fmt.Sprintf("%d", time.Now().Unix()),
+// uploadCollected uploads a collected profile and registers its metadata.
+func (p *Profiler) uploadCollected(ctx context.Context, profile collectedProfile, profileType ProfileType) (CollectionResult, error) {
+	p.beginUpload()
+	defer p.endUpload()
+
+	if queuedUploadFromContext(ctx) {
+		return p.enqueueUpload(ctx, profile, profileType)
 	}
 
-	// Add user-provided tags
-	for k, v := range p.config.Tags {
-		metadata[k] = v
+	result := CollectionResult{Type: profileType, SizeBytes: profile.size()}
+	p.retainRecent(ctx, profileType, profile)
+	ctx = withCorrelationID(ctx, newCorrelationID())
+
+	if p.config.TransactionalUploads && !p.config.OutputToStdout {
+		return p.uploadTransaction(ctx, profile, result)
 	}
 
+	response, err := p.storeProfile(ctx, profile, profileType, "")
+	result.Attempts = response.Attempts
+	if err != nil {
+		return result, err
+	}
+	result.URL = response.ProfileURL
+	result.ProfileID = response.ProfileID
+
+	// Send metadata with the returned profile_url
+	metadata := p.profileMetadata(ctx, profileType, response)
+
 	// If using stdout mode, output metadata to stdout as well
 	if p.config.OutputToStdout {
 		if stdoutStorage, ok := p.config.Storage.(*StdoutStorage); ok {
 			if err := stdoutStorage.OutputMetadata(metadata); err != nil {
-				return fmt.Errorf("failed to output metadata to stdout: %w", err)
+				return result, &stageError{stage: StageMetadata, err: fmt.Errorf("failed to output metadata to stdout: %w", err)}
 			}
 		}
 	} else {
-		// Send metadata to server in normal mode
+		// Send metadata to server in normal mode, retrying earlier failures
+		// first and queueing this one if it fails
+		p.retryPendingMetadata(ctx)
 		if err := p.sendMetadata(ctx, metadata); err != nil {
-			return fmt.Errorf("failed to send metadata: %w", err)
+			p.queueMetadata(metadata)
+			p.logCorrelation(ctx, "metadata send failed; queued for retry", response.ProfileURL)
+			return result, &stageError{stage: StageMetadata, err: fmt.Errorf("failed to send metadata: %w", err)}
 		}
+		p.logCorrelation(ctx, "metadata sent", response.ProfileURL)
 	}
 
-	return nil
+	return result, nil
+}
+
+// storeProfile uploads the profile through the configured Storage and applies
+// its result. A non-empty reservedID is passed to Storage through the
+// upload context.
+func (p *Profiler) storeProfile(ctx context.Context, profile collectedProfile, profileType ProfileType, reservedID string) (UploadResult, error) {
+	uploadCtx := withUploadContext(ctx, p.config.ServiceName, p.profileTags(), profileType)
+	uploadCtx = withUploadExtension(uploadCtx, p.profileExtension(profileType))
+	if reservedID != "" {
+		uploadCtx = withReservedProfileID(uploadCtx, reservedID)
+	}
+
+	response, err := p.uploadToStorage(uploadCtx, profile, profileType)
+	p.recordUpload(profileType, profile.size(), err)
+	if err == nil {
+		p.logCorrelation(ctx, "profile uploaded", response.ProfileURL)
+	}
+	if p.config.OnUpload != nil {
+		go p.config.OnUpload(string(profileType), response, err)
+	}
+	if err != nil {
+		p.deltaLost(ctx, profileType)
+		return response, &stageError{stage: StageUpload, err: fmt.Errorf("failed to upload profile: %w", err)}
+	}
+
+	// The server lost the delta base and wants a full profile next cycle
+	if response.NeedFull {
+		p.requestFullProfile(profileType)
+	}
+	if response.Type == "" {
+		response.Type = string(profileType)
+	}
+	return response, nil
+}
+
+// profileMetadata builds the metadata registered for an uploaded profile.
+func (p *Profiler) profileMetadata(ctx context.Context, profileType ProfileType, response UploadResult) map[string]string {
+	metadata := map[string]string{
+		"profile_url": response.ProfileURL,
+		"service":     p.config.ServiceName,
+		"type":        response.Type,
+		"timestamp":   fmt.Sprintf("%d", time.Now().Unix()),
+	}
+	if response.ProfileID != "" {
+		metadata["profile_id"] = response.ProfileID
+	}
+	if id, ok := CorrelationIDFromUploadContext(ctx); ok {
+		metadata["correlation_id"] = id
+	}
+	trigger := triggerFromContext(ctx)
+	if trigger != "" {
+		metadata["trigger"] = trigger
+	}
+	if retention := p.retention(trigger); retention != "" {
+		metadata["retention"] = retention
+	}
+	if ids := spanIDsFromContext(ctx); len(ids) > 0 {
+		metadata["span_id"] = strings.Join(ids, ",")
+	}
+
+	// Add user-provided tags
+	for k, v := range p.profileTags() {
+		metadata[k] = v
+	}
+
+	// Add annotations specific to this profile type
+	for k, v := range p.typeMetadata(string(profileType)) {
+		metadata[k] = v
+	}
+	for k, v := range p.deltaMetadata(ctx) {
+		metadata[k] = v
+	}
+	for k, v := range extraMetadataFromContext(ctx) {
+		metadata[k] = v
+	}
+	return metadata
 }
@@ -1,14 +1,26 @@
 package pprofio
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/google/pprof/profile"
 )
 
 func TestNewProfiler(t *testing.T) {
@@ -382,7 +394,7 @@ func TestUploadProfileWithCorrectFlow(t *testing.T) {
 	}
 
 	// Test the upload flow
-	err = profiler.uploadProfile(context.Background(), tmpFile.Name(), "cpu")
+	_, err = profiler.uploadProfile(context.Background(), tmpFile.Name(), "cpu")
 	if err != nil {
 		t.Fatalf("uploadProfile() error = %v", err)
 	}
@@ -420,3 +432,1153 @@ func TestUploadProfileWithCorrectFlow(t *testing.T) {
 		t.Error("timestamp should be present in metadata")
 	}
 }
+
+// captureStorage records the bytes of every uploaded profile.
+type captureStorage struct {
+	mu      sync.Mutex
+	uploads [][]byte
+}
+
+func (s *captureStorage) Upload(ctx context.Context, filePath string) (UploadResult, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	s.mu.Lock()
+	s.uploads = append(s.uploads, data)
+	n := len(s.uploads)
+	s.mu.Unlock()
+
+	return UploadResult{ProfileID: fmt.Sprintf("p%d", n), ProfileURL: fmt.Sprintf("https://storage.pprofio.com/p%d.pprof", n)}, nil
+}
+
+func TestCollectMemoryDefaultSampleType(t *testing.T) {
+	metadataServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer metadataServer.Close()
+
+	storage := &captureStorage{}
+	p, err := newProfiler(Config{
+		APIKey:                "test-key",
+		IngestURL:             metadataServer.URL,
+		Storage:               storage,
+		ServiceName:           "test-service",
+		EnableMemory:          true,
+		HeapDefaultSampleType: "alloc_objects",
+	})
+	if err != nil {
+		t.Fatalf("newProfiler() error = %v", err)
+	}
+
+	if _, err := p.collectMemory(context.Background()); err != nil {
+		t.Fatalf("collectMemory() error = %v", err)
+	}
+
+	if len(storage.uploads) != 1 {
+		t.Fatalf("Expected 1 upload, got %d", len(storage.uploads))
+	}
+
+	prof, err := profile.ParseData(storage.uploads[0])
+	if err != nil {
+		t.Fatalf("Failed to parse uploaded profile: %v", err)
+	}
+
+	if prof.DefaultSampleType != "alloc_objects" {
+		t.Errorf("DefaultSampleType = %q, want %q", prof.DefaultSampleType, "alloc_objects")
+	}
+}
+
+func TestFlushReturnsCollectionResults(t *testing.T) {
+	metadataServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer metadataServer.Close()
+
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       metadataServer.URL,
+		ProfileDuration: 10 * time.Millisecond,
+		Storage:         &captureStorage{},
+		ServiceName:     "test-service",
+		EnableCPU:       true,
+		EnableMemory:    true,
+		EnableGoroutine: true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	results := p.Flush(context.Background())
+
+	wantTypes := []ProfileType{ProfileCPU, ProfileMemory, ProfileGoroutine}
+	if len(results) != len(wantTypes) {
+		t.Fatalf("Flush() returned %d results, want %d", len(results), len(wantTypes))
+	}
+
+	for i, result := range results {
+		if result.Type != wantTypes[i] {
+			t.Errorf("results[%d].Type = %q, want %q", i, result.Type, wantTypes[i])
+		}
+		if result.Err != nil {
+			t.Errorf("results[%d].Err = %v", i, result.Err)
+		}
+		if result.URL == "" {
+			t.Errorf("results[%d].URL is empty", i)
+		}
+		if result.SizeBytes <= 0 {
+			t.Errorf("results[%d].SizeBytes = %d, want > 0", i, result.SizeBytes)
+		}
+	}
+}
+
+// slowStorage delays every upload until released, recording completions.
+type slowStorage struct {
+	delay     time.Duration
+	started   chan struct{}
+	mu        sync.Mutex
+	completed int
+}
+
+func (s *slowStorage) Upload(ctx context.Context, filePath string) (UploadResult, error) {
+	s.started <- struct{}{}
+	time.Sleep(s.delay)
+
+	s.mu.Lock()
+	s.completed++
+	s.mu.Unlock()
+
+	return UploadResult{ProfileURL: "https://storage.pprofio.com/slow.pprof"}, nil
+}
+
+func TestDrainWaitsForPendingUploads(t *testing.T) {
+	metadataServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer metadataServer.Close()
+
+	const uploads = 3
+	storage := &slowStorage{delay: 50 * time.Millisecond, started: make(chan struct{}, uploads)}
+	p, err := newProfiler(Config{
+		APIKey:      "test-key",
+		IngestURL:   metadataServer.URL,
+		Storage:     storage,
+		ServiceName: "test-service",
+	})
+	if err != nil {
+		t.Fatalf("newProfiler() error = %v", err)
+	}
+
+	tmpFile, err := os.CreateTemp("", "memory.pprof")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	for i := 0; i < uploads; i++ {
+		go p.uploadProfile(context.Background(), tmpFile.Name(), "memory")
+	}
+	for i := 0; i < uploads; i++ {
+		<-storage.started
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := p.Drain(ctx); err != nil {
+		t.Fatalf("Drain() error = %v", err)
+	}
+
+	storage.mu.Lock()
+	completed := storage.completed
+	storage.mu.Unlock()
+
+	if completed != uploads {
+		t.Errorf("Drain() returned with %d of %d uploads complete", completed, uploads)
+	}
+
+	// Drain on an idle profiler returns immediately
+	if err := p.Drain(ctx); err != nil {
+		t.Errorf("Drain() on idle profiler error = %v", err)
+	}
+}
+
+func TestKeepTempFiles(t *testing.T) {
+	metadataServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer metadataServer.Close()
+
+	debugDir := t.TempDir()
+	p, err := newProfiler(Config{
+		APIKey:        "test-key",
+		IngestURL:     metadataServer.URL,
+		Storage:       &captureStorage{},
+		ServiceName:   "test-service",
+		KeepTempFiles: true,
+		DebugDir:      debugDir,
+	})
+	if err != nil {
+		t.Fatalf("newProfiler() error = %v", err)
+	}
+
+	if _, err := p.collectGoroutine(context.Background()); err != nil {
+		t.Fatalf("collectGoroutine() error = %v", err)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(debugDir, "test-service-goroutine-*.pprof"))
+	if err != nil {
+		t.Fatalf("Glob() error = %v", err)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("Expected 1 kept goroutine profile in %s, found %d", debugDir, len(matches))
+	}
+
+	info, err := os.Stat(matches[0])
+	if err != nil {
+		t.Fatalf("Failed to stat kept profile: %v", err)
+	}
+	if info.Size() == 0 {
+		t.Error("Kept profile is empty")
+	}
+}
+
+func TestKeepTempFilesExtension(t *testing.T) {
+	metadataServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer metadataServer.Close()
+
+	debugDir := t.TempDir()
+	p, err := newProfiler(Config{
+		APIKey:         "test-key",
+		IngestURL:      metadataServer.URL,
+		Storage:        &captureStorage{},
+		ServiceName:    "test-service",
+		KeepTempFiles:  true,
+		DebugDir:       debugDir,
+		FileExtensions: map[ProfileType]string{ProfileGoroutine: ".goroutine.pb.gz"},
+	})
+	if err != nil {
+		t.Fatalf("newProfiler() error = %v", err)
+	}
+
+	// Kept across filesystems, where rename fails with EXDEV
+	renameFile = func(src, dst string) error {
+		return &os.LinkError{Op: "rename", Old: src, New: dst, Err: errors.New("invalid cross-device link")}
+	}
+	defer func() { renameFile = os.Rename }()
+
+	if _, err := p.collectGoroutine(context.Background()); err != nil {
+		t.Fatalf("collectGoroutine() error = %v", err)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(debugDir, "test-service-goroutine-*.goroutine.pb.gz"))
+	if err != nil {
+		t.Fatalf("Glob() error = %v", err)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("Expected 1 kept goroutine profile with its configured extension, found %d", len(matches))
+	}
+	if info, err := os.Stat(matches[0]); err != nil || info.Size() == 0 {
+		t.Errorf("Kept profile copied across filesystems is missing or empty: %v", err)
+	}
+}
+
+func TestPruneDebugDirKeepsUnrelatedFiles(t *testing.T) {
+	debugDir := t.TempDir()
+	p := &Profiler{config: Config{ServiceName: "test-service", DebugDir: debugDir}}
+
+	old := "test-service-cpu-20240101T000000.000000000.pprof"
+	recent := "test-service-trace-20240101T000001.000000000.out"
+	unrelated := []string{
+		"notes.pprof",
+		"other-service-cpu-20240101T000000.000000000.pprof",
+		"test-service-cpu-latest.pprof",
+		"test-service-cpu-20240101T000000.000000000.pprof.bak",
+	}
+	for i, name := range append([]string{old, recent}, unrelated...) {
+		path := filepath.Join(debugDir, name)
+		if err := os.WriteFile(path, []byte("profile"), 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+		modTime := time.Now().Add(time.Duration(i-10) * time.Minute)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("Chtimes() error = %v", err)
+		}
+	}
+
+	p.pruneDebugDir(1)
+
+	if _, err := os.Stat(filepath.Join(debugDir, old)); !os.IsNotExist(err) {
+		t.Errorf("Oldest kept profile %s should have been pruned", old)
+	}
+	for _, name := range append([]string{recent}, unrelated...) {
+		if _, err := os.Stat(filepath.Join(debugDir, name)); err != nil {
+			t.Errorf("%s should survive pruning: %v", name, err)
+		}
+	}
+}
+
+func TestFlushWithExportedProfileTypes(t *testing.T) {
+	metadataServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer metadataServer.Close()
+
+	p, err := New(Config{
+		APIKey:      "test-key",
+		IngestURL:   metadataServer.URL,
+		Storage:     &captureStorage{},
+		ServiceName: "test-service",
+		EnableCPU:   true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	for _, pt := range []ProfileType{ProfileGoroutine, ProfileMutex, ProfileBlock} {
+		results := p.Flush(context.Background(), pt)
+		if len(results) != 1 {
+			t.Fatalf("Flush(%s) returned %d results, want 1", pt, len(results))
+		}
+		if results[0].Type != pt {
+			t.Errorf("Flush(%s) result type = %q", pt, results[0].Type)
+		}
+		if results[0].Err != nil {
+			t.Errorf("Flush(%s) error = %v", pt, results[0].Err)
+		}
+	}
+
+	results := p.Flush(context.Background(), ProfileType("bogus"))
+	if len(results) != 1 || results[0].Err == nil {
+		t.Error("Flush() with an unknown profile type should report an error")
+	}
+}
+
+func TestStopRestoresHostMutexFraction(t *testing.T) {
+	metadataServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer metadataServer.Close()
+
+	originalMutex := runtime.SetMutexProfileFraction(7)
+	defer runtime.SetMutexProfileFraction(originalMutex)
+
+	for _, enableMutex := range []bool{true, false} {
+		p, err := New(Config{
+			APIKey:          "test-key",
+			IngestURL:       metadataServer.URL,
+			SampleRate:      time.Hour,
+			Storage:         &captureStorage{},
+			ServiceName:     "test-service",
+			EnableGoroutine: true,
+			EnableMutex:     enableMutex,
+			MutexFraction:   2,
+		})
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+
+		if err := p.Start(context.Background()); err != nil {
+			t.Fatalf("Start() error = %v", err)
+		}
+		if fraction := runtime.SetMutexProfileFraction(-1); enableMutex && fraction != 2 {
+			t.Errorf("Mutex fraction = %d while running, want 2", fraction)
+		}
+		p.Stop()
+
+		if fraction := runtime.SetMutexProfileFraction(-1); fraction != 7 {
+			t.Errorf("EnableMutex=%v: mutex fraction = %d after Stop, want 7", enableMutex, fraction)
+		}
+	}
+}
+
+func TestStopKeepsExternallyChangedMemProfileRate(t *testing.T) {
+	metadataServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer metadataServer.Close()
+
+	original := runtime.MemProfileRate
+	defer func() { runtime.MemProfileRate = original }()
+
+	for _, external := range []bool{true, false} {
+		runtime.MemProfileRate = 512 * 1024
+		p, err := New(Config{
+			APIKey:         "test-key",
+			IngestURL:      metadataServer.URL,
+			SampleRate:     time.Hour,
+			Storage:        &captureStorage{},
+			ServiceName:    "test-service",
+			EnableMemory:   true,
+			MemProfileRate: 2048,
+		})
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		p.Pause()
+		if err := p.Start(context.Background()); err != nil {
+			t.Fatalf("Start() error = %v", err)
+		}
+		if runtime.MemProfileRate != 2048 {
+			t.Errorf("MemProfileRate = %d while running, want 2048", runtime.MemProfileRate)
+		}
+
+		want := 512 * 1024
+		if external {
+			// The host application tunes the rate mid-run
+			runtime.MemProfileRate = 1
+			want = 1
+		}
+		p.Stop()
+
+		if runtime.MemProfileRate != want {
+			t.Errorf("external change=%v: MemProfileRate = %d after Stop, want %d", external, runtime.MemProfileRate, want)
+		}
+	}
+}
+
+func TestScopedProfilerRejectsCPUAndTrace(t *testing.T) {
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       "https://api.pprofio.com",
+		Storage:         &captureStorage{},
+		ServiceName:     "test-service",
+		ProfileDuration: time.Second,
+		EnableGoroutine: true,
+		Scoped:          true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	results := make(chan []CollectionResult, 1)
+	go func() { results <- p.Flush(context.Background(), ProfileCPU, ProfileTrace) }()
+
+	// The host application can still start the CPU profiler while Flush runs
+	deadline := time.Now().Add(100 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		if err := pprof.StartCPUProfile(io.Discard); err != nil {
+			t.Fatalf("StartCPUProfile() during Flush error = %v; the scoped profiler took the CPU profiler", err)
+		}
+		pprof.StopCPUProfile()
+	}
+
+	for _, result := range <-results {
+		if result.Err == nil {
+			t.Errorf("Flush(%s) on a scoped profiler should return an error", result.Type)
+		}
+	}
+	if err := p.CollectTo(context.Background(), ProfileCPU, os.Stdout); err == nil {
+		t.Error("CollectTo(cpu) on a scoped profiler should return an error")
+	}
+}
+
+func TestScopedProfilerLeavesRuntimeRates(t *testing.T) {
+	metadataServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer metadataServer.Close()
+
+	originalMemRate := runtime.MemProfileRate
+	defer func() { runtime.MemProfileRate = originalMemRate }()
+	originalMutex := runtime.SetMutexProfileFraction(3)
+	defer runtime.SetMutexProfileFraction(originalMutex)
+
+	runtime.MemProfileRate = 1234
+
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       metadataServer.URL,
+		SampleRate:      20 * time.Millisecond,
+		Storage:         &captureStorage{},
+		ServiceName:     "test-service",
+		EnableGoroutine: true,
+		EnableCustom:    true,
+		Scoped:          true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if runtime.MemProfileRate != 1234 {
+		t.Errorf("MemProfileRate changed to %d while running", runtime.MemProfileRate)
+	}
+	if fraction := runtime.SetMutexProfileFraction(-1); fraction != 3 {
+		t.Errorf("Mutex fraction changed to %d while running", fraction)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	p.Stop()
+
+	if runtime.MemProfileRate != 1234 {
+		t.Errorf("MemProfileRate changed to %d after Stop", runtime.MemProfileRate)
+	}
+	if fraction := runtime.SetMutexProfileFraction(-1); fraction != 3 {
+		t.Errorf("Mutex fraction changed to %d after Stop", fraction)
+	}
+
+	// Profile types needing global runtime changes are rejected
+	_, err = New(Config{
+		APIKey:       "test-key",
+		IngestURL:    metadataServer.URL,
+		Storage:      &captureStorage{},
+		ServiceName:  "test-service",
+		EnableMemory: true,
+		Scoped:       true,
+	})
+	if err == nil {
+		t.Error("New() with Scoped and EnableMemory should return error")
+	}
+}
+
+func TestSecondCPUProfilerFailsToStart(t *testing.T) {
+	metadataServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer metadataServer.Close()
+
+	newCPUProfiler := func(service string) *Profiler {
+		p, err := New(Config{
+			APIKey:          "test-key",
+			IngestURL:       metadataServer.URL,
+			SampleRate:      time.Minute,
+			ProfileDuration: 10 * time.Millisecond,
+			Storage:         &captureStorage{},
+			ServiceName:     service,
+			EnableCPU:       true,
+		})
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		return p
+	}
+
+	first := newCPUProfiler("first")
+	second := newCPUProfiler("second")
+
+	if err := first.Start(context.Background()); err != nil {
+		t.Fatalf("first.Start() error = %v", err)
+	}
+
+	err := second.Start(context.Background())
+	if err == nil {
+		second.Stop()
+		t.Fatal("second.Start() should fail while another profiler owns CPU profiling")
+	}
+	if !strings.Contains(err.Error(), "CPU profiling is already active") || !strings.Contains(err.Error(), "first") {
+		t.Errorf("second.Start() error = %q, want a descriptive ownership error", err)
+	}
+
+	// Ownership is released on Stop
+	first.Stop()
+	if err := second.Start(context.Background()); err != nil {
+		t.Fatalf("second.Start() after first.Stop() error = %v", err)
+	}
+	second.Stop()
+}
+
+func TestHeapProfileMode(t *testing.T) {
+	metadataServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer metadataServer.Close()
+
+	testCases := []struct {
+		name string
+		mode HeapProfileMode
+		want []ProfileType
+	}{
+		{name: "Inuse", mode: HeapProfileInuse, want: []ProfileType{ProfileMemory}},
+		{name: "Alloc", mode: HeapProfileAlloc, want: []ProfileType{ProfileAllocs}},
+		{name: "Both", mode: HeapProfileBoth, want: []ProfileType{ProfileMemory, ProfileAllocs}},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			storage := &captureStorage{}
+			p, err := New(Config{
+				APIKey:          "test-key",
+				IngestURL:       metadataServer.URL,
+				Storage:         storage,
+				ServiceName:     "test-service",
+				EnableMemory:    true,
+				HeapProfileMode: tc.mode,
+			})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			results := p.Flush(context.Background())
+			if len(results) != len(tc.want) {
+				t.Fatalf("Flush() returned %d results, want %d", len(results), len(tc.want))
+			}
+			for i, result := range results {
+				if result.Type != tc.want[i] || result.Err != nil {
+					t.Errorf("results[%d] = %s (err %v), want %s", i, result.Type, result.Err, tc.want[i])
+				}
+			}
+
+			if len(storage.uploads) != len(tc.want) {
+				t.Errorf("Uploaded %d profiles, want %d", len(storage.uploads), len(tc.want))
+			}
+		})
+	}
+}
+
+func TestEnableAllocs(t *testing.T) {
+	testCases := []struct {
+		name   string
+		config Config
+		want   []profileType
+	}{
+		{name: "AllocsOnly", config: Config{EnableAllocs: true}, want: []profileType{profileTypeAllocs}},
+		{
+			name:   "WithHeap",
+			config: Config{EnableMemory: true, EnableAllocs: true},
+			want:   []profileType{profileTypeMemory, profileTypeAllocs},
+		},
+		{
+			name:   "NoDuplicate",
+			config: Config{EnableMemory: true, EnableAllocs: true, HeapProfileMode: HeapProfileBoth},
+			want:   []profileType{profileTypeMemory, profileTypeAllocs},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			tc.config.APIKey = "test-key"
+			tc.config.IngestURL = "https://api.pprofio.com"
+			tc.config.ServiceName = "test-service"
+			p, err := New(tc.config)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			got := p.enabledProfileTypes()
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("enabledProfileTypes() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDisableUnderTestCollectsNothing(t *testing.T) {
+	storage := &captureStorage{}
+	originalMemRate := runtime.MemProfileRate
+	defer func() { runtime.MemProfileRate = originalMemRate }()
+	runtime.MemProfileRate = 1234
+
+	p, err := New(Config{
+		APIKey:           "test-key",
+		IngestURL:        "https://api.pprofio.com",
+		SampleRate:       10 * time.Millisecond,
+		ProfileDuration:  5 * time.Millisecond,
+		Storage:          storage,
+		ServiceName:      "test-service",
+		EnableCPU:        true,
+		EnableMemory:     true,
+		EnableGoroutine:  true,
+		DisableUnderTest: true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := p.Start(context.Background()); err == nil {
+		t.Error("Expected second Start() to fail while running")
+	}
+	if runtime.MemProfileRate != 1234 {
+		t.Errorf("MemProfileRate changed to %d while running", runtime.MemProfileRate)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if results := p.Flush(context.Background()); len(results) != 0 {
+		t.Errorf("Flush() returned %d results, want none", len(results))
+	}
+	p.Stop()
+
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	if len(storage.uploads) != 0 {
+		t.Errorf("Expected no uploads, got %d", len(storage.uploads))
+	}
+	if runtime.MemProfileRate != 1234 {
+		t.Errorf("MemProfileRate changed to %d after Stop", runtime.MemProfileRate)
+	}
+}
+
+func TestAPIKeyFile(t *testing.T) {
+	var mu sync.Mutex
+	var authHeaders []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		authHeaders = append(authHeaders, r.Header.Get("Authorization"))
+		mu.Unlock()
+
+		if r.URL.Path == "/upload" {
+			w.Write([]byte(`{"profile_id":"p1","profile_url":"https://storage.pprofio.com/p1.pprof"}`))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	keyFile := filepath.Join(t.TempDir(), "api-key")
+	if err := os.WriteFile(keyFile, []byte("  file-key\n"), 0600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+
+	p, err := New(Config{
+		APIKeyFile:      keyFile,
+		IngestURL:       server.URL,
+		ServiceName:     "test-service",
+		EnableGoroutine: true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	results := p.Flush(context.Background())
+	if len(results) != 1 || results[0].Err != nil {
+		t.Fatalf("Flush() = %+v", results)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(authHeaders) != 2 {
+		t.Fatalf("Expected upload and metadata requests, got %d", len(authHeaders))
+	}
+	for _, header := range authHeaders {
+		if header != "Bearer file-key" {
+			t.Errorf("Authorization = %q, want %q", header, "Bearer file-key")
+		}
+	}
+
+	if _, err := New(Config{
+		APIKeyFile:  filepath.Join(t.TempDir(), "missing"),
+		IngestURL:   server.URL,
+		ServiceName: "test-service",
+	}); err == nil {
+		t.Error("New() with a missing key file should return an error")
+	}
+}
+
+func TestCollectToFile(t *testing.T) {
+	storage := &captureStorage{}
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       "https://api.pprofio.com",
+		ProfileDuration: 20 * time.Millisecond,
+		Storage:         storage,
+		ServiceName:     "test-service",
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	wantSampleType := map[ProfileType]string{
+		ProfileCPU:       "cpu",
+		ProfileMemory:    "inuse_space",
+		ProfileGoroutine: "goroutine",
+	}
+	for pt, sampleType := range wantSampleType {
+		f, err := os.CreateTemp(t.TempDir(), "collect-to")
+		if err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+
+		if err := p.CollectTo(context.Background(), pt, f); err != nil {
+			t.Fatalf("CollectTo(%s) error = %v", pt, err)
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			t.Fatalf("Seek() error = %v", err)
+		}
+		prof, err := profile.Parse(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("CollectTo(%s) wrote an invalid profile: %v", pt, err)
+		}
+
+		found := false
+		for _, st := range prof.SampleType {
+			if st.Type == sampleType {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("CollectTo(%s) sample types = %v, want %q", pt, prof.SampleType, sampleType)
+		}
+	}
+
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	if len(storage.uploads) != 0 {
+		t.Errorf("CollectTo uploaded %d profiles, want none", len(storage.uploads))
+	}
+
+	f, err := os.CreateTemp(t.TempDir(), "collect-to")
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	defer f.Close()
+	if err := p.CollectTo(context.Background(), ProfileType("bogus"), f); err == nil {
+		t.Error("CollectTo() with an unknown type should return an error")
+	}
+}
+
+// fileCheckingStorage records each temp file's content before delegating the
+// upload to Storage
+type fileCheckingStorage struct {
+	Storage
+	mu    sync.Mutex
+	files map[ProfileType][]byte
+}
+
+func (s *fileCheckingStorage) Upload(ctx context.Context, filePath string) (UploadResult, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return UploadResult{}, err
+	}
+	t, _ := ProfileTypeFromUploadContext(ctx)
+
+	s.mu.Lock()
+	s.files[t] = data
+	s.mu.Unlock()
+	return s.Storage.Upload(ctx, filePath)
+}
+
+func TestCollectedProfilesAreGzippedOnce(t *testing.T) {
+	var mu sync.Mutex
+	var bodies [][]byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/upload" {
+			body, _ := io.ReadAll(r.Body)
+			mu.Lock()
+			bodies = append(bodies, body)
+			mu.Unlock()
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	storage := &fileCheckingStorage{
+		Storage: NewHTTPStorage(server.URL+"/upload", "test-key", "local"),
+		files:   make(map[ProfileType][]byte),
+	}
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       server.URL,
+		Storage:         storage,
+		ServiceName:     "test-service",
+		EnableGoroutine: true,
+		EnableMutex:     true,
+		EnableBlock:     true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	for _, pt := range []profileType{profileTypeGoroutine, profileTypeMutex, profileTypeBlock} {
+		mu.Lock()
+		bodies = nil
+		mu.Unlock()
+
+		if _, err := p.collectProfile(context.Background(), pt); err != nil {
+			t.Fatalf("collectProfile(%s) error = %v", pt, err)
+		}
+
+		file := storage.files[pt]
+		if !bytes.HasPrefix(file, gzipMagic) {
+			t.Errorf("%s temp file is not gzip-compressed", pt)
+		}
+
+		mu.Lock()
+		body := bodies[0]
+		mu.Unlock()
+		if !bytes.Equal(body, file) {
+			t.Errorf("%s profile was re-compressed on upload", pt)
+		}
+		gr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("gzip.NewReader(%s) error = %v", pt, err)
+		}
+		decoded, _ := io.ReadAll(gr)
+		if _, err := profile.ParseUncompressed(decoded); err != nil {
+			t.Errorf("%s upload does not decode with a single gunzip: %v", pt, err)
+		}
+	}
+}
+
+func TestDisableMemSampling(t *testing.T) {
+	metadataServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer metadataServer.Close()
+
+	original := runtime.MemProfileRate
+	defer func() { runtime.MemProfileRate = original }()
+	runtime.MemProfileRate = 512 * 1024
+
+	storage := &captureStorage{}
+	p, err := New(Config{
+		APIKey:             "test-key",
+		IngestURL:          metadataServer.URL,
+		SampleRate:         time.Hour,
+		Storage:            storage,
+		ServiceName:        "test-service",
+		EnableMemory:       true,
+		DisableMemSampling: true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if p.config.MemProfileRate != DefaultMemProfileRate {
+		t.Errorf("MemProfileRate = %d, want the default left in place", p.config.MemProfileRate)
+	}
+
+	p.Pause()
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if runtime.MemProfileRate != 0 {
+		t.Errorf("runtime.MemProfileRate = %d while running, want 0", runtime.MemProfileRate)
+	}
+
+	// Inuse snapshots are still collected
+	for _, r := range p.Flush(context.Background(), ProfileMemory) {
+		if r.Err != nil {
+			t.Fatalf("Flush() error = %v", r.Err)
+		}
+	}
+	if storage.count() != 1 {
+		t.Errorf("Expected 1 memory profile upload, got %d", storage.count())
+	}
+
+	p.Stop()
+	if runtime.MemProfileRate != 512*1024 {
+		t.Errorf("runtime.MemProfileRate = %d after Stop, want %d", runtime.MemProfileRate, 512*1024)
+	}
+
+	scoped := Config{
+		APIKey:             "test-key",
+		IngestURL:          metadataServer.URL,
+		Storage:            storage,
+		ServiceName:        "test-service",
+		Scoped:             true,
+		DisableMemSampling: true,
+	}
+	if err := scoped.validate(); err == nil {
+		t.Error("validate() should reject DisableMemSampling on a scoped profiler")
+	}
+}
+
+func TestStartWithCancelledContextCollectsNothing(t *testing.T) {
+	for _, snapshots := range []bool{false, true} {
+		storage := &captureStorage{}
+		p, err := New(Config{
+			APIKey:          "test-key",
+			IngestURL:       "http://localhost",
+			SampleRate:      time.Hour,
+			Storage:         storage,
+			ServiceName:     "test-service",
+			EnableGoroutine: true,
+			Snapshots:       snapshots,
+		})
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := p.Start(ctx); err != nil {
+			t.Fatalf("Start() error = %v", err)
+		}
+		p.Stop()
+
+		if n := storage.count(); n != 0 {
+			t.Errorf("Snapshots=%v: uploaded %d profiles with a cancelled context, want 0", snapshots, n)
+		}
+	}
+}
+
+func TestHeapGCEveryN(t *testing.T) {
+	metadataServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer metadataServer.Close()
+
+	gcs := 0
+	forceGC = func() { gcs++ }
+	defer func() { forceGC = runtime.GC }()
+
+	storage := &captureStorage{}
+	p, err := New(Config{
+		APIKey:       "test-key",
+		IngestURL:    metadataServer.URL,
+		Storage:      storage,
+		ServiceName:  "test-service",
+		EnableMemory: true,
+		HeapGCEveryN: 3,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	// Collections 1 and 4 force a GC; 2, 3 and 5 reuse the last one
+	want := []int{1, 1, 1, 2, 2}
+	for i, w := range want {
+		if results := p.Flush(context.Background(), ProfileMemory); results[0].Err != nil {
+			t.Fatalf("Flush() error = %v", results[0].Err)
+		}
+		if gcs != w {
+			t.Errorf("After collection %d, forced %d GCs, want %d", i+1, gcs, w)
+		}
+	}
+	if n := storage.count(); n != len(want) {
+		t.Errorf("Uploaded %d memory profiles, want %d", n, len(want))
+	}
+}
+
+func TestMemoryInterval(t *testing.T) {
+	metadataServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer metadataServer.Close()
+
+	for _, snapshots := range []bool{false, true} {
+		t.Run(fmt.Sprintf("snapshots=%v", snapshots), func(t *testing.T) {
+			p, err := New(Config{
+				APIKey:          "test-key",
+				IngestURL:       metadataServer.URL,
+				SampleRate:      20 * time.Millisecond,
+				ProfileDuration: 5 * time.Millisecond,
+				MemoryInterval:  time.Hour,
+				Storage:         &captureStorage{},
+				ServiceName:     "test-service",
+				EnableCPU:       true,
+				EnableMemory:    true,
+				Snapshots:       snapshots,
+			})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			if err := p.Start(context.Background()); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			waitFor(t, func() bool { return p.Stats().Types[ProfileCPU].ProfilesCollected >= 4 })
+			p.Stop()
+
+			if got := p.Stats().Types[ProfileMemory].ProfilesCollected; got != 1 {
+				t.Errorf("memory profiles collected = %d, want 1 within MemoryInterval", got)
+			}
+		})
+	}
+}
+
+func TestProfileOptions(t *testing.T) {
+	metadataServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer metadataServer.Close()
+
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       metadataServer.URL,
+		SampleRate:      time.Hour,
+		ProfileDuration: 5 * time.Millisecond,
+		ProfileOptions: map[ProfileType]ProfileOptions{
+			ProfileGoroutine: {SampleRate: 10 * time.Millisecond},
+		},
+		Storage:         &captureStorage{},
+		ServiceName:     "test-service",
+		EnableGoroutine: true,
+		EnableMutex:     true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	waitFor(t, func() bool { return p.Stats().Types[ProfileGoroutine].ProfilesCollected >= 3 })
+	p.Stop()
+
+	if got := p.Stats().Types[ProfileMutex].ProfilesCollected; got != 1 {
+		t.Errorf("mutex profiles collected = %d, want 1 on the global SampleRate", got)
+	}
+}
+
+func TestProfileOptionsFallback(t *testing.T) {
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       "https://api.pprofio.com",
+		Storage:         &captureStorage{},
+		ServiceName:     "test-service",
+		SampleRate:      time.Minute,
+		ProfileDuration: 10 * time.Second,
+		EnableTrace:     true,
+		TraceInterval:   10 * time.Minute,
+		ProfileOptions: map[ProfileType]ProfileOptions{
+			ProfileCPU:   {SampleRate: 20 * time.Second, Duration: 5 * time.Second},
+			ProfileTrace: {SampleRate: 2 * time.Minute},
+			ProfileBlock: {},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	tests := []struct {
+		profileType  ProfileType
+		wantInterval time.Duration
+		wantDuration time.Duration
+	}{
+		{ProfileCPU, 20 * time.Second, 5 * time.Second},
+		{ProfileTrace, 2 * time.Minute, 10 * time.Second},
+		{ProfileBlock, time.Minute, 10 * time.Second},
+		{ProfileMutex, time.Minute, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := p.interval(tt.profileType); got != tt.wantInterval {
+			t.Errorf("interval(%s) = %v, want %v", tt.profileType, got, tt.wantInterval)
+		}
+		if got := p.profileDuration(tt.profileType); got != tt.wantDuration {
+			t.Errorf("profileDuration(%s) = %v, want %v", tt.profileType, got, tt.wantDuration)
+		}
+	}
+}
+
+func TestMemoryIntervalDefault(t *testing.T) {
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       "https://api.pprofio.com",
+		Storage:         &captureStorage{},
+		ServiceName:     "test-service",
+		SampleRate:      time.Second,
+		ProfileDuration: 500 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if got := p.interval(ProfileMemory); got != DefaultMemoryInterval {
+		t.Errorf("interval(memory) = %v, want %v", got, DefaultMemoryInterval)
+	}
+	if got := p.interval(ProfileCPU); got != time.Second {
+		t.Errorf("interval(cpu) = %v, want SampleRate", got)
+	}
+}
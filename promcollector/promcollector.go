@@ -0,0 +1,110 @@
+// Package promcollector exposes a pprofio Profiler's own counters as
+// Prometheus metrics.
+//
+//	prometheus.MustRegister(promcollector.New(p))
+//
+// The collector reads Profiler.Stats on each scrape. client_golang is
+// imported only by this package, so programs that do not use it never link
+// the Prometheus client.
+package promcollector
+
+import (
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/pprofio/pprofio"
+)
+
+var (
+	collectedDesc = prometheus.NewDesc(
+		"pprofio_profiles_collected_total",
+		"Profiles handed to storage for upload.",
+		[]string{"type"}, nil)
+	uploadedDesc = prometheus.NewDesc(
+		"pprofio_profiles_uploaded_total",
+		"Profiles accepted by storage.",
+		[]string{"type"}, nil)
+	failuresDesc = prometheus.NewDesc(
+		"pprofio_upload_failures_total",
+		"Profiles rejected by storage.",
+		[]string{"type"}, nil)
+	bytesDesc = prometheus.NewDesc(
+		"pprofio_uploaded_bytes_total",
+		"Size of the profiles accepted by storage.",
+		[]string{"type"}, nil)
+	collectionSecondsDesc = prometheus.NewDesc(
+		"pprofio_collection_seconds_total",
+		"Time spent collecting and uploading profiles.",
+		[]string{"type"}, nil)
+	lastUploadDesc = prometheus.NewDesc(
+		"pprofio_last_upload_timestamp_seconds",
+		"Unix time of the last profile accepted by storage.",
+		[]string{"type"}, nil)
+	spansFlushedDesc = prometheus.NewDesc(
+		"pprofio_spans_flushed_total",
+		"Custom spans included in uploaded span profiles.",
+		nil, nil)
+	budgetSkipsDesc = prometheus.NewDesc(
+		"pprofio_budget_skips_total",
+		"Scheduled collections skipped by MaxUploadsPerHour.",
+		nil, nil)
+	idleCPUSkipsDesc = prometheus.NewDesc(
+		"pprofio_idle_cpu_skips_total",
+		"Scheduled CPU profiles skipped by MinCPUUsage.",
+		nil, nil)
+	queueDropsDesc = prometheus.NewDesc(
+		"pprofio_upload_queue_drops_total",
+		"Queued profiles dropped because the upload queue was full.",
+		nil, nil)
+)
+
+// Collector reads the profiler's Stats on every scrape
+type Collector struct {
+	profiler *pprofio.Profiler
+}
+
+// New returns a Collector for p's counters.
+func New(p *pprofio.Profiler) *Collector {
+	return &Collector{profiler: p}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		collectedDesc, uploadedDesc, failuresDesc, bytesDesc, collectionSecondsDesc,
+		lastUploadDesc, spansFlushedDesc, budgetSkipsDesc, idleCPUSkipsDesc, queueDropsDesc,
+	} {
+		ch <- desc
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.profiler.Stats()
+
+	types := make([]pprofio.ProfileType, 0, len(stats.Types))
+	for t := range stats.Types {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+
+	for _, t := range types {
+		s := stats.Types[t]
+		label := string(t)
+		ch <- prometheus.MustNewConstMetric(collectedDesc, prometheus.CounterValue, float64(s.ProfilesCollected), label)
+		ch <- prometheus.MustNewConstMetric(uploadedDesc, prometheus.CounterValue, float64(s.ProfilesUploaded), label)
+		ch <- prometheus.MustNewConstMetric(failuresDesc, prometheus.CounterValue, float64(s.UploadFailures), label)
+		ch <- prometheus.MustNewConstMetric(bytesDesc, prometheus.CounterValue, float64(s.BytesUploaded), label)
+		ch <- prometheus.MustNewConstMetric(collectionSecondsDesc, prometheus.CounterValue, s.CollectionTime.Seconds(), label)
+		if !s.LastUploadTime.IsZero() {
+			ch <- prometheus.MustNewConstMetric(lastUploadDesc, prometheus.GaugeValue,
+				float64(s.LastUploadTime.UnixNano())/1e9, label)
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(spansFlushedDesc, prometheus.CounterValue, float64(stats.SpansFlushed))
+	ch <- prometheus.MustNewConstMetric(budgetSkipsDesc, prometheus.CounterValue, float64(stats.BudgetSkips))
+	ch <- prometheus.MustNewConstMetric(idleCPUSkipsDesc, prometheus.CounterValue, float64(stats.IdleCPUSkips))
+	ch <- prometheus.MustNewConstMetric(queueDropsDesc, prometheus.CounterValue, float64(stats.UploadQueueDrops))
+}
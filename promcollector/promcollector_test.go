@@ -0,0 +1,85 @@
+package promcollector
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/pprofio/pprofio"
+)
+
+func TestCollectorGathersProfilerStats(t *testing.T) {
+	ingest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ingest.Close()
+
+	p, err := pprofio.New(pprofio.Config{
+		APIKey:          "test-key",
+		IngestURL:       ingest.URL,
+		SampleRate:      time.Hour,
+		Storage:         &pprofio.FileStorage{Directory: t.TempDir()},
+		ServiceName:     "test-service",
+		EnableGoroutine: true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if results := p.Flush(context.Background(), pprofio.ProfileGoroutine); results[0].Err != nil {
+			t.Fatalf("Flush() error = %v", results[0].Err)
+		}
+	}
+
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(New(p)); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+
+	got := make(map[string]*dto.Metric)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			got[family.GetName()+labelSuffix(metric)] = metric
+		}
+	}
+
+	if m := got["pprofio_profiles_uploaded_total{type=goroutine}"]; m.GetCounter().GetValue() != 2 {
+		t.Errorf("pprofio_profiles_uploaded_total{type=goroutine} = %v, want 2", m)
+	}
+	if m := got["pprofio_upload_failures_total{type=goroutine}"]; m == nil || m.GetCounter().GetValue() != 0 {
+		t.Errorf("pprofio_upload_failures_total{type=goroutine} = %v, want 0", m)
+	}
+	if m := got["pprofio_uploaded_bytes_total{type=goroutine}"]; m.GetCounter().GetValue() <= 0 {
+		t.Errorf("pprofio_uploaded_bytes_total{type=goroutine} = %v, want > 0", m)
+	}
+	if m := got["pprofio_collection_seconds_total{type=goroutine}"]; m.GetCounter().GetValue() <= 0 {
+		t.Errorf("pprofio_collection_seconds_total{type=goroutine} = %v, want > 0", m)
+	}
+	if m := got["pprofio_last_upload_timestamp_seconds{type=goroutine}"]; m.GetGauge().GetValue() <= 0 {
+		t.Errorf("pprofio_last_upload_timestamp_seconds{type=goroutine} = %v, want > 0", m)
+	}
+	if _, ok := got["pprofio_budget_skips_total"]; !ok {
+		t.Error("pprofio_budget_skips_total not gathered")
+	}
+}
+
+func labelSuffix(m *dto.Metric) string {
+	if len(m.GetLabel()) == 0 {
+		return ""
+	}
+	suffix := "{"
+	for i, label := range m.GetLabel() {
+		if i > 0 {
+			suffix += ","
+		}
+		suffix += label.GetName() + "=" + label.GetValue()
+	}
+	return suffix + "}"
+}
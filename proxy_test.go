@@ -0,0 +1,177 @@
+package pprofio
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// proxyConfig returns a config uploading to an unresolvable ingest host, so
+// requests only succeed when they go through the proxy
+func proxyConfig(proxyURL string) Config {
+	return Config{
+		APIKey:          "test-key",
+		IngestURL:       "http://ingest.pprofio.invalid",
+		Env:             "local",
+		SampleRate:      time.Hour,
+		ServiceName:     "test-service",
+		EnableGoroutine: true,
+		ProxyURL:        proxyURL,
+	}
+}
+
+func TestHTTPProxy(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.URL.String())
+		mu.Unlock()
+		if r.URL.Path == "/upload" {
+			w.Write([]byte(`{"profile_url":"https://storage.pprofio.com/p1.pprof"}`))
+		}
+	}))
+	defer proxy.Close()
+
+	p, err := New(proxyConfig(proxy.URL))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if results := p.Flush(context.Background(), ProfileGoroutine); results[0].Err != nil {
+		t.Fatalf("Flush() error = %v", results[0].Err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"http://ingest.pprofio.invalid/upload", "http://ingest.pprofio.invalid/metadata"}
+	if len(requests) != len(want) || requests[0] != want[0] || requests[1] != want[1] {
+		t.Errorf("proxy received %v, want %v", requests, want)
+	}
+}
+
+func TestSOCKS5Proxy(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.Host+r.URL.Path)
+		mu.Unlock()
+		if r.URL.Path == "/upload" {
+			w.Write([]byte(`{"profile_url":"https://storage.pprofio.com/p1.pprof"}`))
+		}
+	}))
+	defer server.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer listener.Close()
+
+	var targets []string
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSOCKS5(conn, server.Listener.Addr().String(), func(target string) {
+				mu.Lock()
+				targets = append(targets, target)
+				mu.Unlock()
+			})
+		}
+	}()
+
+	p, err := New(proxyConfig("socks5://" + listener.Addr().String()))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if results := p.Flush(context.Background(), ProfileGoroutine); results[0].Err != nil {
+		t.Fatalf("Flush() error = %v", results[0].Err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(paths) != 2 || paths[0] != "ingest.pprofio.invalid/upload" || paths[1] != "ingest.pprofio.invalid/metadata" {
+		t.Errorf("server received %v, want the upload and its metadata", paths)
+	}
+	for _, target := range targets {
+		if target != "ingest.pprofio.invalid:80" {
+			t.Errorf("SOCKS5 CONNECT target = %q, want ingest.pprofio.invalid:80", target)
+		}
+	}
+	if len(targets) == 0 {
+		t.Error("no connections went through the SOCKS5 proxy")
+	}
+}
+
+// serveSOCKS5 handles one no-auth SOCKS5 CONNECT, relaying the connection to
+// upstream whatever the requested target, which is passed to onConnect.
+func serveSOCKS5(conn net.Conn, upstream string, onConnect func(target string)) error {
+	defer conn.Close()
+
+	// Greeting: version, method count, methods
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(conn, make([]byte, header[1])); err != nil {
+		return err
+	}
+	if _, err := conn.Write([]byte{5, 0}); err != nil {
+		return err
+	}
+
+	// Request: version, command, reserved, address type, address, port
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return err
+	}
+	var host string
+	switch request[3] {
+	case 1:
+		addr := make([]byte, 4)
+		if _, err := io.ReadFull(conn, addr); err != nil {
+			return err
+		}
+		host = net.IP(addr).String()
+	case 3:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return err
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return err
+		}
+		host = string(name)
+	default:
+		return io.ErrUnexpectedEOF
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return err
+	}
+	onConnect(net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+
+	backend, err := net.Dial("tcp", upstream)
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+	if _, err := conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}); err != nil {
+		return err
+	}
+
+	go io.Copy(backend, conn)
+	io.Copy(conn, backend)
+	return nil
+}
@@ -0,0 +1,196 @@
+package pprofio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+// pyroscopeTypes maps profile types to the names Pyroscope expects as the
+// application name suffix, matching the default sample type of each profile
+var pyroscopeTypes = map[ProfileType]string{
+	ProfileCPU:       "cpu",
+	ProfileMemory:    "inuse_space",
+	ProfileAllocs:    "alloc_space",
+	ProfileGoroutine: "goroutines",
+	ProfileMutex:     "mutex_duration",
+	ProfileBlock:     "block_duration",
+	ProfileCustom:    "custom",
+}
+
+// pyroscopeCPUSampleRate is the rate of Go's CPU profiler in samples per second
+const pyroscopeCPUSampleRate = 100
+
+// PyroscopeStorage uploads profiles to a Grafana Pyroscope server's /ingest
+// endpoint in pprof format. The application name is the service name with
+// the Pyroscope profile type appended and the profile's tags as labels,
+// e.g. "checkout.cpu{env=prod}". The from and until parameters cover the
+// profile's sampling window.
+type PyroscopeStorage struct {
+	// URL is the Pyroscope server's base URL, e.g. "https://pyroscope.example.com"
+	URL    string
+	Client *http.Client
+
+	// AuthToken is sent as a bearer token. BasicAuthUser and
+	// BasicAuthPassword are used instead when set, as for Grafana Cloud.
+	AuthToken         string
+	BasicAuthUser     string
+	BasicAuthPassword string
+}
+
+// NewPyroscopeStorage creates a storage uploading to the Pyroscope server at url
+func NewPyroscopeStorage(url string) *PyroscopeStorage {
+	return &PyroscopeStorage{
+		URL:    url,
+		Client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Upload sends the profile as a multipart "profile" field to /ingest
+func (s *PyroscopeStorage) Upload(ctx context.Context, filePath string) (UploadResult, error) {
+	if s.URL == "" {
+		return UploadResult{}, errors.New("URL is required")
+	}
+	baseURL, err := url.Parse(s.URL)
+	if err != nil {
+		return UploadResult{}, fmt.Errorf("invalid URL: %w", err)
+	}
+	if baseURL.Scheme != "https" && !isLoopback(baseURL) {
+		return UploadResult{}, errors.New("HTTPS is required for secure uploads")
+	}
+
+	t, ok := ProfileTypeFromUploadContext(ctx)
+	if !ok {
+		t = ProfileType(profileTypeFromPath(filePath))
+	}
+	pyroscopeType, ok := pyroscopeTypes[t]
+	if !ok {
+		return UploadResult{}, fmt.Errorf("%s profiles are not supported by Pyroscope", t)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return UploadResult{}, fmt.Errorf("failed to read profile file: %w", err)
+	}
+
+	service, _ := ServiceNameFromUploadContext(ctx)
+	tags, _ := TagsFromUploadContext(ctx)
+	from, until := profileWindow(data)
+
+	query := url.Values{}
+	query.Set("name", pyroscopeAppName(service, pyroscopeType, tags))
+	query.Set("from", strconv.FormatInt(from.Unix(), 10))
+	query.Set("until", strconv.FormatInt(until.Unix(), 10))
+	query.Set("format", "pprof")
+	query.Set("spyName", "pprofio")
+	if t == ProfileCPU {
+		query.Set("sampleRate", strconv.Itoa(pyroscopeCPUSampleRate))
+	}
+	ingestURL := strings.TrimSuffix(s.URL, "/") + "/ingest?" + query.Encode()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("profile", "profile.pprof")
+	if err != nil {
+		return UploadResult{}, fmt.Errorf("failed to create multipart body: %w", err)
+	}
+	if _, err := part.Write(data); err != nil {
+		return UploadResult{}, fmt.Errorf("failed to create multipart body: %w", err)
+	}
+	if err := form.Close(); err != nil {
+		return UploadResult{}, fmt.Errorf("failed to create multipart body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ingestURL, &body)
+	if err != nil {
+		return UploadResult{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if s.BasicAuthUser != "" {
+		req.SetBasicAuth(s.BasicAuthUser, s.BasicAuthPassword)
+	} else if s.AuthToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.AuthToken)
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return UploadResult{}, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return UploadResult{}, responseError("unexpected status code", resp)
+	}
+
+	return UploadResult{ProfileURL: "pyroscope", Type: string(t), Size: int64(len(data))}, nil
+}
+
+// profileWindow returns the time range a profile covers. Snapshot profiles
+// such as heap or goroutine profiles have no duration, so they cover the
+// second they were taken in. Profiles that cannot be parsed are treated as
+// taken now.
+func profileWindow(data []byte) (from, until time.Time) {
+	from = time.Now()
+	var duration time.Duration
+	if p, err := profile.ParseData(data); err == nil && p.TimeNanos > 0 {
+		from = time.Unix(0, p.TimeNanos)
+		duration = time.Duration(p.DurationNanos)
+	}
+
+	until = from.Add(duration)
+	if until.Unix() <= from.Unix() {
+		until = from.Add(time.Second)
+	}
+	return from, until
+}
+
+// pyroscopeAppName builds a Pyroscope application name with the tags as
+// labels in key order, e.g. "checkout.cpu{env=prod,region=eu}".
+func pyroscopeAppName(service, pyroscopeType string, tags map[string]string) string {
+	if service == "" {
+		service = "unknown"
+	}
+	name := pyroscopeLabel(service) + "." + pyroscopeType
+	if len(tags) == 0 {
+		return name
+	}
+
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	labels := make([]string, 0, len(keys))
+	for _, k := range keys {
+		labels = append(labels, pyroscopeLabel(k)+"="+pyroscopeLabel(tags[k]))
+	}
+	return name + "{" + strings.Join(labels, ",") + "}"
+}
+
+// pyroscopeLabel replaces the characters that delimit labels in an
+// application name
+func pyroscopeLabel(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '{', '}', ',', '=', ' ':
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,131 @@
+package pprofio
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+func writeTestProfile(t *testing.T, start time.Time, duration time.Duration) string {
+	t.Helper()
+	prof := &profile.Profile{
+		SampleType:    []*profile.ValueType{{Type: "samples", Unit: "count"}},
+		TimeNanos:     start.UnixNano(),
+		DurationNanos: int64(duration),
+	}
+	path := filepath.Join(t.TempDir(), "cpu.pprof")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	defer f.Close()
+	if err := prof.Write(f); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	return path
+}
+
+func TestPyroscopeStorageUpload(t *testing.T) {
+	var query map[string][]string
+	var auth string
+	var uploaded []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ingest" || r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		query = r.URL.Query()
+		auth = r.Header.Get("Authorization")
+
+		file, _, err := r.FormFile("profile")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		uploaded, _ = io.ReadAll(file)
+	}))
+	defer server.Close()
+
+	start := time.Unix(1700000000, 0)
+	path := writeTestProfile(t, start, 10*time.Second)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+
+	storage := NewPyroscopeStorage(server.URL)
+	storage.AuthToken = "secret"
+	ctx := withUploadContext(context.Background(), "checkout",
+		map[string]string{"env": "prod", "region": "eu-west"}, ProfileCPU)
+
+	result, err := storage.Upload(ctx, path)
+	if err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	if result.Type != "cpu" || result.Size != int64(len(data)) {
+		t.Errorf("Upload() = %+v", result)
+	}
+
+	want := map[string]string{
+		"name":       "checkout.cpu{env=prod,region=eu-west}",
+		"from":       "1700000000",
+		"until":      "1700000010",
+		"format":     "pprof",
+		"sampleRate": "100",
+	}
+	for k, v := range want {
+		if got := query[k]; len(got) != 1 || got[0] != v {
+			t.Errorf("query %s = %v, want %q", k, got, v)
+		}
+	}
+	if auth != "Bearer secret" {
+		t.Errorf("Authorization = %q, want bearer token", auth)
+	}
+	if !bytes.Equal(uploaded, data) {
+		t.Error("Multipart profile field does not match the profile file")
+	}
+}
+
+func TestPyroscopeStorageProfileTypes(t *testing.T) {
+	var name, from, until string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name = r.URL.Query().Get("name")
+		from = r.URL.Query().Get("from")
+		until = r.URL.Query().Get("until")
+	}))
+	defer server.Close()
+
+	storage := NewPyroscopeStorage(server.URL)
+	storage.BasicAuthUser = "user"
+
+	// Snapshot profiles have no duration, so they cover one second
+	path := writeTestProfile(t, time.Unix(1700000000, 0), 0)
+	ctx := withUploadContext(context.Background(), "checkout", nil, ProfileMemory)
+	if _, err := storage.Upload(ctx, path); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	if name != "checkout.inuse_space" || from != "1700000000" || until != "1700000001" {
+		t.Errorf("name, from, until = %q, %q, %q", name, from, until)
+	}
+
+	ctx = withUploadContext(context.Background(), "checkout", nil, profileTypeTrace)
+	if _, err := storage.Upload(ctx, path); err == nil {
+		t.Error("Upload() of an execution trace should fail")
+	}
+}
+
+func TestPyroscopeStorageRequiresHTTPS(t *testing.T) {
+	path := writeTestProfile(t, time.Now(), 0)
+	ctx := withUploadContext(context.Background(), "checkout", nil, ProfileCPU)
+	if _, err := NewPyroscopeStorage("http://pyroscope.example.com").Upload(ctx, path); err == nil {
+		t.Error("Upload() over plain HTTP to a remote host should fail")
+	}
+}
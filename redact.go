@@ -0,0 +1,65 @@
+package pprofio
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// redactTags returns tags with the values of RedactTags keys replaced by
+// their hash. tags itself is returned when none of its keys are redacted.
+func (p *Profiler) redactTags(tags map[string]string) map[string]string {
+	redacted, _ := redact(tags, p.config.RedactTags)
+	return redacted
+}
+
+// redactSpanTags returns spans with RedactTags values hashed. Spans carrying
+// a redacted tag are copied so the caller's spans are left untouched.
+func (p *Profiler) redactSpanTags(spans map[string][]*Span) map[string][]*Span {
+	if len(p.config.RedactTags) == 0 {
+		return spans
+	}
+
+	redacted := make(map[string][]*Span, len(spans))
+	for name, named := range spans {
+		out := make([]*Span, len(named))
+		for i, span := range named {
+			out[i] = span
+			if tags, changed := redact(span.Tags, p.config.RedactTags); changed {
+				copied := *span
+				copied.Tags = tags
+				out[i] = &copied
+			}
+		}
+		redacted[name] = out
+	}
+	return redacted
+}
+
+// redact hashes the values of keys in tags, copying tags first. It returns
+// tags unchanged and false when none of the keys are present.
+func redact(tags map[string]string, keys []string) (map[string]string, bool) {
+	var redacted map[string]string
+	for _, key := range keys {
+		value, ok := tags[key]
+		if !ok {
+			continue
+		}
+		if redacted == nil {
+			redacted = make(map[string]string, len(tags))
+			for k, v := range tags {
+				redacted[k] = v
+			}
+		}
+		redacted[key] = hashTagValue(value)
+	}
+	if redacted == nil {
+		return tags, false
+	}
+	return redacted, true
+}
+
+// hashTagValue returns the hex SHA-256 hash of value
+func hashTagValue(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
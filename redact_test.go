@@ -0,0 +1,86 @@
+package pprofio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+func TestRedactTags(t *testing.T) {
+	var mu sync.Mutex
+	var metadata []map[string]string
+	ingest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&m); err == nil {
+			mu.Lock()
+			metadata = append(metadata, m)
+			mu.Unlock()
+		}
+	}))
+	defer ingest.Close()
+
+	storage := &captureStorage{}
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       ingest.URL,
+		SampleRate:      time.Hour,
+		Storage:         storage,
+		ServiceName:     "test-service",
+		Tags:            map[string]string{"user_email": "jane@example.com", "env": "prod"},
+		RedactTags:      []string{"user_email"},
+		EnableGoroutine: true,
+		EnableCustom:    true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	_, span := StartSpan(WithProfiler(context.Background(), p), "handle_request",
+		"user_email", "jane@example.com", "route", "/checkout")
+	span.End()
+
+	for _, result := range p.Flush(context.Background(), ProfileGoroutine, ProfileCustom) {
+		if result.Err != nil {
+			t.Fatalf("Flush(%s) error = %v", result.Type, result.Err)
+		}
+	}
+
+	hashed := hashTagValue("jane@example.com")
+	mu.Lock()
+	defer mu.Unlock()
+	if len(metadata) != 2 {
+		t.Fatalf("Received %d metadata requests, want 2", len(metadata))
+	}
+	for _, m := range metadata {
+		if m["user_email"] != hashed {
+			t.Errorf("%s metadata user_email = %q, want its hash %q", m["type"], m["user_email"], hashed)
+		}
+		if m["env"] != "prod" {
+			t.Errorf("%s metadata env = %q, want unredacted %q", m["type"], m["env"], "prod")
+		}
+	}
+
+	storage.mu.Lock()
+	custom, err := profile.Parse(bytes.NewReader(storage.uploads[1]))
+	storage.mu.Unlock()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	labels := custom.Sample[0].Label
+	if got := labels["user_email"]; len(got) != 1 || got[0] != hashed {
+		t.Errorf("Span label user_email = %v, want its hash", got)
+	}
+	if got := labels["route"]; len(got) != 1 || got[0] != "/checkout" {
+		t.Errorf("Span label route = %v, want unredacted", got)
+	}
+	if span.Tags["user_email"] != "jane@example.com" {
+		t.Error("Redaction should not modify the caller's span")
+	}
+}
@@ -0,0 +1,72 @@
+package pprofio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// collectReplay uploads the next profile of the given type from ReplayDir in
+// place of sampling the runtime. Files are matched to types by name (e.g.
+// "cpu-001.pprof"), replayed in name order, and wrap around once exhausted.
+func (p *Profiler) collectReplay(ctx context.Context, profileType profileType) (CollectionResult, error) {
+	src, err := p.nextReplayFile(profileType)
+	if err != nil {
+		return CollectionResult{}, err
+	}
+
+	f, err := p.createTempFile(profileType)
+	if err != nil {
+		return CollectionResult{}, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer p.releaseTempFile(f.Name(), profileType)
+
+	if err := copyFile(f, src); err != nil {
+		f.Close()
+		return CollectionResult{}, fmt.Errorf("failed to copy replay profile %s: %w", filepath.Base(src), err)
+	}
+	f.Close()
+
+	ctx = withExtraMetadata(ctx, map[string]string{"replay": "true", "replay_file": filepath.Base(src)})
+	return p.uploadProfile(ctx, f.Name(), string(profileType))
+}
+
+// nextReplayFile returns the path of the next replay file for profileType.
+// The directory is listed on every call so files added later are picked up.
+func (p *Profiler) nextReplayFile(profileType profileType) (string, error) {
+	entries, err := os.ReadDir(p.config.ReplayDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to read replay directory: %w", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && profileTypeFromPath(entry.Name()) == string(profileType) {
+			names = append(names, entry.Name())
+		}
+	}
+	if len(names) == 0 {
+		return "", fmt.Errorf("no %s profiles to replay in %s", profileType, p.config.ReplayDir)
+	}
+	sort.Strings(names)
+
+	p.replayMu.Lock()
+	defer p.replayMu.Unlock()
+	i := p.replayNext[profileType] % len(names)
+	p.replayNext[profileType] = i + 1
+	return filepath.Join(p.config.ReplayDir, names[i]), nil
+}
+
+func copyFile(dst io.Writer, path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	_, err = io.Copy(dst, src)
+	return err
+}
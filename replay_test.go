@@ -0,0 +1,115 @@
+package pprofio
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReplayDirUploadsFixturesInOrder(t *testing.T) {
+	dir := t.TempDir()
+	fixtures := map[string]string{
+		"cpu-001.pprof":       "cpu one",
+		"cpu-002.pprof":       "cpu two",
+		"goroutine-001.pprof": "goroutine one",
+		"notes.txt":           "ignored",
+	}
+	for name, data := range fixtures {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+
+	var mu sync.Mutex
+	var metadata []map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m map[string]string
+		json.NewDecoder(r.Body).Decode(&m)
+		mu.Lock()
+		metadata = append(metadata, m)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	storage := &captureStorage{}
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       server.URL,
+		SampleRate:      time.Hour,
+		Storage:         storage,
+		ServiceName:     "test-service",
+		EnableCPU:       true,
+		EnableGoroutine: true,
+		ReplayDir:       dir,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	// Three CPU collections wrap around the two CPU fixtures
+	for i := 0; i < 3; i++ {
+		for _, r := range p.Flush(context.Background(), ProfileCPU) {
+			if r.Err != nil {
+				t.Fatalf("Flush() error = %v", r.Err)
+			}
+		}
+	}
+	p.Flush(context.Background(), ProfileGoroutine)
+
+	storage.mu.Lock()
+	var got []string
+	for _, upload := range storage.uploads {
+		got = append(got, string(upload))
+	}
+	storage.mu.Unlock()
+
+	want := []string{"cpu one", "cpu two", "cpu one", "goroutine one"}
+	if len(got) != len(want) {
+		t.Fatalf("uploads = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("upload %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(metadata) != len(want) {
+		t.Fatalf("Expected %d metadata posts, got %d", len(want), len(metadata))
+	}
+	for i, m := range metadata {
+		if m["replay"] != "true" {
+			t.Errorf("metadata %d replay = %q, want true", i, m["replay"])
+		}
+	}
+	if metadata[3]["type"] != "goroutine" || metadata[3]["replay_file"] != "goroutine-001.pprof" {
+		t.Errorf("metadata = %v, want the goroutine fixture", metadata[3])
+	}
+}
+
+func TestReplayDirMissingTypeFails(t *testing.T) {
+	p, err := New(Config{
+		APIKey:      "test-key",
+		IngestURL:   "http://localhost",
+		Storage:     &captureStorage{},
+		ServiceName: "test-service",
+		EnableBlock: true,
+		ReplayDir:   t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	results := p.Flush(context.Background(), ProfileBlock)
+	if len(results) != 1 || results[0].Err == nil {
+		t.Errorf("Flush() = %+v, want an error for a type with no fixtures", results)
+	}
+}
@@ -0,0 +1,139 @@
+package pprofio
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// storedProfileName matches the names of profiles written by FileStorage,
+// "[<service>_]<type>_<timestamp>_<seq><ext>", capturing the type and
+// extension. Retention only ever removes files matching it whose extension
+// is one FileStorage writes for that type.
+var storedProfileName = regexp.MustCompile(
+	`^(?:[A-Za-z0-9._-]+_)?(cpu|memory|allocs|goroutine|mutex|block|custom|trace|unknown)_\d{8}T\d{6}\.\d{9}Z_\d+(\..*)?$`)
+
+// storedProfile is a profile file found in a FileStorage directory
+type storedProfile struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+// prune removes stored profiles beyond MaxFiles or MaxBytes, newest kept,
+// and those older than MaxAge. keep, the file just written, is never
+// removed. Pruning is best effort: files that cannot be listed or removed
+// are left in place.
+func (s *FileStorage) prune(keep string) {
+	if s.MaxFiles <= 0 && s.MaxAge <= 0 && s.MaxBytes <= 0 {
+		return
+	}
+
+	profiles := s.storedProfiles()
+	now := time.Now()
+	kept := 0
+	var keptBytes int64
+	if s.MaxBytes > 0 {
+		// The new profile is always kept, so it counts against the budget
+		// before any older one
+		for _, profile := range profiles {
+			if profile.path == keep {
+				keptBytes = profile.size
+			}
+		}
+	}
+	for _, profile := range profiles {
+		if profile.path == keep {
+			kept++
+			continue
+		}
+		expired := s.MaxAge > 0 && now.Sub(profile.modTime) > s.MaxAge
+		overCount := s.MaxFiles > 0 && kept >= s.MaxFiles
+		overBytes := s.MaxBytes > 0 && keptBytes+profile.size > s.MaxBytes
+		if !expired && !overCount && !overBytes {
+			kept++
+			keptBytes += profile.size
+			continue
+		}
+		os.Remove(profile.path)
+	}
+}
+
+// storedProfiles lists the profiles written to the directory, newest first.
+func (s *FileStorage) storedProfiles() []storedProfile {
+	entries, err := os.ReadDir(s.Directory)
+	if err != nil {
+		return nil
+	}
+
+	var profiles []storedProfile
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !s.isStoredProfile(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		profiles = append(profiles, storedProfile{
+			path:    filepath.Join(s.Directory, entry.Name()),
+			modTime: info.ModTime(),
+			size:    info.Size(),
+		})
+	}
+
+	sort.Slice(profiles, func(i, j int) bool {
+		if !profiles[i].modTime.Equal(profiles[j].modTime) {
+			return profiles[i].modTime.After(profiles[j].modTime)
+		}
+		return profiles[i].path > profiles[j].path
+	})
+	return profiles
+}
+
+// isStoredProfile reports whether name is a profile FileStorage wrote: it
+// matches storedProfileName with the default extension for its type, or one
+// this storage has written for that type.
+func (s *FileStorage) isStoredProfile(name string) bool {
+	m := storedProfileName.FindStringSubmatch(name)
+	if m == nil {
+		return false
+	}
+	profileType, ext := m[1], m[2]
+	if ext == defaultStoredExtension(profileType) {
+		return true
+	}
+
+	s.extMu.Lock()
+	defer s.extMu.Unlock()
+	return s.extensions[profileType][ext]
+}
+
+// recordExtension remembers that a profile of the given type was stored
+// with ext, so retention recognizes it.
+func (s *FileStorage) recordExtension(profileType, ext string) {
+	if ext == defaultStoredExtension(profileType) {
+		return
+	}
+
+	s.extMu.Lock()
+	defer s.extMu.Unlock()
+	if s.extensions == nil {
+		s.extensions = make(map[string]map[string]bool)
+	}
+	if s.extensions[profileType] == nil {
+		s.extensions[profileType] = make(map[string]bool)
+	}
+	s.extensions[profileType][ext] = true
+}
+
+// defaultStoredExtension is the extension of profiles of the given type
+// collected without FileExtensions.
+func defaultStoredExtension(profileType string) string {
+	if profileType == string(profileTypeTrace) {
+		return ".out"
+	}
+	return ".pprof"
+}
@@ -0,0 +1,61 @@
+package pprofio
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// retryAfterError is a failed request whose response asked the client to
+// wait before retrying.
+type retryAfterError struct {
+	err  error
+	wait time.Duration
+}
+
+func (e *retryAfterError) Error() string { return e.err.Error() }
+
+func (e *retryAfterError) Unwrap() error { return e.err }
+
+// parseRetryAfter reads a Retry-After header given either as delay seconds
+// or as an HTTP date. A date in the past yields a zero wait.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		if seconds > int64(maxRetryAfter/time.Second) {
+			return maxRetryAfter, true
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := at.Sub(now); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// maxRetryAfter bounds parsed Retry-After delays so huge values cannot
+// overflow a time.Duration
+const maxRetryAfter = 24 * time.Hour
+
+// retryDelay returns how long to wait before the next attempt: the backoff,
+// raised to the server's Retry-After when that is longer, capped at max.
+// A max of zero means no cap.
+func retryDelay(backoff, retryAfter, max time.Duration) time.Duration {
+	if retryAfter > backoff {
+		backoff = retryAfter
+	}
+	if max > 0 && backoff > max {
+		backoff = max
+	}
+	return backoff
+}
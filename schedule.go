@@ -0,0 +1,62 @@
+package pprofio
+
+import (
+	"errors"
+	"time"
+)
+
+// UpdateSampleRate changes how often profiles are collected while the
+// profiler is running, without restarting it. Each collection loop starts a
+// fresh interval from the moment of the change. Runtime profile rates and
+// buffered spans are kept. A rate shorter than the CPU profile duration is
+// rejected.
+func (p *Profiler) UpdateSampleRate(d time.Duration) error {
+	if d <= 0 {
+		return errors.New("sample rate must be positive")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.scheduleMu.Lock()
+	defer p.scheduleMu.Unlock()
+
+	if err := p.config.validateCPUDuration(d); err != nil {
+		return err
+	}
+	p.config.SampleRate = d
+	close(p.rateCh)
+	p.rateCh = make(chan struct{})
+	return nil
+}
+
+// sampleRate returns the current collection interval.
+func (p *Profiler) sampleRate() time.Duration {
+	p.scheduleMu.Lock()
+	defer p.scheduleMu.Unlock()
+	return p.config.SampleRate
+}
+
+// rateChanged returns a channel closed by the next UpdateSampleRate. Loops
+// must fetch a new channel after each change.
+func (p *Profiler) rateChanged() <-chan struct{} {
+	p.scheduleMu.Lock()
+	defer p.scheduleMu.Unlock()
+	return p.rateCh
+}
+
+// memoryDue reports whether a snapshot cycle should include a memory
+// profile under MemoryInterval, recording the collection if so. Cycles run
+// every SampleRate, so one arriving up to half a cycle early counts as due
+// rather than waiting a whole extra cycle.
+func (p *Profiler) memoryDue(now time.Time) bool {
+	p.scheduleMu.Lock()
+	defer p.scheduleMu.Unlock()
+
+	if !p.lastSnapshotMemory.IsZero() &&
+		now.Sub(p.lastSnapshotMemory) < p.config.MemoryInterval-p.config.SampleRate/2 {
+		return false
+	}
+	p.lastSnapshotMemory = now
+	return true
+}
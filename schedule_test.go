@@ -0,0 +1,86 @@
+package pprofio
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestUpdateSampleRate(t *testing.T) {
+	metadataServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer metadataServer.Close()
+
+	storage := &captureStorage{}
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       metadataServer.URL,
+		SampleRate:      time.Hour,
+		Storage:         storage,
+		ServiceName:     "test-service",
+		EnableGoroutine: true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if err := p.UpdateSampleRate(0); err == nil {
+		t.Error("UpdateSampleRate(0) should return error")
+	}
+
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer p.Stop()
+
+	// Only the startup collection runs on an hourly cadence
+	waitFor(t, func() bool { return storage.count() == 1 })
+
+	if err := p.UpdateSampleRate(10 * time.Millisecond); err != nil {
+		t.Fatalf("UpdateSampleRate() error = %v", err)
+	}
+	waitFor(t, func() bool { return storage.count() >= 4 })
+
+	if err := p.UpdateSampleRate(time.Hour); err != nil {
+		t.Fatalf("UpdateSampleRate() error = %v", err)
+	}
+	// Let a collection that was already running finish
+	time.Sleep(30 * time.Millisecond)
+	slowed := storage.count()
+
+	time.Sleep(100 * time.Millisecond)
+	if got := storage.count(); got != slowed {
+		t.Errorf("%d profiles uploaded after slowing the sample rate", got-slowed)
+	}
+}
+
+func TestUpdateSampleRateBelowCPUDuration(t *testing.T) {
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       "https://api.pprofio.com",
+		SampleRate:      time.Minute,
+		ProfileDuration: 10 * time.Second,
+		Storage:         &captureStorage{},
+		ServiceName:     "test-service",
+		EnableCPU:       true,
+		ProfileOptions: map[ProfileType]ProfileOptions{
+			ProfileCPU: {Duration: 2 * time.Second},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if err := p.UpdateSampleRate(time.Second); err == nil {
+		t.Error("UpdateSampleRate() below the CPU profile duration should return error")
+	}
+	if got := p.sampleRate(); got != time.Minute {
+		t.Errorf("sampleRate() = %v after a rejected update, want %v", got, time.Minute)
+	}
+	if err := p.UpdateSampleRate(5 * time.Second); err != nil {
+		t.Errorf("UpdateSampleRate() above the CPU override duration error = %v", err)
+	}
+}
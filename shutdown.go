@@ -0,0 +1,62 @@
+package pprofio
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyShutdown returns a channel receiving the termination signals
+// CaptureOnShutdown handles.
+func notifyShutdown() chan os.Signal {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	return signals
+}
+
+// watchShutdownSignals captures a final profile set when the process is asked
+// to terminate. Hosts commonly derive the Start context from the same signal
+// with signal.NotifyContext, so a signal pending when the context or Stop
+// wins the race still triggers the capture. With ReraiseShutdownSignal, the
+// signal is then re-raised with default handling restored so the process
+// exits as it would have without the profiler.
+func (p *Profiler) watchShutdownSignals(ctx context.Context, signals chan os.Signal) {
+	defer p.wg.Done()
+	defer signal.Stop(signals)
+
+	var sig os.Signal
+	select {
+	case sig = <-signals:
+	case <-p.stopCh:
+	case <-ctx.Done():
+	}
+	if sig == nil {
+		select {
+		case sig = <-signals:
+		default:
+			return
+		}
+	}
+
+	p.captureOnShutdown()
+	if !p.config.ReraiseShutdownSignal {
+		return
+	}
+
+	signal.Stop(signals)
+	if proc, err := os.FindProcess(os.Getpid()); err == nil {
+		proc.Signal(sig)
+	}
+}
+
+// captureOnShutdown flushes every enabled profile type, giving up once
+// ShutdownTimeout has elapsed so shutdown is not delayed past the grace
+// period.
+func (p *Profiler) captureOnShutdown() []CollectionResult {
+	// The application context may already be cancelled by its own shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), p.config.ShutdownTimeout)
+	defer cancel()
+
+	return p.Flush(withTrigger(ctx, triggerSignal))
+}
@@ -0,0 +1,69 @@
+//go:build !windows && !plan9
+
+package pprofio
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCaptureOnShutdownWithNotifyContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	storage := &captureStorage{}
+	p, err := New(Config{
+		APIKey:            "test-key",
+		IngestURL:         server.URL,
+		Storage:           storage,
+		ServiceName:       "test-service",
+		EnableGoroutine:   true,
+		CaptureOnShutdown: true,
+		ShutdownTimeout:   time.Second,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	// The host cancels the Start context on the same SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+	defer stop()
+	signals := notifyShutdown()
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatalf("Kill() error = %v", err)
+	}
+	<-ctx.Done()
+	waitFor(t, func() bool { return len(signals) == 1 })
+
+	// The watcher sees the cancelled context and the pending signal together;
+	// whichever its select picks, the final capture must run. Repeat with
+	// the signal queued again so both orders are exercised.
+	for i := 0; i < 8; i++ {
+		if i > 0 {
+			signals = make(chan os.Signal, 1)
+			signals <- syscall.SIGTERM
+		}
+		before := storage.count()
+		p.wg.Add(1)
+		p.watchShutdownSignals(ctx, signals)
+		if storage.count() != before+1 {
+			t.Fatalf("run %d: watcher uploaded %d profiles, want the shutdown capture", i+1, storage.count()-before)
+		}
+	}
+
+	// Without a pending signal, a cancelled context captures nothing
+	before := storage.count()
+	p.wg.Add(1)
+	p.watchShutdownSignals(ctx, make(chan os.Signal, 1))
+	if storage.count() != before {
+		t.Error("watcher captured profiles without a shutdown signal")
+	}
+}
@@ -0,0 +1,85 @@
+package pprofio
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCaptureOnShutdownFlushesWithinTimeout(t *testing.T) {
+	var mu sync.Mutex
+	var triggers []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var metadata map[string]string
+		json.NewDecoder(r.Body).Decode(&metadata)
+
+		mu.Lock()
+		triggers = append(triggers, metadata["trigger"])
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	storage := &captureStorage{}
+	p, err := New(Config{
+		APIKey:            "test-key",
+		IngestURL:         server.URL,
+		ProfileDuration:   10 * time.Second,
+		Storage:           storage,
+		ServiceName:       "test-service",
+		EnableCPU:         true,
+		EnableGoroutine:   true,
+		CaptureOnShutdown: true,
+		ShutdownTimeout:   500 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	start := time.Now()
+	results := p.captureOnShutdown()
+	elapsed := time.Since(start)
+
+	if elapsed > p.config.ShutdownTimeout {
+		t.Errorf("captureOnShutdown() took %v, want at most %v", elapsed, p.config.ShutdownTimeout)
+	}
+	if len(results) != 2 {
+		t.Fatalf("captureOnShutdown() returned %d results, want 2", len(results))
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			t.Errorf("%s flush error = %v", result.Type, result.Err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(triggers) != 2 {
+		t.Fatalf("Received %d metadata payloads, want 2", len(triggers))
+	}
+	for _, trigger := range triggers {
+		if trigger != triggerSignal {
+			t.Errorf("trigger = %q, want %q", trigger, triggerSignal)
+		}
+	}
+}
+
+func TestShutdownTimeoutDefault(t *testing.T) {
+	p, err := New(Config{
+		APIKey:            "test-key",
+		IngestURL:         "https://api.pprofio.com",
+		Storage:           &captureStorage{},
+		ServiceName:       "test-service",
+		CaptureOnShutdown: true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if p.config.ShutdownTimeout != DefaultShutdownTimeout {
+		t.Errorf("ShutdownTimeout = %v, want %v", p.config.ShutdownTimeout, DefaultShutdownTimeout)
+	}
+}
@@ -0,0 +1,51 @@
+//go:build go1.21
+
+package pprofio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// slogLogger adapts a *slog.Logger to StructuredLogger
+type slogLogger struct {
+	l *slog.Logger
+}
+
+// NewSlogLogger returns a Logger that writes to l, logging collection and
+// upload events with their fields as slog attributes at debug level and
+// errors at error level. A nil l discards all output.
+//
+//	cfg.Logger = pprofio.NewSlogLogger(slog.Default())
+func NewSlogLogger(l *slog.Logger) StructuredLogger {
+	if l == nil {
+		l = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	return slogLogger{l: l}
+}
+
+func (s slogLogger) Debugf(format string, args ...interface{}) {
+	s.l.Debug(fmt.Sprintf(format, args...))
+}
+
+func (s slogLogger) Errorf(format string, args ...interface{}) {
+	s.l.Error(fmt.Sprintf(format, args...))
+}
+
+func (s slogLogger) Debug(msg string, fields ...Field) {
+	s.l.LogAttrs(context.Background(), slog.LevelDebug, msg, slogAttrs(fields)...)
+}
+
+func (s slogLogger) Error(msg string, fields ...Field) {
+	s.l.LogAttrs(context.Background(), slog.LevelError, msg, slogAttrs(fields)...)
+}
+
+func slogAttrs(fields []Field) []slog.Attr {
+	attrs := make([]slog.Attr, 0, len(fields))
+	for _, f := range fields {
+		attrs = append(attrs, slog.Any(f.Key, f.Value))
+	}
+	return attrs
+}
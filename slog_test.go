@@ -0,0 +1,124 @@
+//go:build go1.21
+
+package pprofio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent handler writes
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// entries decodes the JSON log lines written so far
+func (b *syncBuffer) entries(t *testing.T) []map[string]interface{} {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var entries []map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(b.buf.Bytes()))
+	for dec.More() {
+		var entry map[string]interface{}
+		if err := dec.Decode(&entry); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestSlogLoggerStructuredEvents(t *testing.T) {
+	ingest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ingest.Close()
+
+	buf := &syncBuffer{}
+	logger := slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       ingest.URL,
+		SampleRate:      time.Hour,
+		Storage:         &failingTypeStorage{fail: ProfileMutex},
+		ServiceName:     "test-service",
+		EnableGoroutine: true,
+		EnableMutex:     true,
+		Logger:          NewSlogLogger(logger),
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	results := p.Flush(context.Background(), ProfileGoroutine, ProfileMutex)
+	if results[0].Err != nil {
+		t.Fatalf("Flush(goroutine) error = %v", results[0].Err)
+	}
+	if results[1].Err == nil {
+		t.Fatal("Flush(mutex) should fail")
+	}
+
+	var collected, failed map[string]interface{}
+	for _, entry := range buf.entries(t) {
+		if entry["msg"] != "profile collected" {
+			continue
+		}
+		switch entry["profile_type"] {
+		case string(ProfileGoroutine):
+			collected = entry
+		case string(ProfileMutex):
+			failed = entry
+		}
+	}
+
+	if collected == nil {
+		t.Fatalf("No debug event for the goroutine profile: %v", buf.entries(t))
+	}
+	if collected["level"] != "DEBUG" {
+		t.Errorf("level = %v, want DEBUG", collected["level"])
+	}
+	if bytes, ok := collected["bytes"].(float64); !ok || int64(bytes) != results[0].SizeBytes {
+		t.Errorf("bytes = %v, want %d", collected["bytes"], results[0].SizeBytes)
+	}
+	for _, key := range []string{"duration", "attempts"} {
+		if _, ok := collected[key]; !ok {
+			t.Errorf("Event missing %q attribute: %v", key, collected)
+		}
+	}
+
+	if failed == nil || failed["error"] == nil {
+		t.Errorf("Failed upload event missing error attribute: %v", failed)
+	}
+}
+
+func TestSlogLoggerCollectionErrors(t *testing.T) {
+	buf := &syncBuffer{}
+	p := &Profiler{config: Config{Logger: NewSlogLogger(slog.New(slog.NewJSONHandler(buf, nil)))}}
+
+	p.logCollectionError(ProfileCPU, errors.New("storage unavailable"))
+
+	entries := buf.entries(t)
+	if len(entries) != 1 {
+		t.Fatalf("Logged %d entries, want 1", len(entries))
+	}
+	entry := entries[0]
+	if entry["level"] != "ERROR" || entry["profile_type"] != "cpu" || entry["error"] != "storage unavailable" {
+		t.Errorf("Error entry = %v", entry)
+	}
+}
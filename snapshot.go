@@ -0,0 +1,115 @@
+package pprofio
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// snapshotManifest lists every profile collected in one cycle so the backend
+// can present a coherent point-in-time view.
+type snapshotManifest struct {
+	Service   string            `json:"service"`
+	Timestamp int64             `json:"timestamp"`
+	Tags      map[string]string `json:"tags,omitempty"`
+	Profiles  []snapshotEntry   `json:"profiles"`
+}
+
+type snapshotEntry struct {
+	Type       ProfileType `json:"type"`
+	ProfileID  string      `json:"profile_id,omitempty"`
+	ProfileURL string      `json:"profile_url,omitempty"`
+	SizeBytes  int64       `json:"size_bytes"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// collectSnapshots replaces the per-type collection loops when Snapshots is
+// enabled, collecting all types together each interval.
+func (p *Profiler) collectSnapshots(ctx context.Context) {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.sampleRate())
+	defer ticker.Stop()
+	rateChanged := p.rateChanged()
+
+	// Collect one snapshot immediately at startup, unless the profiler was
+	// stopped or its context cancelled before the loop began
+	if p.pauseState() == nil && p.running(ctx) {
+		if err := p.collectSnapshot(ctx); err != nil {
+			p.config.Logger.Errorf("Error emitting snapshot: %v", err)
+		}
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			if !p.waitWhilePaused(ctx, ticker, p.sampleRate()) {
+				return
+			}
+			if err := p.collectSnapshot(ctx); err != nil {
+				p.config.Logger.Errorf("Error emitting snapshot: %v", err)
+			}
+		case <-rateChanged:
+			rateChanged = p.rateChanged()
+			ticker.Reset(p.sampleRate())
+		case <-p.stopCh:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// collectSnapshot collects every enabled type concurrently and, once all
+// uploads have completed, posts the cycle's manifest to /snapshot.
+func (p *Profiler) collectSnapshot(ctx context.Context) error {
+	var types []profileType
+	now := time.Now()
+	for _, t := range p.enabledProfileTypes() {
+		if p.cpuBusyEnough(t) && (t != profileTypeMemory || p.memoryDue(now)) && p.withinBudget() {
+			types = append(types, t)
+		}
+	}
+	if len(types) == 0 {
+		return nil
+	}
+	results := make([]CollectionResult, len(types))
+	ctx = withTrigger(ctx, triggerScheduled)
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for i, t := range types {
+		wg.Add(1)
+		go func(i int, t profileType) {
+			defer wg.Done()
+			results[i] = p.collectResult(ctx, t)
+		}(i, t)
+	}
+	wg.Wait()
+	p.logCycleSummary(triggerScheduled, results, time.Since(start))
+
+	manifest := snapshotManifest{
+		Service:   p.config.ServiceName,
+		Timestamp: time.Now().Unix(),
+		Tags:      p.profileTags(),
+		Profiles:  make([]snapshotEntry, 0, len(results)),
+	}
+	for _, result := range results {
+		entry := snapshotEntry{
+			Type:       result.Type,
+			ProfileID:  result.ProfileID,
+			ProfileURL: result.URL,
+			SizeBytes:  result.SizeBytes,
+		}
+		if result.Err != nil {
+			entry.Error = result.Err.Error()
+		}
+		manifest.Profiles = append(manifest.Profiles, entry)
+	}
+
+	if p.config.OutputToStdout {
+		return nil
+	}
+
+	return p.newIngestClient().post(ctx, "/snapshot", manifest)
+}
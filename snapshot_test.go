@@ -0,0 +1,72 @@
+package pprofio
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSnapshotManifest(t *testing.T) {
+	manifests := make(chan snapshotManifest, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/snapshot" {
+			var manifest snapshotManifest
+			if err := json.NewDecoder(r.Body).Decode(&manifest); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			manifests <- manifest
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       server.URL,
+		SampleRate:      time.Minute,
+		ProfileDuration: 10 * time.Millisecond,
+		Storage:         &captureStorage{},
+		ServiceName:     "test-service",
+		EnableCPU:       true,
+		EnableMemory:    true,
+		EnableGoroutine: true,
+		Snapshots:       true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer p.Stop()
+
+	var manifest snapshotManifest
+	select {
+	case manifest = <-manifests:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for snapshot manifest")
+	}
+
+	if manifest.Service != "test-service" {
+		t.Errorf("manifest.Service = %q, want %q", manifest.Service, "test-service")
+	}
+
+	seen := make(map[ProfileType]string)
+	for _, entry := range manifest.Profiles {
+		if entry.Error != "" {
+			t.Errorf("%s entry error = %s", entry.Type, entry.Error)
+		}
+		seen[entry.Type] = entry.ProfileURL
+	}
+
+	for _, pt := range []ProfileType{ProfileCPU, ProfileMemory, ProfileGoroutine} {
+		if seen[pt] == "" {
+			t.Errorf("Manifest missing URL for %s profile: %+v", pt, manifest.Profiles)
+		}
+	}
+}
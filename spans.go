@@ -2,12 +2,29 @@ package pprofio
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"os"
-	"sync"
+	"math"
+	"sort"
+	"strings"
 	"time"
+
+	"github.com/google/pprof/profile"
 )
 
+// DefaultSpanUnit is the unit of a span's value when none is set: its
+// duration in nanoseconds.
+const DefaultSpanUnit = "nanoseconds"
+
+// weightedTypePrefix marks the sample types holding weighted span values
+const weightedTypePrefix = "weighted_"
+
+// otherSpanName collects spans whose names exceed Config.MaxSpanNames. It is
+// namespaced so it cannot collide with an application span called "other".
+const otherSpanName = "pprofio.other"
+
 type spanKey struct{}
 
 type Span struct {
@@ -15,52 +32,200 @@ type Span struct {
 	Start    time.Time
 	Duration time.Duration
 	Tags     map[string]string
+
+	// Value and Unit record a custom measurement (e.g. bytes or a count)
+	// reported instead of the span's duration. Set them with SetValue.
+	Value int64
+	Unit  string
+
+	// Weight scales the span's value in an additional weighted sample value,
+	// e.g. by request cost. Zero means unweighted, counted with weight 1.
+	// Set it with SetWeight.
+	Weight float64
+
+	// ClockSkewed is set when End measured a negative duration, which can
+	// happen if Start came from a wall clock that was later adjusted
+	ClockSkewed bool
+
+	// id is generated by StartSpan; see ID
+	id string
+
+	// profiler receives the span when it ends, if it was started with a
+	// profiler in its context
+	profiler *Profiler
+	ended    bool
+
+	// endTrace ends the span mirrored by Config.SpanTracer, if any
+	endTrace func()
+}
+
+// SpanTracer mirrors custom spans into an external tracing system, such as
+// OpenTelemetry through the otelbridge subpackage.
+type SpanTracer interface {
+	// StartSpan is called by StartSpan for spans started under a profiler.
+	// It returns the context handed back to the caller, e.g. carrying the
+	// mirrored span, and a function called by End once the span's Duration,
+	// Value and Weight are final.
+	StartSpan(ctx context.Context, span *Span) (context.Context, func())
 }
 
+// End records the span's duration, ends any span mirrored by the
+// profiler's SpanTracer and delivers it to the profiler it was started
+// under. Only the first call has any effect. If the profiler's span
+// queue is full, the span is dropped rather than blocking the caller.
 func (s *Span) End() {
+	if s.ended {
+		return
+	}
+	s.ended = true
+
 	s.Duration = time.Since(s.Start)
-	// Queue for upload - actual implementation would send to profiler
+	if s.Duration < 0 {
+		s.Duration = 0
+		s.ClockSkewed = true
+	}
+
+	if s.endTrace != nil {
+		s.endTrace()
+	}
+
+	if s.profiler == nil {
+		return
+	}
+	s.profiler.spanEnded(s)
+	select {
+	case s.profiler.spanCh <- s:
+	default:
+		// Queue full, drop the span
+	}
 }
 
-func (p *Profiler) processCustomSpans(ctx context.Context) {
-	defer p.wg.Done()
+// ID returns the span's random ID, generated by StartSpan. Profiles whose
+// collection began while the span was active carry it in their "span_id"
+// metadata, so they can be correlated with the span.
+func (s *Span) ID() string {
+	return s.id
+}
 
-	// Map to collect spans by name
-	spans := make(map[string][]*Span)
+// newSpanID returns a random 64-bit span ID in hex
+func newSpanID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// maxActiveSpans bounds the spans tracked for span_id metadata, so spans
+// that are never ended cannot grow the set without limit
+const maxActiveSpans = 1000
+
+// spanStarted tracks s as active until it ends.
+func (p *Profiler) spanStarted(s *Span) {
+	p.activeSpansMu.Lock()
+	defer p.activeSpansMu.Unlock()
+
+	if p.activeSpans == nil {
+		p.activeSpans = make(map[string]struct{})
+	}
+	if len(p.activeSpans) < maxActiveSpans {
+		p.activeSpans[s.id] = struct{}{}
+	}
+}
 
-	// Lock for spans map
-	var spansLock sync.Mutex
+// spanEnded stops tracking s as active.
+func (p *Profiler) spanEnded(s *Span) {
+	p.activeSpansMu.Lock()
+	defer p.activeSpansMu.Unlock()
+
+	delete(p.activeSpans, s.id)
+}
+
+// activeSpanIDs returns the IDs of the spans currently active, sorted.
+func (p *Profiler) activeSpanIDs() []string {
+	p.activeSpansMu.Lock()
+	defer p.activeSpansMu.Unlock()
+
+	if len(p.activeSpans) == 0 {
+		return nil
+	}
+	ids := make([]string, 0, len(p.activeSpans))
+	for id := range p.activeSpans {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// SetValue makes the span report value in the given unit (e.g. "bytes" or
+// "count") in the custom profile instead of its duration.
+func (s *Span) SetValue(value int64, unit string) {
+	s.Value = value
+	s.Unit = unit
+}
+
+// SetWeight weights the span's value by w in the custom profile's weighted
+// sample values, so costlier operations count for more in aggregates.
+func (s *Span) SetWeight(w float64) {
+	s.Weight = w
+}
+
+// unit returns the unit of the span's reported value.
+func (s *Span) unit() string {
+	if s.Unit == "" {
+		return DefaultSpanUnit
+	}
+	return s.Unit
+}
+
+// value returns the span's reported value in its unit.
+func (s *Span) value() int64 {
+	if s.Unit == "" {
+		return s.Duration.Nanoseconds()
+	}
+	return s.Value
+}
+
+// weightedValue returns the span's value scaled by its weight.
+func (s *Span) weightedValue() int64 {
+	if s.Weight == 0 {
+		return s.value()
+	}
+	return int64(math.Round(float64(s.value()) * s.Weight))
+}
+
+func (p *Profiler) processCustomSpans(ctx context.Context) {
+	defer p.wg.Done()
 
 	// Ticker for periodic flushing
-	flushTicker := time.NewTicker(p.config.SampleRate)
+	flushTicker := time.NewTicker(p.interval(profileTypeCustom))
 	defer flushTicker.Stop()
+	rateChanged := p.rateChanged()
 
 	for {
 		select {
 		case span := <-p.spanCh:
-			spansLock.Lock()
-			spans[span.Name] = append(spans[span.Name], span)
-			spansLock.Lock()
+			p.addPendingSpan(span)
 
 		case <-flushTicker.C:
 			// Take a snapshot of current spans and reset
-			spansLock.Lock()
-			if len(spans) > 0 {
-				snapshotSpans := spans
-				spans = make(map[string][]*Span)
-				spansLock.Unlock()
-
-				// Process spans in a separate goroutine to avoid blocking
+			if snapshotSpans := p.takePendingSpans(); len(snapshotSpans) > 0 {
+				// Process spans in a separate goroutine to avoid blocking.
+				// Stop waits for it through wg.
+				p.wg.Add(1)
 				go func() {
-					if err := p.processSpans(ctx, snapshotSpans); err != nil {
-						fmt.Fprintf(os.Stderr, "Error processing spans: %v\n", err)
-					}
+					defer p.wg.Done()
+					p.flushSpans(ctx, snapshotSpans)
 				}()
-			} else {
-				spansLock.Unlock()
 			}
 
+		case <-rateChanged:
+			rateChanged = p.rateChanged()
+			flushTicker.Reset(p.interval(profileTypeCustom))
+
 		case <-p.stopCh:
+			// Upload spans that ended since the last tick before Stop returns
+			p.flushSpans(ctx, p.takePendingSpans())
 			return
 
 		case <-ctx.Done():
@@ -69,10 +234,226 @@ func (p *Profiler) processCustomSpans(ctx context.Context) {
 	}
 }
 
+// flushSpans uploads a snapshot of pending spans, recording any failure.
+func (p *Profiler) flushSpans(ctx context.Context, spans map[string][]*Span) {
+	err := p.processSpans(ctx, spans)
+	p.recordError(err)
+	if err != nil {
+		p.config.Logger.Errorf("Error processing spans: %v", err)
+	}
+}
+
+// addPendingSpan holds span until the next flush.
+func (p *Profiler) addPendingSpan(span *Span) {
+	p.spansMu.Lock()
+	defer p.spansMu.Unlock()
+	addSpan(p.pendingSpans, span, p.config.MaxSpanNames)
+}
+
+// takePendingSpans returns the spans held since the last flush and resets
+// them. Spans still queued on spanCh are included, so a flush right after
+// Span.End sees the span.
+func (p *Profiler) takePendingSpans() map[string][]*Span {
+	for drained := false; !drained; {
+		select {
+		case span := <-p.spanCh:
+			p.addPendingSpan(span)
+		default:
+			drained = true
+		}
+	}
+
+	p.spansMu.Lock()
+	defer p.spansMu.Unlock()
+	spans := p.pendingSpans
+	p.pendingSpans = make(map[string][]*Span)
+	return spans
+}
+
+// collectCustom uploads the spans received since the last flush as a custom
+// profile. With no spans, nothing is uploaded.
+func (p *Profiler) collectCustom(ctx context.Context) (CollectionResult, error) {
+	spans := p.takePendingSpans()
+	if len(spans) == 0 {
+		return CollectionResult{}, nil
+	}
+	return p.uploadSpans(ctx, spans)
+}
+
+// addSpan groups span by name. One of the max names is reserved for
+// otherSpanName, so once max-1 distinct names are held, spans with new names
+// are bucketed under it and the map never exceeds max names. A max of zero
+// means no limit.
+func addSpan(spans map[string][]*Span, span *Span, max int) {
+	name := span.Name
+	if _, ok := spans[name]; !ok && max > 0 && len(spans) >= max-1 {
+		name = otherSpanName
+	}
+	spans[name] = append(spans[name], span)
+}
+
+// processSpans converts the collected spans into a custom profile and
+// uploads it, recording the value units in the profile metadata.
 func (p *Profiler) processSpans(ctx context.Context, spans map[string][]*Span) error {
-	// This would convert spans to a pprof-compatible format
-	// and upload them as a custom profile
+	if len(spans) == 0 {
+		return nil
+	}
+
+	start := time.Now()
+	result, err := p.uploadSpans(ctx, spans)
+	result.Type = ProfileCustom
+	result.Err = err
+	result.Duration = time.Since(start)
+	p.emitEvent(result, start)
+	return err
+}
+
+// uploadSpans writes the span profile to a temp file and uploads it.
+func (p *Profiler) uploadSpans(ctx context.Context, spans map[string][]*Span) (CollectionResult, error) {
+	prof := buildSpanProfile(p.redactSpanTags(spans))
+
+	f, err := p.createTempFile(profileTypeCustom)
+	if err != nil {
+		return CollectionResult{}, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer p.releaseTempFile(f.Name(), profileTypeCustom)
+
+	if err := prof.Write(f); err != nil {
+		f.Close()
+		return CollectionResult{}, fmt.Errorf("failed to write custom profile: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return CollectionResult{}, fmt.Errorf("failed to close custom profile: %w", err)
+	}
+
+	units := make([]string, 0, len(prof.SampleType)-1)
+	for _, st := range prof.SampleType[1:] {
+		if !strings.HasPrefix(st.Type, weightedTypePrefix) {
+			units = append(units, st.Unit)
+		}
+	}
+	ctx = withExtraMetadata(ctx, map[string]string{"units": strings.Join(units, ",")})
+
+	result, err := p.uploadProfile(ctx, f.Name(), string(profileTypeCustom))
+	var se *stageError
+	if err == nil || (errors.As(err, &se) && se.stage == StageMetadata) {
+		p.recordSpansFlushed(spans)
+	}
+	return result, err
+}
+
+// buildSpanProfile aggregates spans into a pprof profile with one sample per
+// span name and tag set, labeled with the tags. The first sample value counts
+// spans; each distinct unit gets its own value column so the backend can
+// render it correctly.
+func buildSpanProfile(spans map[string][]*Span) *profile.Profile {
+	names := make([]string, 0, len(spans))
+	unitSet := make(map[string]bool)
+	weighted := false
+	for name, list := range spans {
+		names = append(names, name)
+		for _, span := range list {
+			unitSet[span.unit()] = true
+			weighted = weighted || span.Weight != 0
+		}
+	}
+	sort.Strings(names)
+
+	units := make([]string, 0, len(unitSet))
+	for unit := range unitSet {
+		units = append(units, unit)
+	}
+	sort.Strings(units)
+
+	prof := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "count", Unit: "count"}},
+		TimeNanos:  time.Now().UnixNano(),
+	}
+	column := make(map[string]int, len(units))
+	for i, unit := range units {
+		prof.SampleType = append(prof.SampleType, &profile.ValueType{Type: spanValueType(unit), Unit: unit})
+		column[unit] = i + 1
+	}
+
+	// Weighted values follow the plain ones, one column per unit, only when
+	// some span carries a weight
+	weightedColumn := make(map[string]int, len(units))
+	if weighted {
+		for _, unit := range units {
+			weightedColumn[unit] = len(prof.SampleType)
+			prof.SampleType = append(prof.SampleType, &profile.ValueType{Type: weightedTypePrefix + spanValueType(unit), Unit: unit})
+		}
+	}
+
+	for i, name := range names {
+		fn := &profile.Function{ID: uint64(i + 1), Name: name}
+		loc := &profile.Location{ID: uint64(i + 1), Line: []profile.Line{{Function: fn}}}
+		prof.Function = append(prof.Function, fn)
+		prof.Location = append(prof.Location, loc)
+
+		// Spans with the same name and tags accumulate into one sample
+		samples := make(map[string]*profile.Sample)
+		var keys []string
+		for _, span := range spans[name] {
+			key := tagsKey(span.Tags)
+			sample, ok := samples[key]
+			if !ok {
+				sample = &profile.Sample{
+					Location: []*profile.Location{loc},
+					Value:    make([]int64, len(prof.SampleType)),
+					Label:    spanLabels(span.Tags),
+				}
+				samples[key] = sample
+				keys = append(keys, key)
+			}
+			sample.Value[0]++
+			sample.Value[column[span.unit()]] += span.value()
+			if weighted {
+				sample.Value[weightedColumn[span.unit()]] += span.weightedValue()
+			}
+		}
+
+		sort.Strings(keys)
+		for _, key := range keys {
+			prof.Sample = append(prof.Sample, samples[key])
+		}
+	}
+
+	return prof
+}
 
-	// Placeholder implementation
-	return nil
+// spanValueType returns the sample type name for span values in unit.
+func spanValueType(unit string) string {
+	if unit == DefaultSpanUnit {
+		return "duration"
+	}
+	return unit
+}
+
+// tagsKey returns a canonical string for a tag set
+func tagsKey(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%q=%q,", k, tags[k])
+	}
+	return b.String()
+}
+
+// spanLabels converts span tags to pprof sample labels
+func spanLabels(tags map[string]string) map[string][]string {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	labels := make(map[string][]string, len(tags))
+	for k, v := range tags {
+		labels[k] = []string{v}
+	}
+	return labels
 }
@@ -0,0 +1,542 @@
+package pprofio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+func TestSpanEndClampsNegativeDuration(t *testing.T) {
+	span := &Span{
+		Name:  "future",
+		Start: time.Now().Add(time.Hour).Round(0), // strip monotonic reading
+		Tags:  map[string]string{},
+	}
+
+	span.End()
+
+	if span.Duration != 0 {
+		t.Errorf("span.Duration = %v, want 0", span.Duration)
+	}
+	if !span.ClockSkewed {
+		t.Error("span.ClockSkewed should be set for a negative duration")
+	}
+
+	normal := &Span{Name: "normal", Start: time.Now()}
+	normal.End()
+	if normal.Duration < 0 || normal.ClockSkewed {
+		t.Errorf("normal span Duration = %v, ClockSkewed = %v", normal.Duration, normal.ClockSkewed)
+	}
+}
+
+func TestCustomSpanUnit(t *testing.T) {
+	var mu sync.Mutex
+	var metadata map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		json.NewDecoder(r.Body).Decode(&metadata)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	storage := &captureStorage{}
+	p, err := New(Config{
+		APIKey:       "test-key",
+		IngestURL:    server.URL,
+		Storage:      storage,
+		ServiceName:  "test-service",
+		EnableCustom: true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	read := &Span{Name: "read_payload", Start: time.Now()}
+	read.End()
+	read.SetValue(1024, "bytes")
+	other := &Span{Name: "read_payload", Start: time.Now()}
+	other.End()
+	other.SetValue(512, "bytes")
+
+	spans := map[string][]*Span{"read_payload": {read, other}}
+	if err := p.processSpans(context.Background(), spans); err != nil {
+		t.Fatalf("processSpans() error = %v", err)
+	}
+
+	storage.mu.Lock()
+	if len(storage.uploads) != 1 {
+		t.Fatalf("Expected 1 upload, got %d", len(storage.uploads))
+	}
+	prof, err := profile.Parse(bytes.NewReader(storage.uploads[0]))
+	storage.mu.Unlock()
+	if err != nil {
+		t.Fatalf("Failed to parse custom profile: %v", err)
+	}
+
+	if len(prof.SampleType) != 2 || prof.SampleType[1].Unit != "bytes" {
+		t.Fatalf("SampleType = %v, want count and bytes", prof.SampleType)
+	}
+	if len(prof.Sample) != 1 {
+		t.Fatalf("Expected 1 sample, got %d", len(prof.Sample))
+	}
+	if got := prof.Sample[0].Value; got[0] != 2 || got[1] != 1536 {
+		t.Errorf("Sample values = %v, want [2 1536]", got)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if metadata["type"] != "custom" || metadata["units"] != "bytes" {
+		t.Errorf("metadata type = %q, units = %q, want custom and bytes", metadata["type"], metadata["units"])
+	}
+}
+
+func TestBuildSpanProfileDefaultUnit(t *testing.T) {
+	span := &Span{Name: "handler", Duration: 3 * time.Millisecond}
+
+	prof := buildSpanProfile(map[string][]*Span{"handler": {span}})
+
+	if len(prof.SampleType) != 2 || prof.SampleType[1].Unit != DefaultSpanUnit {
+		t.Fatalf("SampleType = %v, want count and %s", prof.SampleType, DefaultSpanUnit)
+	}
+	if got := prof.Sample[0].Value[1]; got != (3 * time.Millisecond).Nanoseconds() {
+		t.Errorf("duration value = %d, want %d", got, (3 * time.Millisecond).Nanoseconds())
+	}
+}
+
+func TestBuildSpanProfileWeightedValues(t *testing.T) {
+	cheap := &Span{Name: "handler", Duration: 10 * time.Millisecond}
+	costly := &Span{Name: "handler", Duration: 10 * time.Millisecond}
+	costly.SetWeight(2.5)
+	upload := &Span{Name: "upload"}
+	upload.SetValue(100, "bytes")
+	upload.SetWeight(0.5)
+
+	prof := buildSpanProfile(map[string][]*Span{
+		"handler": {cheap, costly},
+		"upload":  {upload},
+	})
+
+	// count, bytes, duration, then the weighted bytes and duration
+	wantTypes := []string{"count", "bytes", "duration", "weighted_bytes", "weighted_duration"}
+	if len(prof.SampleType) != len(wantTypes) {
+		t.Fatalf("SampleType = %v, want %v", prof.SampleType, wantTypes)
+	}
+	for i, st := range prof.SampleType {
+		if st.Type != wantTypes[i] {
+			t.Errorf("SampleType[%d] = %s, want %s", i, st.Type, wantTypes[i])
+		}
+	}
+
+	want := map[string][]int64{
+		// The unweighted span counts with weight 1: 10ms + 2.5*10ms
+		"handler": {2, 0, (20 * time.Millisecond).Nanoseconds(), 0, (35 * time.Millisecond).Nanoseconds()},
+		"upload":  {1, 100, 0, 50, 0},
+	}
+	for _, sample := range prof.Sample {
+		name := sample.Location[0].Line[0].Function.Name
+		if !reflect.DeepEqual(sample.Value, want[name]) {
+			t.Errorf("%s values = %v, want %v", name, sample.Value, want[name])
+		}
+	}
+
+	// Without weights, no weighted columns are added
+	plain := buildSpanProfile(map[string][]*Span{"handler": {cheap}})
+	if len(plain.SampleType) != 2 {
+		t.Errorf("SampleType = %v, want no weighted columns", plain.SampleType)
+	}
+}
+
+func TestAddSpanBucketsOverflowNames(t *testing.T) {
+	spans := make(map[string][]*Span)
+
+	for i := 0; i < 100; i++ {
+		addSpan(spans, &Span{Name: fmt.Sprintf("GET /users/%d", i)}, 10)
+	}
+	// Names already held keep their own bucket
+	addSpan(spans, &Span{Name: "GET /users/0"}, 10)
+
+	if len(spans) > 10 {
+		t.Fatalf("len(spans) = %d, want at most MaxSpanNames (10)", len(spans))
+	}
+	if len(spans) != 10 {
+		t.Fatalf("len(spans) = %d, want 9 names plus %q", len(spans), otherSpanName)
+	}
+	if got := len(spans[otherSpanName]); got != 91 {
+		t.Errorf("overflow bucket holds %d spans, want 91", got)
+	}
+	if got := len(spans["GET /users/0"]); got != 2 {
+		t.Errorf("existing name holds %d spans, want 2", got)
+	}
+
+	unlimited := make(map[string][]*Span)
+	for i := 0; i < 100; i++ {
+		addSpan(unlimited, &Span{Name: fmt.Sprintf("span-%d", i)}, 0)
+	}
+	if len(unlimited) != 100 {
+		t.Errorf("len(unlimited) = %d, want 100", len(unlimited))
+	}
+}
+
+func TestProcessSpansUploadsCustomProfile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	storage := &captureStorage{}
+	p, err := New(Config{
+		APIKey:       "test-key",
+		IngestURL:    server.URL,
+		Storage:      storage,
+		ServiceName:  "test-service",
+		EnableCustom: true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	// An empty flush uploads nothing
+	if err := p.processSpans(context.Background(), map[string][]*Span{}); err != nil {
+		t.Fatalf("processSpans() with no spans error = %v", err)
+	}
+
+	get := map[string]string{"method": "GET"}
+	spans := map[string][]*Span{
+		"handle_request": {
+			{Name: "handle_request", Duration: 10 * time.Millisecond, Tags: get},
+			{Name: "handle_request", Duration: 30 * time.Millisecond, Tags: map[string]string{"method": "GET"}},
+			{Name: "handle_request", Duration: 5 * time.Millisecond, Tags: map[string]string{"method": "POST"}},
+		},
+		"query_db": {
+			{Name: "query_db", Duration: 2 * time.Millisecond},
+		},
+	}
+	if err := p.processSpans(context.Background(), spans); err != nil {
+		t.Fatalf("processSpans() error = %v", err)
+	}
+
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	if len(storage.uploads) != 1 {
+		t.Fatalf("Expected 1 upload, got %d", len(storage.uploads))
+	}
+	prof, err := profile.Parse(bytes.NewReader(storage.uploads[0]))
+	if err != nil {
+		t.Fatalf("Failed to parse custom profile: %v", err)
+	}
+
+	type aggregate struct{ count, total int64 }
+	got := make(map[string]aggregate)
+	for _, sample := range prof.Sample {
+		key := sample.Location[0].Line[0].Function.Name
+		if method := sample.Label["method"]; len(method) == 1 {
+			key += " " + method[0]
+		}
+		got[key] = aggregate{sample.Value[0], sample.Value[1]}
+	}
+
+	want := map[string]aggregate{
+		"handle_request GET":  {2, (40 * time.Millisecond).Nanoseconds()},
+		"handle_request POST": {1, (5 * time.Millisecond).Nanoseconds()},
+		"query_db":            {1, (2 * time.Millisecond).Nanoseconds()},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Samples = %v, want %v", got, want)
+	}
+	for key, w := range want {
+		if got[key] != w {
+			t.Errorf("Sample %q = %+v, want %+v", key, got[key], w)
+		}
+	}
+}
+
+func TestProcessCustomSpansHandlesManySpans(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	storage := &captureStorage{}
+	p, err := New(Config{
+		APIKey:       "test-key",
+		IngestURL:    server.URL,
+		SampleRate:   50 * time.Millisecond,
+		Storage:      storage,
+		ServiceName:  "test-service",
+		EnableCustom: true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer p.Stop()
+
+	// Every span after the first used to block the processing loop forever
+	for i := 0; i < 5; i++ {
+		p.spanCh <- &Span{Name: "handle_request", Duration: time.Millisecond}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		storage.mu.Lock()
+		n := len(storage.uploads)
+		storage.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the custom profile upload")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	prof, err := profile.Parse(bytes.NewReader(storage.uploads[0]))
+	if err != nil {
+		t.Fatalf("Failed to parse custom profile: %v", err)
+	}
+	var count int64
+	for _, sample := range prof.Sample {
+		count += sample.Value[0]
+	}
+	if count != 5 {
+		t.Errorf("Custom profile counts %d spans, want 5", count)
+	}
+}
+
+func TestSpanEndDeliversMeasuredSpan(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	storage := &captureStorage{}
+	p, err := New(Config{
+		APIKey:       "test-key",
+		IngestURL:    server.URL,
+		SampleRate:   50 * time.Millisecond,
+		Storage:      storage,
+		ServiceName:  "test-service",
+		EnableCustom: true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer p.Stop()
+
+	_, span := StartSpan(WithProfiler(context.Background(), p), "slow_operation")
+	time.Sleep(5 * time.Millisecond)
+	span.End()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		storage.mu.Lock()
+		n := len(storage.uploads)
+		storage.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the custom profile upload")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	prof, err := profile.Parse(bytes.NewReader(storage.uploads[0]))
+	if err != nil {
+		t.Fatalf("Failed to parse custom profile: %v", err)
+	}
+	if len(prof.Sample) != 1 {
+		t.Fatalf("Expected 1 sample, got %d", len(prof.Sample))
+	}
+	if got := time.Duration(prof.Sample[0].Value[1]); got < 5*time.Millisecond {
+		t.Errorf("Flushed span duration = %v, want at least 5ms", got)
+	}
+}
+
+func TestSpanDeliveredOnlyOnEnd(t *testing.T) {
+	p := &Profiler{spanCh: make(chan *Span, 1)}
+	ctx := WithProfiler(context.Background(), p)
+
+	_, span := StartSpan(ctx, "operation")
+	if len(p.spanCh) != 0 {
+		t.Fatal("Span was queued before End")
+	}
+
+	span.End()
+	span.End()
+	if len(p.spanCh) != 1 {
+		t.Fatalf("Expected exactly 1 queued span, got %d", len(p.spanCh))
+	}
+
+	// A full queue drops spans instead of blocking
+	_, other := StartSpan(ctx, "dropped")
+	other.End()
+	if queued := <-p.spanCh; queued != span {
+		t.Errorf("Queued span = %q, want %q", queued.Name, span.Name)
+	}
+}
+
+func TestCustomOnlyProfilerUploadsOnFlush(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	storage := &captureStorage{}
+	logger := &captureLogger{}
+	p, err := New(Config{
+		APIKey:       "test-key",
+		IngestURL:    server.URL,
+		SampleRate:   time.Hour,
+		Storage:      storage,
+		ServiceName:  "test-service",
+		EnableCustom: true,
+		Logger:       logger,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer p.Stop()
+
+	logger.mu.Lock()
+	warned := len(logger.errors) == 1 && strings.Contains(logger.errors[0], "only custom profiling is enabled")
+	logger.mu.Unlock()
+	if !warned {
+		t.Errorf("Expected a custom-only startup warning, got %v", logger.errors)
+	}
+
+	ctx := WithProfiler(context.Background(), p)
+	_, span := StartSpan(ctx, "handle_request")
+	span.End()
+
+	results := p.Flush(context.Background())
+	if len(results) != 1 || results[0].Type != ProfileCustom || results[0].Err != nil {
+		t.Fatalf("Flush() = %+v, want one custom result", results)
+	}
+	if storage.count() != 1 {
+		t.Fatalf("Expected 1 custom profile upload, got %d", storage.count())
+	}
+
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	prof, err := profile.Parse(bytes.NewReader(storage.uploads[0]))
+	if err != nil {
+		t.Fatalf("Failed to parse custom profile: %v", err)
+	}
+	if len(prof.Sample) != 1 || prof.Sample[0].Value[0] != 1 {
+		t.Errorf("Custom profile samples = %v, want one span", prof.Sample)
+	}
+}
+
+func TestStopFlushesPendingSpans(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	storage := &captureStorage{}
+	p, err := New(Config{
+		APIKey:       "test-key",
+		IngestURL:    server.URL,
+		SampleRate:   time.Hour,
+		Storage:      storage,
+		ServiceName:  "test-service",
+		EnableCustom: true,
+		Logger:       &captureLogger{},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	ctx := WithProfiler(context.Background(), p)
+	for i := 0; i < 3; i++ {
+		_, span := StartSpan(ctx, "handle_request")
+		span.End()
+	}
+	// The flush tick is an hour away, so only Stop can upload these
+	p.Stop()
+
+	if storage.count() != 1 {
+		t.Fatalf("Expected 1 custom profile upload after Stop, got %d", storage.count())
+	}
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	prof, err := profile.Parse(bytes.NewReader(storage.uploads[0]))
+	if err != nil {
+		t.Fatalf("Failed to parse custom profile: %v", err)
+	}
+	if len(prof.Sample) != 1 || prof.Sample[0].Value[0] != 3 {
+		t.Errorf("Custom profile samples = %v, want 3 spans", prof.Sample)
+	}
+}
+
+func TestProfileMetadataCarriesActiveSpanID(t *testing.T) {
+	var mu sync.Mutex
+	var spanIDs []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var metadata map[string]string
+		json.NewDecoder(r.Body).Decode(&metadata)
+
+		mu.Lock()
+		spanIDs = append(spanIDs, metadata["span_id"])
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       server.URL,
+		ProfileDuration: 50 * time.Millisecond,
+		Storage:         &captureStorage{},
+		ServiceName:     "test-service",
+		EnableCPU:       true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	_, span := StartSpan(WithProfiler(context.Background(), p), "checkout")
+	if len(span.ID()) != 16 {
+		t.Fatalf("ID() = %q, want 16 hex characters", span.ID())
+	}
+	if results := p.Flush(context.Background(), ProfileCPU); results[0].Err != nil {
+		t.Fatalf("Flush() error = %v", results[0].Err)
+	}
+	span.End()
+
+	// Profiles collected after the span ended are not linked to it
+	if results := p.Flush(context.Background(), ProfileCPU); results[0].Err != nil {
+		t.Fatalf("Flush() error = %v", results[0].Err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(spanIDs) != 2 || spanIDs[0] != span.ID() || spanIDs[1] != "" {
+		t.Errorf("span_id metadata = %q, want [%q \"\"]", spanIDs, span.ID())
+	}
+}
@@ -0,0 +1,159 @@
+package pprofio
+
+import (
+	"errors"
+	"time"
+)
+
+// Stages reported by LastError
+const (
+	StageCollect  = "collect"
+	StageUpload   = "upload"
+	StageMetadata = "metadata"
+)
+
+// stageError tags an error with the stage of the collection pipeline that
+// failed
+type stageError struct {
+	stage string
+	err   error
+}
+
+func (e *stageError) Error() string { return e.err.Error() }
+
+func (e *stageError) Unwrap() error { return e.err }
+
+// ProfilerStats reports counters about the profiler's own behavior
+type ProfilerStats struct {
+	// BudgetSkips counts scheduled collections skipped by MaxUploadsPerHour
+	BudgetSkips uint64
+
+	// IdleCPUSkips counts scheduled CPU profiles skipped by MinCPUUsage
+	IdleCPUSkips uint64
+
+	// UploadQueueDrops counts queued profiles dropped because the
+	// UploadQueueSize queue was full
+	UploadQueueDrops uint64
+
+	// ProfilesCollected counts profiles handed to Storage, ProfilesUploaded
+	// those it accepted and UploadFailures those it rejected
+	ProfilesCollected uint64
+	ProfilesUploaded  uint64
+	UploadFailures    uint64
+
+	// BytesUploaded is the on-disk size of the profiles Storage accepted
+	BytesUploaded uint64
+
+	// SpansFlushed counts custom spans included in uploaded span profiles
+	SpansFlushed uint64
+
+	// Types breaks the profile counters down by profile type
+	Types map[ProfileType]TypeStats
+}
+
+// TypeStats reports the profile counters for one profile type
+type TypeStats struct {
+	ProfilesCollected uint64
+	ProfilesUploaded  uint64
+	UploadFailures    uint64
+	BytesUploaded     uint64
+
+	// CollectionTime is the total time spent collecting and uploading
+	// profiles of this type, including failed attempts
+	CollectionTime time.Duration
+
+	// LastUploadTime is when Storage last accepted a profile of this type
+	LastUploadTime time.Time
+}
+
+// Stats returns a snapshot of the profiler's counters
+func (p *Profiler) Stats() ProfilerStats {
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+
+	stats := p.stats
+	stats.Types = make(map[ProfileType]TypeStats, len(p.stats.Types))
+	for t, s := range p.stats.Types {
+		stats.Types[t] = s
+	}
+	return stats
+}
+
+// recordUpload counts a profile handed to Storage and the outcome of its
+// upload.
+func (p *Profiler) recordUpload(profileType ProfileType, size int64, err error) {
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+
+	if p.stats.Types == nil {
+		p.stats.Types = make(map[ProfileType]TypeStats)
+	}
+	typeStats := p.stats.Types[profileType]
+
+	p.stats.ProfilesCollected++
+	typeStats.ProfilesCollected++
+	if err != nil {
+		p.stats.UploadFailures++
+		typeStats.UploadFailures++
+	} else {
+		p.stats.ProfilesUploaded++
+		p.stats.BytesUploaded += uint64(size)
+		typeStats.ProfilesUploaded++
+		typeStats.BytesUploaded += uint64(size)
+		typeStats.LastUploadTime = time.Now()
+	}
+	p.stats.Types[profileType] = typeStats
+}
+
+// recordCollectionTime adds the duration of one collection of profileType
+func (p *Profiler) recordCollectionTime(profileType ProfileType, d time.Duration) {
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+
+	if p.stats.Types == nil {
+		p.stats.Types = make(map[ProfileType]TypeStats)
+	}
+	typeStats := p.stats.Types[profileType]
+	typeStats.CollectionTime += d
+	p.stats.Types[profileType] = typeStats
+}
+
+// recordSpansFlushed counts spans included in an uploaded span profile
+func (p *Profiler) recordSpansFlushed(spans map[string][]*Span) {
+	var n uint64
+	for _, named := range spans {
+		n += uint64(len(named))
+	}
+
+	p.statsMu.Lock()
+	p.stats.SpansFlushed += n
+	p.statsMu.Unlock()
+}
+
+// LastError returns the most recent collection failure, the stage it occurred
+// in (StageCollect, StageUpload or StageMetadata) and when. It is cleared by
+// the next successful collection, after which err is nil.
+func (p *Profiler) LastError() (stage string, err error, at time.Time) {
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+
+	return p.lastErrStage, p.lastErr, p.lastErrAt
+}
+
+// recordError updates LastError with the outcome of a collection
+func (p *Profiler) recordError(err error) {
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+
+	if err == nil {
+		p.lastErrStage, p.lastErr, p.lastErrAt = "", nil, time.Time{}
+		return
+	}
+
+	stage := StageCollect
+	var se *stageError
+	if errors.As(err, &se) {
+		stage = se.stage
+	}
+	p.lastErrStage, p.lastErr, p.lastErrAt = stage, err, time.Now()
+}
@@ -0,0 +1,150 @@
+package pprofio
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// toggleStorage fails uploads while fail is set
+type toggleStorage struct {
+	captureStorage
+	failMu sync.Mutex
+	fail   bool
+}
+
+func (s *toggleStorage) setFail(fail bool) {
+	s.failMu.Lock()
+	defer s.failMu.Unlock()
+	s.fail = fail
+}
+
+func (s *toggleStorage) Upload(ctx context.Context, filePath string) (UploadResult, error) {
+	s.failMu.Lock()
+	fail := s.fail
+	s.failMu.Unlock()
+
+	if fail {
+		return UploadResult{}, errors.New("bucket unavailable")
+	}
+	return s.captureStorage.Upload(ctx, filePath)
+}
+
+func TestLastErrorTransitions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	storage := &toggleStorage{}
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       server.URL,
+		Storage:         storage,
+		ServiceName:     "test-service",
+		EnableGoroutine: true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if stage, err, _ := p.LastError(); stage != "" || err != nil {
+		t.Fatalf("LastError() before any collection = %q, %v", stage, err)
+	}
+
+	storage.setFail(true)
+	before := time.Now()
+	p.Flush(context.Background())
+
+	stage, lastErr, at := p.LastError()
+	if stage != StageUpload {
+		t.Errorf("stage = %q, want %q", stage, StageUpload)
+	}
+	if lastErr == nil || !strings.Contains(lastErr.Error(), "bucket unavailable") {
+		t.Errorf("err = %v, want the upload failure", lastErr)
+	}
+	if at.Before(before) {
+		t.Errorf("at = %v, want after %v", at, before)
+	}
+
+	storage.setFail(false)
+	p.Flush(context.Background())
+
+	if stage, err, at := p.LastError(); stage != "" || err != nil || !at.IsZero() {
+		t.Errorf("LastError() after success = %q, %v, %v, want cleared", stage, err, at)
+	}
+
+	p.Flush(context.Background(), ProfileType("bogus"))
+	if stage, err, _ := p.LastError(); stage != StageCollect || err == nil {
+		t.Errorf("LastError() after collection failure = %q, %v, want %q", stage, err, StageCollect)
+	}
+}
+
+func TestStatsCounters(t *testing.T) {
+	ingest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ingest.Close()
+
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       ingest.URL,
+		SampleRate:      time.Hour,
+		Storage:         &FileStorage{Directory: t.TempDir()},
+		ServiceName:     "test-service",
+		EnableGoroutine: true,
+		EnableCustom:    true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	var bytes uint64
+	for i := 0; i < 3; i++ {
+		for _, result := range p.Flush(context.Background(), ProfileGoroutine) {
+			if result.Err != nil {
+				t.Fatalf("Flush() error = %v", result.Err)
+			}
+			bytes += uint64(result.SizeBytes)
+		}
+	}
+
+	ctx := WithProfiler(context.Background(), p)
+	for i := 0; i < 2; i++ {
+		_, span := StartSpan(ctx, "handle_request")
+		span.End()
+	}
+	if results := p.Flush(context.Background(), ProfileCustom); results[0].Err != nil {
+		t.Fatalf("Flush(custom) error = %v", results[0].Err)
+	}
+
+	stats := p.Stats()
+	if stats.ProfilesCollected != 4 || stats.ProfilesUploaded != 4 || stats.UploadFailures != 0 {
+		t.Errorf("Stats() collected/uploaded/failed = %d/%d/%d, want 4/4/0",
+			stats.ProfilesCollected, stats.ProfilesUploaded, stats.UploadFailures)
+	}
+	if stats.SpansFlushed != 2 {
+		t.Errorf("Stats().SpansFlushed = %d, want 2", stats.SpansFlushed)
+	}
+
+	goroutine := stats.Types[ProfileGoroutine]
+	if goroutine.ProfilesUploaded != 3 || goroutine.BytesUploaded != bytes {
+		t.Errorf("Stats().Types[goroutine] = %+v, want 3 uploads of %d bytes", goroutine, bytes)
+	}
+	if goroutine.LastUploadTime.IsZero() {
+		t.Error("Stats().Types[goroutine].LastUploadTime should be set")
+	}
+	if stats.BytesUploaded <= bytes {
+		t.Errorf("Stats().BytesUploaded = %d, want more than the goroutine profiles' %d", stats.BytesUploaded, bytes)
+	}
+
+	// Failed uploads are counted separately
+	p.config.Storage = &failingTypeStorage{fail: ProfileGoroutine}
+	p.Flush(context.Background(), ProfileGoroutine)
+	if got := p.Stats().Types[ProfileGoroutine].UploadFailures; got != 1 {
+		t.Errorf("Stats().Types[goroutine].UploadFailures = %d, want 1", got)
+	}
+}
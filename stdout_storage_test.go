@@ -47,8 +47,8 @@ func TestStdoutStorage_Upload(t *testing.T) {
 	output := string(buf[:n])
 
 	// Verify result
-	if result != "stdout" {
-		t.Errorf("StdoutStorage.Upload() result = %q, want %q", result, "stdout")
+	if result.ProfileURL != "stdout" {
+		t.Errorf("StdoutStorage.Upload() result = %q, want %q", result.ProfileURL, "stdout")
 	}
 
 	// Verify output contains structured profile information
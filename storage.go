@@ -4,21 +4,53 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"math"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// gzipMagic is the header that starts every gzip stream
+var gzipMagic = []byte{0x1f, 0x8b}
+
 type Storage interface {
-	Upload(ctx context.Context, filePath string) (string, error)
+	Upload(ctx context.Context, filePath string) (UploadResult, error)
+}
+
+// UploadResult describes where a storage put an uploaded profile
+type UploadResult struct {
+	// ProfileID identifies the profile to the backend, if it assigns one
+	ProfileID string `json:"profile_id,omitempty"`
+
+	// ProfileURL locates the stored profile, e.g. a URL, path or key
+	ProfileURL string `json:"profile_url"`
+
+	// Type is the profile type recorded by the backend; the collected type
+	// is used when empty
+	Type string `json:"type,omitempty"`
+
+	// Size is the number of bytes stored or sent
+	Size int64 `json:"size,omitempty"`
+
+	// NeedFull reports that the backend lost the delta base for this type
+	// and wants a full profile next cycle
+	NeedFull bool `json:"need_full,omitempty"`
+
+	// Attempts is the number of requests the upload took, for storages
+	// that retry; zero when not reported
+	Attempts int `json:"-"`
 }
 
 type HTTPStorage struct {
@@ -27,40 +59,153 @@ type HTTPStorage struct {
 	Client  *http.Client
 	Retries int
 	Env     string
+
+	// DisableCompression sends profiles as plain protobuf, decompressing
+	// those the runtime already gzipped, useful when inspecting raw request
+	// bodies while debugging an ingest server
+	DisableCompression bool
+
+	// URLByType overrides URL for specific profile types, identified from
+	// the upload context
+	URLByType map[ProfileType]string
+
+	// MaxBackoff caps the delay between retries, including delays requested
+	// by a Retry-After header on 429 responses. Zero means no cap.
+	MaxBackoff time.Duration
+
+	// Headers are added to every upload request, e.g. for an API gateway.
+	// They cannot replace the headers HTTPStorage sets itself:
+	// Authorization, Content-Type, Content-Encoding and CorrelationIDHeader.
+	Headers map[string]string
+
+	// TLSConfig, if set, configures the transport of a Client that has none,
+	// e.g. with client certificates for mutual TLS
+	TLSConfig *tls.Config
+
+	// ProxyURL, if set, routes the uploads of a Client that has no transport
+	// through an HTTP, HTTPS or SOCKS5 proxy, e.g. "socks5://proxy:1080".
+	// When empty, HTTP_PROXY, HTTPS_PROXY and NO_PROXY apply.
+	ProxyURL string
+
+	clientOnce      sync.Once
+	transportClient *http.Client
+	clientErr       error
+
+	// rand picks the jittered retry delays, so instances restarting together
+	// do not retry in lockstep; tests may set a seeded source
+	randMu sync.Mutex
+	rand   *rand.Rand
 }
 
+// Retry backoff doubles from baseBackoff, with full jitter
+const (
+	baseBackoff       = 100 * time.Millisecond
+	DefaultMaxBackoff = 10 * time.Second
+)
+
 func NewHTTPStorage(url, apiKey, env string) *HTTPStorage {
 	return &HTTPStorage{
-		URL:     url,
-		APIKey:  apiKey,
-		Client:  &http.Client{Timeout: 30 * time.Second},
-		Retries: 3,
-		Env:     env,
+		URL:        url,
+		APIKey:     apiKey,
+		Client:     &http.Client{Timeout: 30 * time.Second},
+		Retries:    3,
+		Env:        env,
+		MaxBackoff: DefaultMaxBackoff,
 	}
 }
 
-func (s *HTTPStorage) Upload(ctx context.Context, filePath string) (string, error) {
-	if s.URL == "" || s.APIKey == "" {
+func (s *HTTPStorage) Upload(ctx context.Context, filePath string) (UploadResult, error) {
+	uploadURL, err := s.uploadURL(ctx)
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	// Open and compress the file, or decompress it for plain uploads
+	var data []byte
+	if s.DisableCompression {
+		data, err = os.ReadFile(filePath)
+		if err != nil {
+			return UploadResult{}, fmt.Errorf("failed to read file: %w", err)
+		}
+		data, err = deCompressProfile(data)
+	} else {
+		data, err = s.readAndCompressFile(filePath)
+	}
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	return s.send(ctx, uploadURL, data)
+}
+
+// UploadStream uploads a profile read from r, encoded as for Upload. The
+// body is held in memory so it can be resent on retries.
+func (s *HTTPStorage) UploadStream(ctx context.Context, r io.Reader, name string) (UploadResult, error) {
+	uploadURL, err := s.uploadURL(ctx)
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return UploadResult{}, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	data, err = s.encode(data)
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	return s.send(ctx, uploadURL, data)
+}
+
+// uploadURL returns the URL a profile uploaded under ctx is sent to,
+// checking that it is usable.
+func (s *HTTPStorage) uploadURL(ctx context.Context) (string, error) {
+	uploadURL := s.URL
+	if t, ok := ProfileTypeFromUploadContext(ctx); ok && s.URLByType[t] != "" {
+		uploadURL = s.URLByType[t]
+	}
+	if uploadURL == "" || s.APIKey == "" {
 		return "", errors.New("URL and APIKey are required")
 	}
+	if id, ok := ProfileIDFromUploadContext(ctx); ok {
+		uploadURL = strings.TrimSuffix(uploadURL, "/") + "/" + url.PathEscape(id)
+	}
 
 	// Validate URL format and ensure HTTPS
-	parsedURL, err := url.Parse(s.URL)
+	parsedURL, err := url.Parse(uploadURL)
 	if err != nil {
 		return "", fmt.Errorf("invalid URL: %w", err)
 	}
-	if parsedURL.Scheme != "https" && s.Env != "local" {
+	if parsedURL.Scheme != "https" && s.Env != "local" && !isLoopback(parsedURL) {
 		return "", errors.New("HTTPS is required for secure uploads")
 	}
+	return uploadURL, nil
+}
 
-	// Open and compress the file
-	data, err := s.readAndCompressFile(filePath)
+// send uploads the request body data with retries and decodes the response.
+func (s *HTTPStorage) send(ctx context.Context, uploadURL string, data []byte) (UploadResult, error) {
+	body, attempts, err := s.uploadWithRetries(ctx, uploadURL, data)
 	if err != nil {
-		return "", err
+		return UploadResult{Attempts: attempts}, err
+	}
+
+	result := decodeUploadResponse(body)
+	result.Size = int64(len(data))
+	result.Attempts = attempts
+	return result, nil
+}
+
+// isLoopback reports whether u points at the local machine, where plain HTTP
+// is allowed so local development works without setting Env to "local".
+func isLoopback(u *url.URL) bool {
+	host := u.Hostname()
+	if strings.EqualFold(host, "localhost") {
+		return true
 	}
 
-	// Upload with retries
-	return s.uploadWithRetries(ctx, data)
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
 }
 
 func (s *HTTPStorage) readAndCompressFile(filePath string) ([]byte, error) {
@@ -76,11 +221,36 @@ func (s *HTTPStorage) readAndCompressFile(filePath string) ([]byte, error) {
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
 
+	return CompressProfile(fileData)
+}
+
+// encode prepares a profile as the request body: gzipped, or plain protobuf
+// when DisableCompression is set.
+func (s *HTTPStorage) encode(data []byte) ([]byte, error) {
+	if s.DisableCompression {
+		return deCompressProfile(data)
+	}
+	return CompressProfile(data)
+}
+
+// IsGzipped reports whether data is gzip-compressed, as profiles written by
+// the runtime often are.
+func IsGzipped(data []byte) bool {
+	return bytes.HasPrefix(data, gzipMagic)
+}
+
+// CompressProfile gzips a profile for upload or storage, returning it
+// unchanged when it is already gzipped, since compressing it again would
+// produce a doubly-encoded body.
+func CompressProfile(data []byte) ([]byte, error) {
+	if IsGzipped(data) {
+		return data, nil
+	}
+
 	// Compress with gzip
 	var buf bytes.Buffer
 	gzipWriter := gzip.NewWriter(&buf)
-	_, err = gzipWriter.Write(fileData)
-	if err != nil {
+	if _, err := gzipWriter.Write(data); err != nil {
 		return nil, fmt.Errorf("failed to compress data: %w", err)
 	}
 	if err := gzipWriter.Close(); err != nil {
@@ -90,29 +260,63 @@ func (s *HTTPStorage) readAndCompressFile(filePath string) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-func (s *HTTPStorage) uploadWithRetries(ctx context.Context, data []byte) (string, error) {
+// decompressData returns the plain protobuf of a profile, undoing the gzip
+// applied by the runtime or at write time.
+func deCompressProfile(data []byte) ([]byte, error) {
+	if !IsGzipped(data) {
+		return data, nil
+	}
+
+	gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress data: %w", err)
+	}
+	defer gzipReader.Close()
+
+	plain, err := io.ReadAll(gzipReader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress data: %w", err)
+	}
+	return plain, nil
+}
+
+func (s *HTTPStorage) uploadWithRetries(ctx context.Context, uploadURL string, data []byte) (string, int, error) {
+	client, err := s.httpClient()
+	if err != nil {
+		return "", 0, err
+	}
+
 	var lastErr error
+	var retryAfter time.Duration
 
 	for attempt := 0; attempt < s.Retries; attempt++ {
-		// Exponential backoff
+		// Exponential backoff, or longer if the server asked us to wait
 		if attempt > 0 {
-			backoff := math.Pow(2, float64(attempt-1)) * 100
-			time.Sleep(time.Duration(backoff) * time.Millisecond)
+			if err := sleepContext(ctx, retryDelay(s.backoff(attempt), retryAfter, s.MaxBackoff)); err != nil {
+				return "", attempt, fmt.Errorf("upload canceled during backoff: %w", err)
+			}
+			retryAfter = 0
 		}
 
 		// Create the request
-		req, err := http.NewRequestWithContext(ctx, "POST", s.URL, bytes.NewReader(data))
+		req, err := http.NewRequestWithContext(ctx, "POST", uploadURL, bytes.NewReader(data))
 		if err != nil {
 			lastErr = fmt.Errorf("failed to create request: %w", err)
 			continue
 		}
 
+		setHeaders(req.Header, s.Headers)
 		req.Header.Set("Content-Type", "application/octet-stream")
-		req.Header.Set("Content-Encoding", "gzip")
+		if !s.DisableCompression {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
 		req.Header.Set("Authorization", "Bearer "+s.APIKey)
+		if id, ok := CorrelationIDFromUploadContext(ctx); ok {
+			req.Header.Set(CorrelationIDHeader, id)
+		}
 
 		// Send the request
-		resp, err := s.Client.Do(req)
+		resp, err := client.Do(req)
 		if err != nil {
 			lastErr = fmt.Errorf("request failed: %w", err)
 			continue
@@ -122,16 +326,19 @@ func (s *HTTPStorage) uploadWithRetries(ctx context.Context, data []byte) (strin
 
 		// Handle HTTP errors
 		if resp.StatusCode == 401 || resp.StatusCode == 403 {
-			return "", fmt.Errorf("authentication failed: %d", resp.StatusCode)
+			return "", attempt + 1, responseError("authentication failed", resp)
 		}
 
 		if resp.StatusCode == 429 || (resp.StatusCode >= 500 && resp.StatusCode < 600) {
-			lastErr = fmt.Errorf("server error: %d", resp.StatusCode)
+			lastErr = responseError("server error", resp)
+			if resp.StatusCode == 429 {
+				retryAfter, _ = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+			}
 			continue
 		}
 
 		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-			return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+			return "", attempt + 1, responseError("unexpected status code", resp)
 		}
 
 		// Read response
@@ -141,14 +348,125 @@ func (s *HTTPStorage) uploadWithRetries(ctx context.Context, data []byte) (strin
 			continue
 		}
 
-		return string(body), nil
+		return string(body), attempt + 1, nil
+	}
+
+	return "", s.Retries, fmt.Errorf("upload failed after %d attempts: %w", s.Retries, lastErr)
+}
+
+// setHeaders adds custom headers to h. It is called before a request's own
+// headers are set, so those take precedence.
+func setHeaders(h http.Header, headers map[string]string) {
+	for k, v := range headers {
+		h.Set(k, v)
+	}
+}
+
+// backoff returns the delay before the given retry attempt: a random
+// duration between zero and baseBackoff doubled per attempt, capped at
+// MaxBackoff.
+func (s *HTTPStorage) backoff(attempt int) time.Duration {
+	ceiling := float64(baseBackoff) * math.Pow(2, float64(attempt-1))
+	if s.MaxBackoff > 0 && ceiling > float64(s.MaxBackoff) {
+		ceiling = float64(s.MaxBackoff)
+	}
+	n := int64(math.MaxInt64)
+	if ceiling < math.MaxInt64 {
+		n = int64(ceiling) + 1
+	}
+
+	s.randMu.Lock()
+	defer s.randMu.Unlock()
+	if s.rand == nil {
+		s.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return time.Duration(s.rand.Int63n(n))
+}
+
+// sleepContext waits for d, returning early with the context error if ctx is
+// done first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// maxErrorBodyBytes bounds how much of an error response body is kept
+const maxErrorBodyBytes = 512
+
+// responseError builds an error for a non-2xx response that includes a
+// truncated copy of the body, since proxies and the ingest API often explain
+// the failure there.
+func responseError(msg string, resp *http.Response) error {
+	body := readErrorBody(resp.Body)
+	if body == "" {
+		return fmt.Errorf("%s: %d", msg, resp.StatusCode)
+	}
+	return fmt.Errorf("%s: %d: %s", msg, resp.StatusCode, body)
+}
+
+// readErrorBody reads up to maxErrorBodyBytes of r with whitespace collapsed
+// so multi-line HTML pages stay readable in a single log line.
+func readErrorBody(r io.Reader) string {
+	data, _ := io.ReadAll(io.LimitReader(r, maxErrorBodyBytes+1))
+	truncated := len(data) > maxErrorBodyBytes
+	if truncated {
+		data = data[:maxErrorBodyBytes]
 	}
 
-	return "", fmt.Errorf("upload failed after %d attempts: %w", s.Retries, lastErr)
+	body := strings.Join(strings.Fields(strings.ToValidUTF8(string(data), "")), " ")
+	if truncated && body != "" {
+		body += "..."
+	}
+	return body
+}
+
+// profileTypeFromPath infers the profile type from a collected profile's file
+// name, returning "unknown" when it cannot be determined.
+func profileTypeFromPath(filePath string) string {
+	name := filepath.Base(filePath)
+	for _, t := range []profileType{
+		profileTypeCPU, profileTypeMemory, profileTypeAllocs, profileTypeGoroutine, profileTypeMutex, profileTypeBlock, profileTypeCustom,
+		profileTypeTrace,
+	} {
+		if strings.HasPrefix(name, string(t)) {
+			return string(t)
+		}
+	}
+	return "unknown"
 }
 
 type FileStorage struct {
+	// seq numbers stored files so names stay unique within a timestamp. It
+	// is first so atomic operations on it are 64-bit aligned on 32-bit
+	// platforms.
+	seq uint64
+
 	Directory string
+
+	// extensions records the non-default extensions written per profile
+	// type, so retention can tell stored profiles from other files
+	extMu      sync.Mutex
+	extensions map[string]map[string]bool
+
+	// MaxFiles keeps at most this many profiles in Directory, removing the
+	// oldest after each upload. Zero means no limit.
+	MaxFiles int
+
+	// MaxAge removes profiles older than this after each upload. Zero means
+	// profiles never expire.
+	MaxAge time.Duration
+
+	// MaxBytes caps the total size of the profiles in Directory, removing
+	// the least recently modified after each upload until the rest fit.
+	// The profile just written is always kept. Zero means no limit.
+	MaxBytes int64
 }
 
 func NewFileStorage(directory string) (*FileStorage, error) {
@@ -164,33 +482,80 @@ func NewFileStorage(directory string) (*FileStorage, error) {
 	return &FileStorage{Directory: directory}, nil
 }
 
-func (s *FileStorage) Upload(ctx context.Context, filePath string) (string, error) {
+func (s *FileStorage) Upload(ctx context.Context, filePath string) (UploadResult, error) {
 	if s.Directory == "" {
-		return "", errors.New("directory is required")
+		return UploadResult{}, errors.New("directory is required")
 	}
 
-	fileName := filepath.Base(filePath)
-	targetPath := filepath.Join(s.Directory, fileName)
+	targetPath := filepath.Join(s.Directory, s.fileName(ctx, filePath))
 
 	// Copy the file
 	source, err := os.Open(filePath)
 	if err != nil {
-		return "", fmt.Errorf("failed to open source file: %w", err)
+		return UploadResult{}, fmt.Errorf("failed to open source file: %w", err)
 	}
 	defer source.Close()
 
 	dest, err := os.Create(targetPath)
 	if err != nil {
-		return "", fmt.Errorf("failed to create destination file: %w", err)
+		return UploadResult{}, fmt.Errorf("failed to create destination file: %w", err)
 	}
 	defer dest.Close()
 
-	_, err = io.Copy(dest, source)
+	n, err := io.Copy(dest, source)
 	if err != nil {
-		return "", fmt.Errorf("failed to copy file: %w", err)
+		return UploadResult{}, fmt.Errorf("failed to copy file: %w", err)
 	}
 
-	return targetPath, nil
+	// Only files matching the profile naming scheme are pruned, so unrelated
+	// files in Directory are never touched
+	s.prune(targetPath)
+
+	return UploadResult{ProfileURL: targetPath, Size: n}, nil
+}
+
+// fileName returns a unique name for a stored profile:
+// "<service>_<type>_<UTC timestamp>_<seq><ext>", where the extension is
+// recorded for retention. The service is omitted when Upload is called
+// outside the profiler.
+func (s *FileStorage) fileName(ctx context.Context, filePath string) string {
+	profileType := string(UploadProfileType(ctx, filePath))
+
+	ext := storedExtension(ctx, filePath)
+	s.recordExtension(profileType, ext)
+	name := fmt.Sprintf("%s_%s_%d%s", profileType, time.Now().UTC().Format(storedTimeFormat),
+		atomic.AddUint64(&s.seq, 1), ext)
+	if service, ok := ServiceNameFromUploadContext(ctx); ok && service != "" {
+		name = sanitizeFileName(service) + "_" + name
+	}
+	return name
+}
+
+// storedTimeFormat is the timestamp layout in stored profile names
+const storedTimeFormat = "20060102T150405.000000000Z"
+
+// storedExtension returns the extension to store the profile at filePath
+// with: the one configured for its type when the profiler uploads it,
+// otherwise the file's own extension, or ".pprof" when it has none.
+func storedExtension(ctx context.Context, filePath string) string {
+	if ext, ok := ExtensionFromUploadContext(ctx); ok {
+		return ext
+	}
+	if ext := filepath.Ext(filePath); ext != "" {
+		return ext
+	}
+	return ".pprof"
+}
+
+// sanitizeFileName replaces characters that are unsafe in file names.
+func sanitizeFileName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '.':
+			return r
+		}
+		return '_'
+	}, s)
 }
 
 // StdoutStorage outputs profile data and metadata to stdout for testing purposes
@@ -202,11 +567,11 @@ func NewStdoutStorage() *StdoutStorage {
 }
 
 // Upload reads the profile file and outputs its contents to stdout in a structured format
-func (s *StdoutStorage) Upload(ctx context.Context, filePath string) (string, error) {
+func (s *StdoutStorage) Upload(ctx context.Context, filePath string) (UploadResult, error) {
 	// Read the profile file
 	data, err := os.ReadFile(filePath)
 	if err != nil {
-		return "", fmt.Errorf("failed to read profile file: %w", err)
+		return UploadResult{}, fmt.Errorf("failed to read profile file: %w", err)
 	}
 
 	// Output profile data header
@@ -220,7 +585,7 @@ func (s *StdoutStorage) Upload(ctx context.Context, filePath string) (string, er
 
 	fmt.Println() // Add separator line
 
-	return "stdout", nil
+	return UploadResult{ProfileURL: "stdout", Size: int64(len(data))}, nil
 }
 
 // displayPprofData uses go tool pprof to show readable profile information
@@ -1,12 +1,25 @@
 package pprofio
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
 )
 
 func TestHTTPStorage_Upload(t *testing.T) {
@@ -60,8 +73,8 @@ func TestHTTPStorage_Upload(t *testing.T) {
 	}
 
 	// Check the result
-	if url != "https://storage.pprofio.com/profile123" {
-		t.Errorf("Storage.Upload() returned %q, want %q", url, "https://storage.pprofio.com/profile123")
+	if url.ProfileURL != "https://storage.pprofio.com/profile123" {
+		t.Errorf("Storage.Upload() returned %q, want %q", url.ProfileURL, "https://storage.pprofio.com/profile123")
 	}
 }
 
@@ -101,13 +114,12 @@ func TestFileStorage_Upload(t *testing.T) {
 	}
 
 	// Check the result
-	expectedPath := filepath.Join(tmpDir, filepath.Base(tmpFile.Name()))
-	if path != expectedPath {
-		t.Errorf("Storage.Upload() returned %q, want %q", path, expectedPath)
+	if filepath.Dir(path.ProfileURL) != tmpDir {
+		t.Errorf("Storage.Upload() returned %q, want a file in %q", path.ProfileURL, tmpDir)
 	}
 
 	// Check the file was copied
-	copiedContent, err := os.ReadFile(path)
+	copiedContent, err := os.ReadFile(path.ProfileURL)
 	if err != nil {
 		t.Fatalf("Failed to read copied file: %v", err)
 	}
@@ -117,6 +129,239 @@ func TestFileStorage_Upload(t *testing.T) {
 	}
 }
 
+// storeProfiles uploads profiles named names to storage, giving the stored
+// copies increasing modification times ending now. It returns the stored
+// file names.
+func storeProfiles(t *testing.T, storage *FileStorage, names ...string) []string {
+	t.Helper()
+	srcDir := t.TempDir()
+	var stored []string
+	for i, name := range names {
+		src := filepath.Join(srcDir, name)
+		if err := os.WriteFile(src, []byte(name), 0600); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+		result, err := storage.Upload(context.Background(), src)
+		if err != nil {
+			t.Fatalf("Upload() error = %v", err)
+		}
+		modTime := time.Now().Add(time.Duration(i-len(names)) * time.Second)
+		if err := os.Chtimes(result.ProfileURL, modTime, modTime); err != nil {
+			t.Fatalf("Chtimes() error = %v", err)
+		}
+		stored = append(stored, filepath.Base(result.ProfileURL))
+	}
+	return stored
+}
+
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestFileStorageMaxFiles(t *testing.T) {
+	dir := t.TempDir()
+	// Files the storage did not write must survive pruning
+	for _, name := range []string{"notes.txt", "cpu-notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+
+	storage, err := NewFileStorage(dir)
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	storage.MaxFiles = 2
+
+	stored := storeProfiles(t, storage, "cpu.pprof1", "goroutine.pprof2", "cpu-3.cpu.pb.gz", "trace.out4", "memory.pprof5")
+
+	want := []string{"cpu-notes.txt", "notes.txt", stored[3], stored[4]}
+	sort.Strings(want)
+	if got := dirNames(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("Directory holds %v, want %v", got, want)
+	}
+}
+
+func TestFileStorageMaxAge(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-2 * time.Hour)
+	expired := []string{"cpu_20240101T000000.000000000Z_1.pprof", "svc_trace_20240101T000000.000000000Z_2.out"}
+	// Look-alikes of stored or collected profile names, which must survive
+	lookalikes := []string{
+		"notes.txt", "cpu-2024.csv", "trace-1.log", "cpu.pprof1", "trace.out2",
+		"cpu_20240101T000000.000000000Z_3.csv", "mutex_20240101T000000.000000000Z_4.pprof.bak",
+	}
+	for _, name := range append(append([]string{}, expired...), lookalikes...) {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, nil, 0600); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatalf("Chtimes() error = %v", err)
+		}
+	}
+
+	storage, err := NewFileStorage(dir)
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	storage.MaxAge = time.Hour
+
+	stored := storeProfiles(t, storage, "cpu.pprof3")
+
+	want := append([]string{stored[0]}, lookalikes...)
+	sort.Strings(want)
+	if got := dirNames(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("Directory holds %v, want %v", got, want)
+	}
+}
+
+func TestFileStorageMaxBytes(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewFileStorage(dir)
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	storage.MaxBytes = 350
+
+	srcDir := t.TempDir()
+	var stored []string
+	for i := 0; i < 6; i++ {
+		src := filepath.Join(srcDir, fmt.Sprintf("cpu.pprof%d", i))
+		if err := os.WriteFile(src, bytes.Repeat([]byte{'x'}, 100), 0600); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+		result, err := storage.Upload(context.Background(), src)
+		if err != nil {
+			t.Fatalf("Upload() error = %v", err)
+		}
+		modTime := time.Now().Add(time.Duration(i-10) * time.Second)
+		if err := os.Chtimes(result.ProfileURL, modTime, modTime); err != nil {
+			t.Fatalf("Chtimes() error = %v", err)
+		}
+		stored = append(stored, filepath.Base(result.ProfileURL))
+
+		var total int64
+		for _, name := range dirNames(t, dir) {
+			info, err := os.Stat(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatalf("Stat() error = %v", err)
+			}
+			total += info.Size()
+		}
+		if total > storage.MaxBytes {
+			t.Fatalf("After upload %d, directory holds %d bytes, want at most %d", i, total, storage.MaxBytes)
+		}
+	}
+
+	// The three newest profiles fit in the budget; older ones were evicted
+	want := stored[3:]
+	sort.Strings(want)
+	if got := dirNames(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("Directory holds %v, want %v", got, want)
+	}
+}
+
+func TestFileStorageUniqueNames(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewFileStorage(dir)
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+
+	src := filepath.Join(t.TempDir(), "cpu.pprof")
+	ctx := withUploadContext(context.Background(), "checkout api", nil, ProfileCPU)
+	var paths []string
+	for _, data := range []string{"first", "second"} {
+		if err := os.WriteFile(src, []byte(data), 0600); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+		result, err := storage.Upload(ctx, src)
+		if err != nil {
+			t.Fatalf("Upload() error = %v", err)
+		}
+		paths = append(paths, result.ProfileURL)
+	}
+
+	if paths[0] == paths[1] {
+		t.Fatalf("Both uploads were stored at %s", paths[0])
+	}
+	for i, want := range []string{"first", "second"} {
+		data, err := os.ReadFile(paths[i])
+		if err != nil {
+			t.Fatalf("ReadFile() error = %v", err)
+		}
+		if string(data) != want {
+			t.Errorf("%s holds %q, want %q", paths[i], data, want)
+		}
+		name := filepath.Base(paths[i])
+		if !strings.HasPrefix(name, "checkout_api_cpu_") || !strings.HasSuffix(name, ".pprof") {
+			t.Errorf("Stored name %q, want service, type and extension", name)
+		}
+		if !storedProfileName.MatchString(name) {
+			t.Errorf("Stored name %q is not recognized for retention", name)
+		}
+	}
+}
+
+func TestStoredExtension(t *testing.T) {
+	// The profiler's configured extension wins over the collected file's name
+	configured := []struct{ path, ext string }{
+		{"/tmp/cpu.pprof123", ".pprof"},
+		{"/tmp/trace.out456", ".out"},
+		{"/tmp/cpu-789.cpu.pb.gz", ".cpu.pb.gz"},
+		{"/tmp/heap_2024.pb.gz", ".pb.gz"},
+		{"/tmp/app-v1.2.pprof", ".pprof"},
+	}
+	for _, tt := range configured {
+		ctx := withUploadExtension(context.Background(), tt.ext)
+		if got := storedExtension(ctx, tt.path); got != tt.ext {
+			t.Errorf("storedExtension(%q) = %q, want the configured %q", tt.path, got, tt.ext)
+		}
+	}
+
+	// External callers keep the file's own extension
+	external := map[string]string{
+		"/data/cpu.pprof":    ".pprof",
+		"/data/trace.out":    ".out",
+		"/data/heap_2024.pb": ".pb",
+		"/data/profile":      ".pprof",
+	}
+	for path, want := range external {
+		if got := storedExtension(context.Background(), path); got != want {
+			t.Errorf("storedExtension(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestUploadProfileType(t *testing.T) {
+	tests := map[string]ProfileType{
+		"/tmp/cpu.pprof123":      ProfileCPU,
+		"/tmp/cpu-123.cpu.pb.gz": ProfileCPU,
+		"/tmp/trace.out456":      ProfileTrace,
+		"/tmp/profile.bin":       "unknown",
+	}
+	for path, want := range tests {
+		if got := UploadProfileType(context.Background(), path); got != want {
+			t.Errorf("UploadProfileType(%q) = %q, want %q", path, got, want)
+		}
+	}
+
+	ctx := withUploadContext(context.Background(), "test-service", nil, ProfileMutex)
+	if got := UploadProfileType(ctx, "/tmp/cpu.pprof123"); got != ProfileMutex {
+		t.Errorf("UploadProfileType() = %q, want the upload context's type", got)
+	}
+}
+
 func TestNewFileStorage_Error(t *testing.T) {
 	// Test with empty directory
 	_, err := NewFileStorage("")
@@ -136,3 +381,668 @@ func TestNewFileStorage_Error(t *testing.T) {
 		t.Error("NewFileStorage() with file path should return error")
 	}
 }
+
+func TestHTTPStorage_UploadLoopbackWithoutLocalEnv(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "profile.pprof")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.WriteString("test profile data"); err != nil {
+		t.Fatalf("Failed to write to temp file: %v", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		t.Fatalf("Failed to close temp file: %v", err)
+	}
+
+	// httptest.NewServer listens on plain http://127.0.0.1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("https://storage.pprofio.com/profile123"))
+	}))
+	defer server.Close()
+
+	storage := NewHTTPStorage(server.URL, "test-key", "")
+	if _, err := storage.Upload(context.Background(), tmpFile.Name()); err != nil {
+		t.Fatalf("Storage.Upload() to loopback without Env=local error = %v", err)
+	}
+
+	// Non-loopback hosts still require HTTPS
+	remote := NewHTTPStorage("http://api.pprofio.com/upload", "test-key", "")
+	if _, err := remote.Upload(context.Background(), tmpFile.Name()); err == nil {
+		t.Error("Storage.Upload() to non-loopback http URL should return error")
+	}
+}
+
+func TestHTTPStorage_UploadWithoutCompression(t *testing.T) {
+	var mu sync.Mutex
+	bodies := map[string][]byte{}
+	encodings := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/upload" {
+			body, _ := io.ReadAll(r.Body)
+			mu.Lock()
+			t := r.Header.Get("X-Test-Type")
+			bodies[t] = body
+			encodings[t] = r.Header.Get("Content-Encoding")
+			mu.Unlock()
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	for _, profileType := range []ProfileType{ProfileCPU, ProfileGoroutine} {
+		t.Run(string(profileType), func(t *testing.T) {
+			p, err := New(Config{
+				APIKey:             "test-key",
+				IngestURL:          server.URL,
+				ServiceName:        "test-service",
+				ProfileDuration:    10 * time.Millisecond,
+				EnableCPU:          profileType == ProfileCPU,
+				EnableGoroutine:    profileType == ProfileGoroutine,
+				Headers:            map[string]string{"X-Test-Type": string(profileType)},
+				DisableCompression: true,
+			})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			// The runtime gzips both profiles as it writes them
+			for _, result := range p.Flush(context.Background(), profileType) {
+				if result.Err != nil {
+					t.Fatalf("Flush(%s) error = %v", profileType, result.Err)
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if encodings[string(profileType)] != "" {
+				t.Errorf("Content-Encoding = %q, want none", encodings[string(profileType)])
+			}
+			body := bodies[string(profileType)]
+			if bytes.HasPrefix(body, gzipMagic) {
+				t.Fatal("Server received a gzipped body")
+			}
+			if _, err := profile.ParseUncompressed(body); err != nil {
+				t.Errorf("Server body is not a valid uncompressed pprof profile: %v", err)
+			}
+		})
+	}
+}
+
+// contextStorage records the upload context values it observes.
+type contextStorage struct {
+	tags        map[string]string
+	profileType ProfileType
+}
+
+func (s *contextStorage) Upload(ctx context.Context, filePath string) (UploadResult, error) {
+	s.tags, _ = TagsFromUploadContext(ctx)
+	s.profileType, _ = ProfileTypeFromUploadContext(ctx)
+	return UploadResult{ProfileURL: "https://storage.pprofio.com/ctx.pprof"}, nil
+}
+
+func TestCustomStorageReadsUploadContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	storage := &contextStorage{}
+	p, err := newProfiler(Config{
+		APIKey:      "test-key",
+		IngestURL:   server.URL,
+		Storage:     storage,
+		ServiceName: "test-service",
+		Tags:        map[string]string{"tenant": "acme", "region": "eu-west-1"},
+	})
+	if err != nil {
+		t.Fatalf("newProfiler() error = %v", err)
+	}
+
+	if _, err := p.collectGoroutine(context.Background()); err != nil {
+		t.Fatalf("collectGoroutine() error = %v", err)
+	}
+
+	if storage.tags["tenant"] != "acme" || storage.tags["region"] != "eu-west-1" {
+		t.Errorf("TagsFromUploadContext() = %v, want tenant and region tags", storage.tags)
+	}
+	if storage.profileType != ProfileGoroutine {
+		t.Errorf("ProfileTypeFromUploadContext() = %q, want %q", storage.profileType, ProfileGoroutine)
+	}
+
+	if _, ok := TagsFromUploadContext(context.Background()); ok {
+		t.Error("TagsFromUploadContext() on a plain context should report false")
+	}
+}
+
+func TestHTTPStorage_UploadSkipsRecompression(t *testing.T) {
+	content := []byte("test profile data")
+
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	gw.Write(content)
+	gw.Close()
+
+	tmpFile, err := os.CreateTemp("", "memory.pprof")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.Write(gzipped.Bytes()); err != nil {
+		t.Fatalf("Failed to write to temp file: %v", err)
+	}
+	tmpFile.Close()
+
+	var body []byte
+	var encoding string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding = r.Header.Get("Content-Encoding")
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	storage := NewHTTPStorage(server.URL, "test-key", "")
+	if _, err := storage.Upload(context.Background(), tmpFile.Name()); err != nil {
+		t.Fatalf("Storage.Upload() error = %v", err)
+	}
+
+	if encoding != "gzip" {
+		t.Errorf("Content-Encoding = %q, want %q", encoding, "gzip")
+	}
+	if !bytes.Equal(body, gzipped.Bytes()) {
+		t.Fatal("Already-gzipped profile should be sent without re-compression")
+	}
+
+	// A single gunzip recovers the original profile
+	gr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("gzip.NewReader() error = %v", err)
+	}
+	decoded, _ := io.ReadAll(gr)
+	if !bytes.Equal(decoded, content) {
+		t.Errorf("Decoded body = %q, want %q", decoded, content)
+	}
+}
+
+func TestErrorResponsesIncludeBody(t *testing.T) {
+	page := "<html>\n<body>\n<h1>502 Bad Gateway</h1>\n<p>upstream ingest-7 unreachable</p>\n</body>\n</html>"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(page))
+	}))
+	defer server.Close()
+
+	tmpFile, err := os.CreateTemp("", "cpu.pprof")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	tmpFile.Close()
+
+	storage := NewHTTPStorage(server.URL, "test-key", "")
+	storage.Retries = 1
+	_, err = storage.Upload(context.Background(), tmpFile.Name())
+	if err == nil || !strings.Contains(err.Error(), "<h1>502 Bad Gateway</h1> <p>upstream ingest-7 unreachable</p>") {
+		t.Errorf("Upload() error = %v, want it to include the response body", err)
+	}
+
+	client := newMetadataClient(server.URL, "test-key")
+	client.retries = 1
+	err = client.sendMetadata(context.Background(), map[string]string{"service": "test-service"})
+	if err == nil || !strings.Contains(err.Error(), "upstream ingest-7 unreachable") {
+		t.Errorf("sendMetadata() error = %v, want it to include the response body", err)
+	}
+}
+
+func TestReadErrorBodyTruncates(t *testing.T) {
+	body := readErrorBody(strings.NewReader(strings.Repeat("x", 2*maxErrorBodyBytes)))
+
+	if want := strings.Repeat("x", maxErrorBodyBytes) + "..."; body != want {
+		t.Errorf("readErrorBody() returned %d bytes, want %d", len(body), len(want))
+	}
+	if body := readErrorBody(strings.NewReader("")); body != "" {
+		t.Errorf("readErrorBody() of empty body = %q, want empty", body)
+	}
+}
+
+func TestUploadPathByType(t *testing.T) {
+	var mu sync.Mutex
+	var uploads []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metadata" {
+			mu.Lock()
+			uploads = append(uploads, r.URL.Path)
+			mu.Unlock()
+			w.Write([]byte(`{"profile_url":"https://storage.pprofio.com/p.pprof"}`))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       server.URL,
+		ProfileDuration: 10 * time.Millisecond,
+		ServiceName:     "test-service",
+		EnableCPU:       true,
+		EnableMemory:    true,
+		EnableGoroutine: true,
+		UploadPathByType: map[ProfileType]string{
+			ProfileCPU:    "/cpu",
+			ProfileMemory: "/heap",
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	for _, result := range p.Flush(context.Background()) {
+		if result.Err != nil {
+			t.Fatalf("Flush() %s error = %v", result.Type, result.Err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"/cpu", "/heap", "/upload"}
+	if len(uploads) != len(want) {
+		t.Fatalf("Upload paths = %v, want %v", uploads, want)
+	}
+	for i := range want {
+		if uploads[i] != want[i] {
+			t.Errorf("Upload %d path = %q, want %q", i, uploads[i], want[i])
+		}
+	}
+}
+
+func TestFileExtensionsNameStoredProfiles(t *testing.T) {
+	metadataServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer metadataServer.Close()
+
+	dir := t.TempDir()
+	storage, err := NewFileStorage(dir)
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       metadataServer.URL,
+		Storage:         storage,
+		ServiceName:     "test-service",
+		ProfileDuration: 10 * time.Millisecond,
+		EnableCPU:       true,
+		EnableGoroutine: true,
+		FileExtensions:  map[ProfileType]string{ProfileCPU: ".cpu.pb.gz"},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	for _, result := range p.Flush(context.Background()) {
+		if result.Err != nil {
+			t.Fatalf("Flush(%s) error = %v", result.Type, result.Err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	var cpu, goroutine bool
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case strings.HasPrefix(name, "test-service_cpu_"):
+			cpu = strings.HasSuffix(name, ".cpu.pb.gz")
+		case strings.HasPrefix(name, "test-service_goroutine_"):
+			goroutine = strings.HasSuffix(name, ".pprof")
+		}
+	}
+	if !cpu {
+		t.Errorf("CPU profile not stored with the .cpu.pb.gz suffix: %v", entries)
+	}
+	if !goroutine {
+		t.Errorf("goroutine profile should keep the default name: %v", entries)
+	}
+}
+
+func TestHTTPStorageUploadResult(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     UploadResult
+	}{
+		{
+			name:     "json",
+			response: `{"profile_id":"p1","profile_url":"https://storage.pprofio.com/p1.pprof","type":"cpu","need_full":true}`,
+			want:     UploadResult{ProfileID: "p1", ProfileURL: "https://storage.pprofio.com/p1.pprof", Type: "cpu", NeedFull: true, Attempts: 1},
+		},
+		{
+			name:     "plain text",
+			response: "https://storage.pprofio.com/p2.pprof\n",
+			want:     UploadResult{ProfileURL: "https://storage.pprofio.com/p2.pprof", Attempts: 1},
+		},
+	}
+
+	tmpFile, err := os.CreateTemp("", "cpu.pprof")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	tmpFile.Write([]byte("profile"))
+	tmpFile.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				sent = len(body)
+				w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+
+			got, err := NewHTTPStorage(server.URL, "test-key", "").Upload(context.Background(), tmpFile.Name())
+			if err != nil {
+				t.Fatalf("Upload() error = %v", err)
+			}
+
+			tt.want.Size = int64(sent)
+			if got != tt.want {
+				t.Errorf("Upload() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPStorageBackoffJitter(t *testing.T) {
+	storage := &HTTPStorage{MaxBackoff: time.Second, rand: rand.New(rand.NewSource(1))}
+
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 50; i++ {
+		for attempt := 1; attempt <= 8; attempt++ {
+			ceiling := baseBackoff << uint(attempt-1)
+			if ceiling > storage.MaxBackoff {
+				ceiling = storage.MaxBackoff
+			}
+
+			d := storage.backoff(attempt)
+			if d < 0 || d > ceiling {
+				t.Fatalf("backoff(%d) = %v, want within [0, %v]", attempt, d, ceiling)
+			}
+			seen[d] = true
+		}
+	}
+	if len(seen) < 100 {
+		t.Errorf("backoff produced only %d distinct delays; jitter missing", len(seen))
+	}
+
+	// Same seed, same delays
+	a := &HTTPStorage{rand: rand.New(rand.NewSource(7))}
+	b := &HTTPStorage{rand: rand.New(rand.NewSource(7))}
+	for attempt := 1; attempt <= 5; attempt++ {
+		if da, db := a.backoff(attempt), b.backoff(attempt); da != db {
+			t.Errorf("backoff(%d) differs for the same seed: %v vs %v", attempt, da, db)
+		}
+	}
+
+	// Without a cap, very late attempts must not overflow
+	if d := (&HTTPStorage{}).backoff(200); d < 0 {
+		t.Errorf("backoff(200) = %v, want non-negative", d)
+	}
+}
+
+// retryAfterServer rejects the first request with 429 and the Retry-After
+// value returned by header, then accepts. It reports the gap between the
+// first two requests.
+func retryAfterServer(t *testing.T, header func() string) (*httptest.Server, func() time.Duration) {
+	t.Helper()
+	var mu sync.Mutex
+	var times []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		first := len(times) == 1
+		mu.Unlock()
+
+		if first {
+			w.Header().Set("Retry-After", header())
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() time.Duration {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(times) < 2 {
+			t.Fatalf("Expected a retry after 429, got %d requests", len(times))
+		}
+		return times[1].Sub(times[0])
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"3", 3 * time.Second, true},
+		{" 0 ", 0, true},
+		{now.Add(5 * time.Second).Format(http.TimeFormat), 5 * time.Second, true},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0, true},
+		{"99999999999999", maxRetryAfter, true},
+		{"", 0, false},
+		{"-1", 0, false},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value, now)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseRetryAfter(%q) = %v, %v; want %v, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestHTTPStorageHonorsRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		header func() string
+	}{
+		{"seconds", func() string { return "1" }},
+		{"http-date", func() string { return time.Now().Add(2 * time.Second).UTC().Format(http.TimeFormat) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, gap := retryAfterServer(t, tt.header)
+			storage := NewHTTPStorage(server.URL, "test-key", "")
+
+			if _, _, err := storage.uploadWithRetries(context.Background(), server.URL, []byte("data")); err != nil {
+				t.Fatalf("uploadWithRetries() error = %v", err)
+			}
+			// HTTP dates have one second resolution, so allow for truncation
+			if d := gap(); d < 900*time.Millisecond {
+				t.Errorf("Retried after %v, want at least the Retry-After delay", d)
+			}
+		})
+	}
+}
+
+func TestHTTPStorageRetryAfterCappedByMaxBackoff(t *testing.T) {
+	server, gap := retryAfterServer(t, func() string { return "3600" })
+	storage := NewHTTPStorage(server.URL, "test-key", "")
+	storage.MaxBackoff = 50 * time.Millisecond
+
+	if _, _, err := storage.uploadWithRetries(context.Background(), server.URL, []byte("data")); err != nil {
+		t.Fatalf("uploadWithRetries() error = %v", err)
+	}
+	if d := gap(); d > time.Second {
+		t.Errorf("Retried after %v, want Retry-After capped by MaxBackoff", d)
+	}
+}
+
+func TestHTTPStorageBackoffReturnsOnCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	storage := NewHTTPStorage(server.URL, "test-key", "")
+	storage.MaxBackoff = time.Minute
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, _, err := storage.uploadWithRetries(ctx, server.URL, []byte("data"))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("uploadWithRetries() error = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("uploadWithRetries() returned after %v, want prompt return on cancel", elapsed)
+	}
+}
+
+func TestCustomHeaders(t *testing.T) {
+	var mu sync.Mutex
+	seen := make(map[string]http.Header)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen[r.URL.Path] = r.Header.Clone()
+		mu.Unlock()
+		if r.URL.Path == "/upload" {
+			w.Write([]byte(`{"profile_url":"https://storage.pprofio.com/p1.pprof"}`))
+		}
+	}))
+	defer server.Close()
+
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       server.URL,
+		ServiceName:     "test-service",
+		EnableGoroutine: true,
+		Headers: map[string]string{
+			"X-Tenant-ID":   "tenant-1",
+			"Authorization": "Bearer gateway-token",
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if results := p.Flush(context.Background(), ProfileGoroutine); results[0].Err != nil {
+		t.Fatalf("Flush() error = %v", results[0].Err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, path := range []string{"/upload", "/metadata"} {
+		h, ok := seen[path]
+		if !ok {
+			t.Fatalf("no request to %s", path)
+		}
+		if got := h.Get("X-Tenant-ID"); got != "tenant-1" {
+			t.Errorf("%s X-Tenant-ID = %q, want %q", path, got, "tenant-1")
+		}
+		if got := h.Get("Authorization"); got != "Bearer test-key" {
+			t.Errorf("%s Authorization = %q, want the API key to take precedence", path, got)
+		}
+	}
+}
+
+// countingTransport counts the requests it sends per path
+type countingTransport struct {
+	mu    sync.Mutex
+	paths []string
+}
+
+func (t *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	t.paths = append(t.paths, r.URL.Path)
+	t.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestConfigHTTPClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/upload" {
+			w.Write([]byte(`{"profile_url":"https://storage.pprofio.com/p1.pprof"}`))
+		}
+	}))
+	defer server.Close()
+
+	transport := &countingTransport{}
+	client := &http.Client{Transport: transport, Timeout: 5 * time.Second}
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       server.URL,
+		ServiceName:     "test-service",
+		EnableGoroutine: true,
+		HTTPClient:      client,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if storage := p.config.Storage.(*HTTPStorage); storage.Client != client {
+		t.Error("HTTPStorage should use Config.HTTPClient")
+	}
+
+	if results := p.Flush(context.Background(), ProfileGoroutine); results[0].Err != nil {
+		t.Fatalf("Flush() error = %v", results[0].Err)
+	}
+
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+	if len(transport.paths) != 2 || transport.paths[0] != "/upload" || transport.paths[1] != "/metadata" {
+		t.Errorf("custom client sent %v, want the upload and its metadata", transport.paths)
+	}
+}
+
+func TestConfigHTTPTimeout(t *testing.T) {
+	p, err := New(Config{
+		APIKey:      "test-key",
+		IngestURL:   "https://api.pprofio.com",
+		ServiceName: "test-service",
+		HTTPTimeout: 3 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if got := p.config.Storage.(*HTTPStorage).Client.Timeout; got != 3*time.Second {
+		t.Errorf("upload timeout = %v, want 3s", got)
+	}
+	if got := p.newIngestClient().client.Timeout; got != 3*time.Second {
+		t.Errorf("metadata timeout = %v, want 3s", got)
+	}
+
+	// The defaults are kept when no timeout is set
+	p, err = New(Config{
+		APIKey:      "test-key",
+		IngestURL:   "https://api.pprofio.com",
+		ServiceName: "test-service",
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if got := p.config.Storage.(*HTTPStorage).Client.Timeout; got != 30*time.Second {
+		t.Errorf("default upload timeout = %v, want 30s", got)
+	}
+	if got := p.newIngestClient().client.Timeout; got != 10*time.Second {
+		t.Errorf("default metadata timeout = %v, want 10s", got)
+	}
+}
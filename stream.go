@@ -0,0 +1,107 @@
+package pprofio
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// StreamStorage is implemented by storages that can upload a profile read
+// from r instead of a file. For memory, allocs, goroutine, mutex and block
+// profiles the profiler then writes the profile to memory and streams it to
+// the storage, skipping the temp file. CPU profiles and traces are always
+// collected into a file. name is the file name the profile would have had,
+// e.g. "goroutine.pprof", from which storages may infer its type.
+type StreamStorage interface {
+	Storage
+	UploadStream(ctx context.Context, r io.Reader, name string) (UploadResult, error)
+}
+
+// collectedProfile is a profile ready for upload: a temp file, or bytes held
+// in memory when streamed to a StreamStorage
+type collectedProfile struct {
+	path string
+
+	name string
+	data []byte
+}
+
+// profileFile returns a collectedProfile for the profile at path.
+func profileFile(path string) collectedProfile {
+	return collectedProfile{path: path}
+}
+
+// size returns the profile's size in bytes, or 0 if it cannot be read.
+func (c collectedProfile) size() int64 {
+	if c.path == "" {
+		return int64(len(c.data))
+	}
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// bytes returns the profile's contents.
+func (c collectedProfile) bytes() ([]byte, error) {
+	if c.path == "" {
+		return c.data, nil
+	}
+	return os.ReadFile(c.path)
+}
+
+// streaming reports whether profiles written with WriteTo are streamed to
+// Storage from memory. Kept temp files need a file to keep.
+func (p *Profiler) streaming() bool {
+	_, ok := p.config.Storage.(StreamStorage)
+	return ok && !p.config.KeepTempFiles
+}
+
+// collectWritten writes a profile of the given type with write and uploads
+// it, streamed from memory when Storage implements StreamStorage and through
+// a temp file otherwise.
+func (p *Profiler) collectWritten(ctx context.Context, profileType profileType, write func(io.Writer) error) (CollectionResult, error) {
+	profile, release, err := p.writeCollected(profileType, write)
+	if err != nil {
+		return CollectionResult{}, err
+	}
+	defer release()
+
+	return p.uploadCollected(ctx, profile, profileType)
+}
+
+// writeCollected writes a profile of the given type with write, into memory
+// when streaming and into a temp file otherwise. release frees the temp
+// file once the profile is uploaded.
+func (p *Profiler) writeCollected(profileType profileType, write func(io.Writer) error) (profile collectedProfile, release func(), err error) {
+	if p.streaming() {
+		var buf bytes.Buffer
+		if err := write(&buf); err != nil {
+			return collectedProfile{}, nil, fmt.Errorf("failed to write %s profile: %w", profileType, err)
+		}
+		return collectedProfile{name: p.profileFileName(profileType), data: buf.Bytes()}, func() {}, nil
+	}
+
+	f, err := p.createTempFile(profileType)
+	if err != nil {
+		return collectedProfile{}, nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	release = func() { p.releaseTempFile(f.Name(), profileType) }
+
+	err = write(f)
+	f.Close()
+	if err != nil {
+		release()
+		return collectedProfile{}, nil, fmt.Errorf("failed to write %s profile: %w", profileType, err)
+	}
+	return profileFile(f.Name()), release, nil
+}
+
+// profileFileName returns the name a streamed profile is uploaded under,
+// with the type's configured FileExtensions suffix when set.
+func (p *Profiler) profileFileName(profileType profileType) string {
+	return string(profileType) + p.profileExtension(profileType)
+}
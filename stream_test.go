@@ -0,0 +1,92 @@
+package pprofio
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestHTTPStorageUploadStream(t *testing.T) {
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "gzip" {
+			t.Errorf("Content-Encoding = %q, want gzip", r.Header.Get("Content-Encoding"))
+		}
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Errorf("gzip.NewReader() error = %v", err)
+			return
+		}
+		body, _ = io.ReadAll(zr)
+		w.Write([]byte(`{"profile_url":"https://storage.pprofio.com/p1.pprof"}`))
+	}))
+	defer server.Close()
+
+	storage := NewHTTPStorage(server.URL+"/upload", "test-key", "")
+	result, err := storage.UploadStream(context.Background(), bytes.NewReader([]byte("heap profile")), "memory.pprof")
+	if err != nil {
+		t.Fatalf("UploadStream() error = %v", err)
+	}
+	if result.ProfileURL != "https://storage.pprofio.com/p1.pprof" {
+		t.Errorf("ProfileURL = %q", result.ProfileURL)
+	}
+	if string(body) != "heap profile" {
+		t.Errorf("server received %q, want %q", body, "heap profile")
+	}
+}
+
+func TestStreamedProfilesSkipTempFiles(t *testing.T) {
+	// Temp files cannot be created, so collection only succeeds if the
+	// profiles are streamed
+	t.Setenv("TMPDIR", filepath.Join(t.TempDir(), "missing"))
+
+	var mu sync.Mutex
+	var uploads [][]byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/upload" {
+			data, _ := io.ReadAll(r.Body)
+			mu.Lock()
+			uploads = append(uploads, data)
+			mu.Unlock()
+			w.Write([]byte(`{"profile_url":"https://storage.pprofio.com/p1.pprof"}`))
+		}
+	}))
+	defer server.Close()
+
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       server.URL,
+		ServiceName:     "test-service",
+		EnableMemory:    true,
+		EnableGoroutine: true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	for _, result := range p.Flush(context.Background(), ProfileMemory, ProfileGoroutine) {
+		if result.Err != nil {
+			t.Fatalf("Flush() %s error = %v", result.Type, result.Err)
+		}
+		if result.SizeBytes == 0 {
+			t.Errorf("%s SizeBytes = 0, want the streamed profile's size", result.Type)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(uploads) != 2 {
+		t.Fatalf("server received %d uploads, want 2", len(uploads))
+	}
+	for _, data := range uploads {
+		if !bytes.HasPrefix(data, gzipMagic) {
+			t.Error("streamed upload is not gzip-encoded")
+		}
+	}
+}
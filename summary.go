@@ -0,0 +1,47 @@
+package pprofio
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// collectResult collects one profile type and records the outcome, including
+// how long collection and upload took.
+func (p *Profiler) collectResult(ctx context.Context, t profileType) CollectionResult {
+	start := time.Now()
+	result, err := p.collectProfile(ctx, t)
+	result.Type = t
+	result.Err = err
+	result.Duration = time.Since(start)
+	return result
+}
+
+// logCycleSummary emits a single log line aggregating every profile collected
+// in one cycle. Cycles with failures are logged as errors.
+func (p *Profiler) logCycleSummary(trigger string, results []CollectionResult, elapsed time.Duration) {
+	if len(results) == 0 {
+		return
+	}
+
+	types := make([]string, 0, len(results))
+	var totalBytes int64
+	var failures []string
+	for _, result := range results {
+		types = append(types, string(result.Type))
+		totalBytes += result.SizeBytes
+		if result.Err != nil {
+			failures = append(failures, fmt.Sprintf("%s (%s): %v", result.Type, result.Duration.Round(time.Millisecond), result.Err))
+		}
+	}
+
+	summary := fmt.Sprintf("profile cycle service=%s trigger=%s types=%s bytes=%d duration=%s errors=%d",
+		p.config.ServiceName, trigger, strings.Join(types, ","), totalBytes, elapsed.Round(time.Millisecond), len(failures))
+
+	if len(failures) > 0 {
+		p.config.Logger.Errorf("%s [%s]", summary, strings.Join(failures, "; "))
+		return
+	}
+	p.config.Logger.Debugf("%s", summary)
+}
@@ -0,0 +1,152 @@
+package pprofio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// captureLogger records log lines by level
+type captureLogger struct {
+	mu     sync.Mutex
+	debugs []string
+	errors []string
+}
+
+func (l *captureLogger) Debugf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.debugs = append(l.debugs, fmt.Sprintf(format, args...))
+}
+
+func (l *captureLogger) Errorf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errors = append(l.errors, fmt.Sprintf(format, args...))
+}
+
+// summaries returns the cycle summary lines logged at either level
+func (l *captureLogger) summaries() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var lines []string
+	for _, line := range append(append([]string{}, l.debugs...), l.errors...) {
+		if strings.HasPrefix(line, "profile cycle ") {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// failingTypeStorage rejects uploads of one profile type
+type failingTypeStorage struct {
+	captureStorage
+	fail ProfileType
+}
+
+func (s *failingTypeStorage) Upload(ctx context.Context, filePath string) (UploadResult, error) {
+	if t, _ := ProfileTypeFromUploadContext(ctx); t == s.fail {
+		return UploadResult{}, errors.New("storage unavailable")
+	}
+	return s.captureStorage.Upload(ctx, filePath)
+}
+
+func TestCycleSummaryLogged(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	logger := &captureLogger{}
+	storage := &captureStorage{}
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       server.URL,
+		SampleRate:      time.Minute,
+		Storage:         storage,
+		ServiceName:     "test-service",
+		EnableMemory:    true,
+		EnableGoroutine: true,
+		Snapshots:       true,
+		Logger:          logger,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := p.collectSnapshot(context.Background()); err != nil {
+			t.Fatalf("collectSnapshot() error = %v", err)
+		}
+	}
+
+	summaries := logger.summaries()
+	if len(summaries) != 2 {
+		t.Fatalf("Expected 2 cycle summaries, got %d: %v", len(summaries), summaries)
+	}
+
+	storage.mu.Lock()
+	var firstCycleBytes int
+	for _, upload := range storage.uploads[:2] {
+		firstCycleBytes += len(upload)
+	}
+	storage.mu.Unlock()
+
+	for _, want := range []string{
+		"service=test-service",
+		"trigger=scheduled",
+		"types=memory,goroutine",
+		fmt.Sprintf("bytes=%d", firstCycleBytes),
+		"duration=",
+		"errors=0",
+	} {
+		if !strings.Contains(summaries[0], want) {
+			t.Errorf("Summary %q missing %q", summaries[0], want)
+		}
+	}
+	if len(logger.errors) != 0 {
+		t.Errorf("Expected no error logs, got %v", logger.errors)
+	}
+}
+
+func TestCycleSummaryReportsErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	logger := &captureLogger{}
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       server.URL,
+		Storage:         &failingTypeStorage{fail: ProfileGoroutine},
+		ServiceName:     "test-service",
+		EnableMemory:    true,
+		EnableGoroutine: true,
+		Logger:          logger,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	p.Flush(context.Background())
+
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	if len(logger.errors) != 1 {
+		t.Fatalf("Expected 1 error summary, got %d: %v", len(logger.errors), logger.errors)
+	}
+	summary := logger.errors[0]
+	for _, want := range []string{"trigger=manual", "types=memory,goroutine", "errors=1", "storage unavailable"} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("Summary %q missing %q", summary, want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+//go:build !windows && !plan9
+
+package pprofio
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/syslog"
+	"os"
+)
+
+// DefaultSyslogMaxBytes is the largest encoded profile embedded in a syslog record
+const DefaultSyslogMaxBytes = 64 * 1024
+
+// syslogWriter is the subset of *syslog.Writer used by SyslogStorage
+type syslogWriter interface {
+	Info(m string) error
+}
+
+// SyslogStorage emits profiles as syslog records, for hosts that forward
+// everything through syslog or journald and block outbound HTTP.
+//
+// Profiles whose base64 encoding exceeds MaxBytes are logged with their type,
+// size, service and correlation ID only, marked omitted=true.
+type SyslogStorage struct {
+	MaxBytes int
+	writer   syslogWriter
+}
+
+// NewSyslogStorage connects to the local syslog daemon using the given tag
+func NewSyslogStorage(tag string) (*SyslogStorage, error) {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+	}
+
+	return &SyslogStorage{MaxBytes: DefaultSyslogMaxBytes, writer: w}, nil
+}
+
+// Upload writes a record describing the profile, embedding it when small enough
+func (s *SyslogStorage) Upload(ctx context.Context, filePath string) (UploadResult, error) {
+	if s.writer == nil {
+		return UploadResult{}, errors.New("syslog writer is required")
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return UploadResult{}, fmt.Errorf("failed to read profile file: %w", err)
+	}
+
+	record := fmt.Sprintf("pprofio profile type=%s size=%d", UploadProfileType(ctx, filePath), len(data))
+	if service, ok := ServiceNameFromUploadContext(ctx); ok && service != "" {
+		record += " service=" + sanitizeFileName(service)
+	}
+	// The correlation ID is also sent with the profile's metadata, tying an
+	// omitted profile's record to it
+	if id, ok := CorrelationIDFromUploadContext(ctx); ok {
+		record += " correlation_id=" + id
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(data)
+	if s.MaxBytes <= 0 || len(encoded) <= s.MaxBytes {
+		record += " data=" + encoded
+	} else {
+		record += " omitted=true"
+	}
+
+	if err := s.writer.Info(record); err != nil {
+		return UploadResult{}, fmt.Errorf("failed to write syslog record: %w", err)
+	}
+
+	return UploadResult{ProfileURL: "syslog", Size: int64(len(data))}, nil
+}
@@ -0,0 +1,81 @@
+//go:build !windows && !plan9
+
+package pprofio
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"strings"
+	"testing"
+)
+
+type fakeSyslogWriter struct {
+	records []string
+}
+
+func (w *fakeSyslogWriter) Info(m string) error {
+	w.records = append(w.records, m)
+	return nil
+}
+
+func TestSyslogStorage_Upload(t *testing.T) {
+	content := "test profile data"
+	tmpFile, err := os.CreateTemp("", "cpu.pprof")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.WriteString(content); err != nil {
+		t.Fatalf("Failed to write to temp file: %v", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		t.Fatalf("Failed to close temp file: %v", err)
+	}
+
+	writer := &fakeSyslogWriter{}
+	storage := &SyslogStorage{MaxBytes: DefaultSyslogMaxBytes, writer: writer}
+
+	result, err := storage.Upload(context.Background(), tmpFile.Name())
+	if err != nil {
+		t.Fatalf("SyslogStorage.Upload() error = %v", err)
+	}
+	if result.ProfileURL != "syslog" {
+		t.Errorf("SyslogStorage.Upload() result = %q, want %q", result.ProfileURL, "syslog")
+	}
+
+	if len(writer.records) != 1 {
+		t.Fatalf("Expected 1 syslog record, got %d", len(writer.records))
+	}
+
+	record := writer.records[0]
+	if !strings.Contains(record, "type=cpu") {
+		t.Errorf("Record should contain profile type, got %q", record)
+	}
+	if !strings.Contains(record, "size=17") {
+		t.Errorf("Record should contain profile size, got %q", record)
+	}
+	if !strings.Contains(record, "data="+base64.StdEncoding.EncodeToString([]byte(content))) {
+		t.Errorf("Record should embed the encoded profile, got %q", record)
+	}
+
+	// Profiles over the size limit are referenced but not embedded
+	storage.MaxBytes = 4
+	ctx := withCorrelationID(withUploadContext(context.Background(), "checkout", nil, ProfileGoroutine), "abc123")
+	if _, err := storage.Upload(ctx, tmpFile.Name()); err != nil {
+		t.Fatalf("SyslogStorage.Upload() error = %v", err)
+	}
+	record = writer.records[1]
+	if strings.Contains(record, "data=") || !strings.Contains(record, "omitted=true") {
+		t.Errorf("Oversized record should omit the profile, got %q", record)
+	}
+	for _, want := range []string{"type=goroutine", "service=checkout", "correlation_id=abc123"} {
+		if !strings.Contains(record, want) {
+			t.Errorf("Oversized record should contain %q, got %q", want, record)
+		}
+	}
+	if strings.Contains(record, "file=") {
+		t.Errorf("Record should not reference the temp file, got %q", record)
+	}
+}
@@ -0,0 +1,136 @@
+package pprofio
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeClientCert writes a self-signed client certificate and its key as PEM
+// files and returns their paths and the parsed certificate.
+func writeClientCert(t *testing.T) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "pprofio-agent"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate() error = %v", err)
+	}
+	cert, err = x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate() error = %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey() error = %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "client.crt")
+	keyFile = filepath.Join(dir, "client.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	return certFile, keyFile, cert
+}
+
+func TestMutualTLS(t *testing.T) {
+	certFile, keyFile, clientCert := writeClientCert(t)
+
+	var mu sync.Mutex
+	var paths []string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		if r.URL.Path == "/upload" {
+			w.Write([]byte(`{"profile_url":"https://storage.pprofio.com/p1.pprof"}`))
+		}
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(server.Certificate())
+
+	config := Config{
+		APIKey:          "test-key",
+		IngestURL:       server.URL,
+		SampleRate:      time.Hour,
+		ServiceName:     "test-service",
+		EnableGoroutine: true,
+		TLSConfig:       &tls.Config{RootCAs: rootCAs, MinVersion: tls.VersionTLS12},
+	}
+
+	// Without a client certificate the gateway rejects the handshake
+	p, err := New(config)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if results := p.Flush(context.Background(), ProfileGoroutine); results[0].Err == nil {
+		t.Fatal("Flush() without a client certificate should fail")
+	}
+
+	config.ClientCertFile = certFile
+	config.ClientKeyFile = keyFile
+	p, err = New(config)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if len(config.TLSConfig.Certificates) != 0 {
+		t.Error("New() should not modify the caller's TLSConfig")
+	}
+	if results := p.Flush(context.Background(), ProfileGoroutine); results[0].Err != nil {
+		t.Fatalf("Flush() with a client certificate error = %v", results[0].Err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(paths) != 2 || paths[0] != "/upload" || paths[1] != "/metadata" {
+		t.Errorf("Server received %v, want the upload and its metadata over mutual TLS", paths)
+	}
+}
+
+func TestClientCertFileMissing(t *testing.T) {
+	_, err := New(Config{
+		APIKey:         "test-key",
+		IngestURL:      "https://api.pprofio.com",
+		ServiceName:    "test-service",
+		ClientCertFile: filepath.Join(t.TempDir(), "missing.crt"),
+		ClientKeyFile:  filepath.Join(t.TempDir(), "missing.key"),
+	})
+	if err == nil {
+		t.Error("New() with a missing client certificate should return error")
+	}
+}
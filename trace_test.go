@@ -0,0 +1,73 @@
+package pprofio
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCollectTrace(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	storage := &captureStorage{}
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       server.URL,
+		ProfileDuration: 20 * time.Millisecond,
+		Storage:         storage,
+		ServiceName:     "test-service",
+		EnableTrace:     true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if p.config.EnableCPU || p.config.EnableMemory {
+		t.Error("EnableTrace alone should not enable the default profile types")
+	}
+	if p.interval(ProfileTrace) != DefaultTraceInterval {
+		t.Errorf("trace interval = %v, want %v", p.interval(ProfileTrace), DefaultTraceInterval)
+	}
+
+	// Traces are only flushed on request
+	if results := p.Flush(context.Background()); len(results) != 0 {
+		t.Errorf("Flush() collected %d profiles, want none", len(results))
+	}
+
+	results := p.Flush(context.Background(), ProfileTrace)
+	if len(results) != 1 || results[0].Err != nil {
+		t.Fatalf("Flush(ProfileTrace) = %+v", results)
+	}
+	if results[0].Type != ProfileTrace {
+		t.Errorf("result type = %q, want %q", results[0].Type, ProfileTrace)
+	}
+
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	if len(storage.uploads) != 1 {
+		t.Fatalf("Expected 1 upload, got %d", len(storage.uploads))
+	}
+	if !bytes.HasPrefix(storage.uploads[0], []byte("go 1.")) {
+		t.Errorf("Upload is not an execution trace: %q", storage.uploads[0][:16])
+	}
+}
+
+func TestTraceIntervalValidation(t *testing.T) {
+	cfg := Config{
+		APIKey:        "test-key",
+		IngestURL:     "https://api.pprofio.com",
+		Storage:       &captureStorage{},
+		ServiceName:   "test-service",
+		EnableTrace:   true,
+		TraceInterval: 10 * time.Second,
+	}
+	if err := cfg.validate(); err == nil {
+		t.Errorf("validate() with TraceInterval below %v should return error", MinTraceInterval)
+	}
+}
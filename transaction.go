@@ -0,0 +1,64 @@
+package pprofio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Ingest API paths for transactional uploads
+const (
+	reservePath  = "/profiles/reserve"
+	completePath = "/profiles/complete"
+	abortPath    = "/profiles/abort"
+)
+
+// reservation is the ingest API's answer to a reserve request
+type reservation struct {
+	ProfileID string `json:"profile_id"`
+}
+
+// uploadTransaction reserves a profile slot, uploads the profile keyed by the
+// reserved ID and marks the slot complete. If the upload or completion fails,
+// the reservation is aborted so no half-registered profile remains.
+func (p *Profiler) uploadTransaction(ctx context.Context, profile collectedProfile, result CollectionResult) (CollectionResult, error) {
+	client := p.newIngestClient()
+
+	reserve := p.profileMetadata(ctx, result.Type, UploadResult{Type: string(result.Type)})
+	delete(reserve, "profile_url")
+
+	var res reservation
+	if err := client.postJSON(ctx, reservePath, reserve, &res); err != nil {
+		return result, &stageError{stage: StageMetadata, err: fmt.Errorf("failed to reserve profile: %w", err)}
+	}
+	if res.ProfileID == "" {
+		return result, &stageError{stage: StageMetadata, err: errors.New("failed to reserve profile: no profile_id in response")}
+	}
+
+	response, err := p.storeProfile(ctx, profile, result.Type, res.ProfileID)
+	result.Attempts = response.Attempts
+	if err != nil {
+		p.abortReservation(ctx, client, res.ProfileID)
+		return result, err
+	}
+	response.ProfileID = res.ProfileID
+	result.URL = response.ProfileURL
+	result.ProfileID = response.ProfileID
+
+	if err := client.post(ctx, completePath, p.profileMetadata(ctx, result.Type, response)); err != nil {
+		p.abortReservation(ctx, client, res.ProfileID)
+		return result, &stageError{stage: StageMetadata, err: fmt.Errorf("failed to complete profile: %w", err)}
+	}
+	p.logCorrelation(ctx, "metadata sent", response.ProfileURL)
+
+	return result, nil
+}
+
+// abortReservation asks the ingest API to discard a reserved profile and
+// anything uploaded for it. Failures are only logged; the backend expires
+// reservations that are never completed.
+func (p *Profiler) abortReservation(ctx context.Context, client *metadataClient, id string) {
+	if err := client.post(ctx, abortPath, map[string]string{"profile_id": id}); err != nil {
+		p.config.Logger.Errorf("Error aborting reserved profile %s: %v", id, err)
+	}
+}
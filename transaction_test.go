@@ -0,0 +1,189 @@
+package pprofio
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// transactionServer is a fake ingest API tracking each reserved profile
+type transactionServer struct {
+	mu           sync.Mutex
+	next         int
+	states       map[string]string
+	failReserve  bool
+	failComplete bool
+}
+
+func (s *transactionServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body map[string]string
+	_ = json.NewDecoder(r.Body).Decode(&body)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.URL.Path {
+	case reservePath:
+		if s.failReserve {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		s.next++
+		id := fmt.Sprintf("prof-%d", s.next)
+		s.states[id] = "reserved"
+		_ = json.NewEncoder(w).Encode(reservation{ProfileID: id})
+	case completePath:
+		if s.failComplete {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		s.states[body["profile_id"]] = "completed"
+	case abortPath:
+		s.states[body["profile_id"]] = "aborted"
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *transactionServer) state(id string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.states[id]
+}
+
+func (s *transactionServer) unfinished() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for id, state := range s.states {
+		if state == "reserved" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// reservedIDStorage records the reserved ID each upload was keyed by
+type reservedIDStorage struct {
+	mu   sync.Mutex
+	ids  []string
+	fail bool
+}
+
+func (s *reservedIDStorage) Upload(ctx context.Context, filePath string) (UploadResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, _ := ProfileIDFromUploadContext(ctx)
+	s.ids = append(s.ids, id)
+	if s.fail {
+		return UploadResult{}, errors.New("bucket unavailable")
+	}
+	return UploadResult{ProfileURL: "https://blobs.example.com/" + id}, nil
+}
+
+func TestTransactionalUploads(t *testing.T) {
+	tests := []struct {
+		name         string
+		failReserve  bool
+		failUpload   bool
+		failComplete bool
+		wantStage    string
+		wantUploads  int
+		wantState    string
+	}{
+		{name: "success", wantUploads: 1, wantState: "completed"},
+		{name: "reserve fails", failReserve: true, wantStage: StageMetadata},
+		{name: "upload fails", failUpload: true, wantStage: StageUpload, wantUploads: 1, wantState: "aborted"},
+		{name: "complete fails", failComplete: true, wantStage: StageMetadata, wantUploads: 1, wantState: "aborted"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &transactionServer{
+				states:       make(map[string]string),
+				failReserve:  tt.failReserve,
+				failComplete: tt.failComplete,
+			}
+			ts := httptest.NewServer(server)
+			defer ts.Close()
+
+			storage := &reservedIDStorage{fail: tt.failUpload}
+			p, err := New(Config{
+				APIKey:               "test-key",
+				IngestURL:            ts.URL,
+				Storage:              storage,
+				ServiceName:          "test-service",
+				EnableGoroutine:      true,
+				TransactionalUploads: true,
+			})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			filePath := filepath.Join(t.TempDir(), "goroutine.pprof")
+			if err := os.WriteFile(filePath, []byte("profile"), 0600); err != nil {
+				t.Fatalf("WriteFile() error = %v", err)
+			}
+
+			result, err := p.uploadProfile(context.Background(), filePath, "goroutine")
+
+			var se *stageError
+			switch {
+			case tt.wantStage == "" && err != nil:
+				t.Fatalf("uploadProfile() error = %v", err)
+			case tt.wantStage != "" && (!errors.As(err, &se) || se.stage != tt.wantStage):
+				t.Fatalf("uploadProfile() error = %v, want stage %q", err, tt.wantStage)
+			}
+
+			if len(storage.ids) != tt.wantUploads {
+				t.Fatalf("uploads = %d, want %d", len(storage.ids), tt.wantUploads)
+			}
+			if tt.wantUploads > 0 {
+				id := storage.ids[0]
+				if id == "" {
+					t.Fatal("upload was not keyed by a reserved profile ID")
+				}
+				if got := server.state(id); got != tt.wantState {
+					t.Errorf("state of %s = %q, want %q", id, got, tt.wantState)
+				}
+				if tt.wantStage == "" && result.ProfileID != id {
+					t.Errorf("ProfileID = %q, want %q", result.ProfileID, id)
+				}
+			}
+			if ids := server.unfinished(); len(ids) != 0 {
+				t.Errorf("reservations left unfinished: %v", ids)
+			}
+		})
+	}
+}
+
+func TestHTTPStorageKeysUploadByReservedID(t *testing.T) {
+	var gotPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	filePath := filepath.Join(t.TempDir(), "cpu.pprof")
+	if err := os.WriteFile(filePath, []byte("profile"), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	storage := NewHTTPStorage(server.URL+"/upload/", "test-key", "local")
+	ctx := withReservedProfileID(context.Background(), "prof 1")
+	if _, err := storage.Upload(ctx, filePath); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	if gotPath != "/upload/prof 1" {
+		t.Errorf("path = %q, want %q", gotPath, "/upload/prof 1")
+	}
+}
@@ -0,0 +1,99 @@
+package pprofio
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// newTransport returns a transport with the default settings, the given TLS
+// configuration if non-nil, and proxy if non-nil. Without a proxy, requests
+// go through the proxy named by HTTP_PROXY, HTTPS_PROXY and NO_PROXY.
+func newTransport(config *tls.Config, proxy *url.URL) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config != nil {
+		transport.TLSClientConfig = config.Clone()
+	}
+	transport.Proxy = http.ProxyFromEnvironment
+	if proxy != nil {
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	return transport
+}
+
+// parseProxyURL parses a ProxyURL option, returning nil for an empty one.
+// HTTP, HTTPS and SOCKS5 proxies are supported.
+func parseProxyURL(rawURL string) (*url.URL, error) {
+	if rawURL == "" {
+		return nil, nil
+	}
+
+	proxy, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	switch proxy.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q: want http, https or socks5", proxy.Scheme)
+	}
+	if proxy.Host == "" {
+		return nil, fmt.Errorf("proxy URL %q has no host", rawURL)
+	}
+	return proxy, nil
+}
+
+// ingestHTTPClient returns the client for metadata requests built from the
+// config's HTTP options, or nil when none are set and the metadata client's
+// default applies.
+func ingestHTTPClient(config Config) *http.Client {
+	if config.HTTPClient == nil && config.HTTPTimeout == 0 && config.TLSConfig == nil && config.ProxyURL == "" {
+		return nil
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	if config.HTTPClient != nil {
+		*client = *config.HTTPClient
+	} else if config.HTTPTimeout > 0 {
+		client.Timeout = config.HTTPTimeout
+	}
+
+	if client.Transport == nil && (config.TLSConfig != nil || config.ProxyURL != "") {
+		// ProxyURL was checked by validate
+		proxy, _ := parseProxyURL(config.ProxyURL)
+		client.Transport = newTransport(config.TLSConfig, proxy)
+	} else if config.HTTPClient != nil {
+		// Share the caller's client, and so its connection pool
+		return config.HTTPClient
+	}
+	return client
+}
+
+// httpClient returns the client used for uploads: Client, with a transport
+// built from TLSConfig and ProxyURL if Client has none.
+func (s *HTTPStorage) httpClient() (*http.Client, error) {
+	if (s.TLSConfig == nil && s.ProxyURL == "") || (s.Client != nil && s.Client.Transport != nil) {
+		if s.Client == nil {
+			return http.DefaultClient, nil
+		}
+		return s.Client, nil
+	}
+
+	s.clientOnce.Do(func() {
+		proxy, err := parseProxyURL(s.ProxyURL)
+		if err != nil {
+			s.clientErr = err
+			return
+		}
+
+		client := &http.Client{}
+		if s.Client != nil {
+			*client = *s.Client
+		}
+		client.Transport = newTransport(s.TLSConfig, proxy)
+		s.transportClient = client
+	})
+	return s.transportClient, s.clientErr
+}
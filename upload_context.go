@@ -0,0 +1,195 @@
+package pprofio
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+type uploadTagsKey struct{}
+
+type uploadTypeKey struct{}
+
+type uploadServiceKey struct{}
+
+type uploadExtensionKey struct{}
+
+type triggerKey struct{}
+
+type extraMetadataKey struct{}
+
+type reservedProfileIDKey struct{}
+
+type correlationIDKey struct{}
+
+type spanIDsKey struct{}
+
+// CorrelationIDHeader carries the ID shared by a profile's upload and
+// metadata requests, so both can be traced in server logs
+const CorrelationIDHeader = "X-Pprofio-Correlation-ID"
+
+// Triggers recorded in profile metadata, identifying what initiated collection
+const (
+	triggerScheduled = "scheduled"
+	triggerManual    = "manual"
+	triggerSignal    = "signal"
+)
+
+// withTrigger records what initiated a collection, keeping any trigger
+// already set by an outer caller (e.g. a signal handler calling Flush).
+func withTrigger(ctx context.Context, trigger string) context.Context {
+	if _, ok := ctx.Value(triggerKey{}).(string); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, triggerKey{}, trigger)
+}
+
+// triggerFromContext returns the collection trigger, or "" if none was set.
+func triggerFromContext(ctx context.Context) string {
+	trigger, _ := ctx.Value(triggerKey{}).(string)
+	return trigger
+}
+
+// defaultRetentionByTrigger tags profiles collected on demand, e.g. during an
+// incident, for longer retention than the routine schedule
+var defaultRetentionByTrigger = map[string]string{
+	triggerScheduled: RetentionDefault,
+	triggerManual:    RetentionIncident,
+	triggerSignal:    RetentionIncident,
+}
+
+// retention returns the retention tag for profiles collected by trigger,
+// preferring Config.RetentionByTrigger over the built-in classes.
+func (p *Profiler) retention(trigger string) string {
+	if r, ok := p.config.RetentionByTrigger[trigger]; ok {
+		return r
+	}
+	if r, ok := defaultRetentionByTrigger[trigger]; ok {
+		return r
+	}
+	return RetentionDefault
+}
+
+// withExtraMetadata attaches annotations that uploadProfile adds to the
+// metadata of the profile collected under ctx.
+func withExtraMetadata(ctx context.Context, metadata map[string]string) context.Context {
+	return context.WithValue(ctx, extraMetadataKey{}, metadata)
+}
+
+// extraMetadataFromContext returns annotations set by withExtraMetadata.
+func extraMetadataFromContext(ctx context.Context) map[string]string {
+	metadata, _ := ctx.Value(extraMetadataKey{}).(map[string]string)
+	return metadata
+}
+
+// withSpanIDs attaches the IDs of the spans active when the profile
+// collected under ctx began.
+func withSpanIDs(ctx context.Context, ids []string) context.Context {
+	return context.WithValue(ctx, spanIDsKey{}, ids)
+}
+
+// spanIDsFromContext returns the span IDs set by withSpanIDs.
+func spanIDsFromContext(ctx context.Context) []string {
+	ids, _ := ctx.Value(spanIDsKey{}).([]string)
+	return ids
+}
+
+// newCorrelationID returns a random ID linking a profile's upload and
+// metadata requests
+func newCorrelationID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// withCorrelationID attaches the correlation ID of the profile uploaded
+// under ctx.
+func withCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey{}, id)
+}
+
+// CorrelationIDFromUploadContext returns the ID shared by the upload and
+// metadata requests of the profile being uploaded, for custom Storage
+// implementations that want to send it along as CorrelationIDHeader.
+func CorrelationIDFromUploadContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(correlationIDKey{}).(string)
+	return id, ok && id != ""
+}
+
+// withUploadContext attaches the service name and the profile's tags and
+// type to the context passed to Storage.Upload.
+func withUploadContext(ctx context.Context, serviceName string, tags map[string]string, profileType ProfileType) context.Context {
+	copied := make(map[string]string, len(tags))
+	for k, v := range tags {
+		copied[k] = v
+	}
+
+	ctx = context.WithValue(ctx, uploadServiceKey{}, serviceName)
+	ctx = context.WithValue(ctx, uploadTagsKey{}, copied)
+	return context.WithValue(ctx, uploadTypeKey{}, profileType)
+}
+
+// ServiceNameFromUploadContext returns the service name of the profiler
+// uploading the profile, for use by custom Storage implementations inside
+// Upload.
+func ServiceNameFromUploadContext(ctx context.Context) (string, bool) {
+	name, ok := ctx.Value(uploadServiceKey{}).(string)
+	return name, ok
+}
+
+// TagsFromUploadContext returns the tags of the profile being uploaded, for
+// use by custom Storage implementations inside Upload. The returned map is a
+// copy and may be modified.
+func TagsFromUploadContext(ctx context.Context) (map[string]string, bool) {
+	tags, ok := ctx.Value(uploadTagsKey{}).(map[string]string)
+	return tags, ok
+}
+
+// ProfileTypeFromUploadContext returns the type of the profile being uploaded,
+// for use by custom Storage implementations inside Upload.
+func ProfileTypeFromUploadContext(ctx context.Context) (ProfileType, bool) {
+	profileType, ok := ctx.Value(uploadTypeKey{}).(ProfileType)
+	return profileType, ok
+}
+
+// UploadProfileType returns the type of the profile at filePath being
+// uploaded under ctx: the type from the upload context when set, otherwise
+// the type inferred from the collected file's name, or "unknown". Storage
+// implementations use it to label profiles consistently.
+func UploadProfileType(ctx context.Context, filePath string) ProfileType {
+	if t, ok := ProfileTypeFromUploadContext(ctx); ok {
+		return t
+	}
+	return ProfileType(profileTypeFromPath(filePath))
+}
+
+// withUploadExtension attaches the file extension configured for the
+// profile's type, so Storage can name the profile without guessing it from
+// the collected file's name.
+func withUploadExtension(ctx context.Context, ext string) context.Context {
+	return context.WithValue(ctx, uploadExtensionKey{}, ext)
+}
+
+// ExtensionFromUploadContext returns the file extension configured for the
+// profile being uploaded, such as ".pprof" or its FileExtensions suffix, for
+// custom Storage implementations that name stored files.
+func ExtensionFromUploadContext(ctx context.Context) (string, bool) {
+	ext, ok := ctx.Value(uploadExtensionKey{}).(string)
+	return ext, ok && ext != ""
+}
+
+// withReservedProfileID attaches the ID reserved for the profile by a
+// transactional upload.
+func withReservedProfileID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, reservedProfileIDKey{}, id)
+}
+
+// ProfileIDFromUploadContext returns the profile ID reserved with the ingest
+// API when TransactionalUploads is enabled. Storage implementations should
+// key the upload by it.
+func ProfileIDFromUploadContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(reservedProfileIDKey{}).(string)
+	return id, ok
+}
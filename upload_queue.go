@@ -0,0 +1,165 @@
+package pprofio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// queuedUpload is a scheduled profile waiting in the upload queue
+type queuedUpload struct {
+	ctx         context.Context
+	profile     collectedProfile
+	profileType ProfileType
+
+	// start is when the profile's collection began, reported in its event
+	start time.Time
+}
+
+type queuedUploadKey struct{}
+
+type collectionStartKey struct{}
+
+// errUploadDropped is the error reported for a queued profile dropped to make
+// room for a newer one
+var errUploadDropped = errors.New("dropped from full upload queue")
+
+// withQueuedUpload marks a scheduled collection whose upload goes through
+// the upload queue.
+func withQueuedUpload(ctx context.Context, queued bool) context.Context {
+	return context.WithValue(ctx, queuedUploadKey{}, queued)
+}
+
+// queuedUploadFromContext reports whether withQueuedUpload marked ctx.
+func queuedUploadFromContext(ctx context.Context) bool {
+	queued, _ := ctx.Value(queuedUploadKey{}).(bool)
+	return queued
+}
+
+// withCollectionStart records when the collection under ctx began, so a
+// queued upload can report it once it completes.
+func withCollectionStart(ctx context.Context, start time.Time) context.Context {
+	return context.WithValue(ctx, collectionStartKey{}, start)
+}
+
+// collectionStartFromContext returns the time set by withCollectionStart,
+// or now if none was set.
+func collectionStartFromContext(ctx context.Context) time.Time {
+	if start, ok := ctx.Value(collectionStartKey{}).(time.Time); ok {
+		return start
+	}
+	return time.Now()
+}
+
+// uploadQueueWorkers returns how many goroutines upload queued profiles:
+// UploadWorkers, or one when it is unset.
+func (p *Profiler) uploadQueueWorkers() int {
+	if p.config.UploadWorkers > 0 {
+		return p.config.UploadWorkers
+	}
+	return 1
+}
+
+// enqueueUpload hands a collected profile to the upload queue and returns
+// at once, so slow uploads do not delay the next collection. The profile is
+// copied into memory, since its temp file is removed when the collector
+// returns. When the queue is full the oldest queued profile is dropped.
+func (p *Profiler) enqueueUpload(ctx context.Context, profile collectedProfile, profileType ProfileType) (CollectionResult, error) {
+	result := CollectionResult{Type: profileType, SizeBytes: profile.size()}
+
+	data, err := profile.bytes()
+	if err != nil {
+		return result, &stageError{stage: StageUpload, err: fmt.Errorf("failed to read profile: %w", err)}
+	}
+	job := queuedUpload{
+		ctx:         withQueuedUpload(ctx, false),
+		profile:     collectedProfile{name: p.profileFileName(profileType), data: data},
+		profileType: profileType,
+		start:       collectionStartFromContext(ctx),
+	}
+
+	// Drain waits for queued uploads as well as running ones
+	p.beginUpload()
+
+	p.queueMu.Lock()
+	defer p.queueMu.Unlock()
+	for {
+		select {
+		case p.uploadQueue <- job:
+			return result, nil
+		default:
+		}
+
+		select {
+		case dropped := <-p.uploadQueue:
+			p.recordQueueDrop(dropped.profileType)
+			p.deltaLost(dropped.ctx, dropped.profileType)
+			p.emitQueuedEvent(dropped, CollectionResult{SizeBytes: dropped.profile.size()},
+				&stageError{stage: StageUpload, err: errUploadDropped})
+			p.endUpload()
+		default:
+		}
+	}
+}
+
+// processUploadQueue uploads queued profiles until the profiler stops. Stop
+// uploads whatever is still queued once collection has ended.
+func (p *Profiler) processUploadQueue(ctx context.Context) {
+	defer p.wg.Done()
+
+	for {
+		select {
+		case job := <-p.uploadQueue:
+			p.runQueuedUpload(job)
+		case <-p.stopCh:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// drainUploadQueue uploads every profile left in the queue.
+func (p *Profiler) drainUploadQueue() {
+	for {
+		select {
+		case job := <-p.uploadQueue:
+			p.runQueuedUpload(job)
+		default:
+			return
+		}
+	}
+}
+
+// runQueuedUpload uploads one queued profile, logging any failure since no
+// caller is waiting for the result, and emits the collection's event.
+func (p *Profiler) runQueuedUpload(job queuedUpload) {
+	defer p.endUpload()
+
+	result, err := p.uploadCollected(job.ctx, job.profile, job.profileType)
+	p.recordError(err)
+	if err != nil {
+		p.logCollectionError(job.profileType, err)
+	}
+	p.emitQueuedEvent(job, result, err)
+}
+
+// emitQueuedEvent publishes the event of a queued collection once its upload
+// has finished or been dropped. Its Duration spans collection, queueing and
+// upload.
+func (p *Profiler) emitQueuedEvent(job queuedUpload, result CollectionResult, err error) {
+	result.Type = job.profileType
+	result.Err = err
+	result.Duration = time.Since(job.start)
+	p.emitEvent(result, job.start)
+}
+
+// recordQueueDrop counts a queued profile dropped to make room for a newer one
+func (p *Profiler) recordQueueDrop(profileType ProfileType) {
+	p.statsMu.Lock()
+	p.stats.UploadQueueDrops++
+	p.statsMu.Unlock()
+
+	p.config.Logger.Errorf("Upload queue full; dropped queued %s profile", profileType)
+}
@@ -0,0 +1,146 @@
+package pprofio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// queuedStorage returns a slowStorage that never blocks on started
+func queuedStorage(delay time.Duration) *slowStorage {
+	return &slowStorage{delay: delay, started: make(chan struct{}, 1000)}
+}
+
+// uploads returns how many uploads s has completed
+func (s *slowStorage) uploads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.completed
+}
+
+// gatedStorage blocks every upload until release is closed.
+type gatedStorage struct {
+	release chan struct{}
+
+	mu      sync.Mutex
+	uploads int
+}
+
+func (s *gatedStorage) Upload(ctx context.Context, filePath string) (UploadResult, error) {
+	<-s.release
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uploads++
+	return UploadResult{ProfileURL: fmt.Sprintf("https://storage.pprofio.com/%d.pprof", s.uploads)}, nil
+}
+
+func (s *gatedStorage) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.uploads
+}
+
+func TestUploadQueueKeepsCadence(t *testing.T) {
+	ingest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ingest.Close()
+
+	storage := &gatedStorage{release: make(chan struct{})}
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       ingest.URL,
+		SampleRate:      10 * time.Millisecond,
+		Storage:         storage,
+		ServiceName:     "test-service",
+		EnableGoroutine: true,
+		UploadQueueSize: 100,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	events := p.Events()
+
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	// Collection continues while the first upload is blocked, so profiles
+	// pile up in the queue behind it
+	waitFor(t, func() bool { return len(p.uploadQueue) >= 3 })
+	if len(events) != 0 {
+		t.Errorf("received %d events before any upload finished, want events after upload", len(events))
+	}
+
+	close(storage.release)
+	p.Stop()
+
+	var collected int
+	for len(events) > 0 {
+		event := <-events
+		collected++
+		if event.Err != nil || event.URL == "" {
+			t.Errorf("queued upload event URL = %q, err = %v; want the uploaded URL", event.URL, event.Err)
+		}
+	}
+	if got := storage.count(); got != collected {
+		t.Errorf("Stop() left the queue with %d of %d profiles uploaded", got, collected)
+	}
+	if got := p.Stats().UploadQueueDrops; got != 0 {
+		t.Errorf("UploadQueueDrops = %d, want 0", got)
+	}
+}
+
+func TestUploadQueueDropsOldest(t *testing.T) {
+	ingest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ingest.Close()
+
+	storage := queuedStorage(50 * time.Millisecond)
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       ingest.URL,
+		Storage:         storage,
+		ServiceName:     "test-service",
+		EnableGoroutine: true,
+		UploadQueueSize: 1,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	// No workers run before Start, so every profile past the first queued
+	// one displaces its predecessor
+	ctx := withQueuedUpload(withTrigger(context.Background(), triggerScheduled), true)
+	for i := 0; i < 3; i++ {
+		if _, err := p.collectProfile(ctx, ProfileGoroutine); err != nil {
+			t.Fatalf("collectProfile() error = %v", err)
+		}
+	}
+
+	if got := p.Stats().UploadQueueDrops; got != 2 {
+		t.Errorf("UploadQueueDrops = %d, want 2", got)
+	}
+
+	p.drainUploadQueue()
+	if got := storage.uploads(); got != 1 {
+		t.Errorf("uploads = %d, want only the newest queued profile", got)
+	}
+
+	// Each dropped profile reports the drop, and the uploaded one its result
+	events := p.Events()
+	if len(events) != 3 {
+		t.Fatalf("received %d events, want one per collection", len(events))
+	}
+	for i := 0; i < 2; i++ {
+		if event := <-events; !errors.Is(event.Err, errUploadDropped) {
+			t.Errorf("event %d err = %v, want %v", i+1, event.Err, errUploadDropped)
+		}
+	}
+	if event := <-events; event.Err != nil {
+		t.Errorf("uploaded profile event err = %v", event.Err)
+	}
+}
@@ -0,0 +1,58 @@
+package pprofio
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// parseUploadResponse extracts the profile URL, ID and type from the body of
+// a successful upload. A JSON object is decoded by its "profile_url",
+// "profile_id" and "type" fields; any other body is taken as the profile URL
+// for endpoints that answer in plain text. It returns an error for an empty
+// body, malformed JSON and text that is not valid UTF-8.
+func parseUploadResponse(body []byte) (url, id, typ string, err error) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return "", "", "", errors.New("empty upload response")
+	}
+
+	if body[0] != '{' {
+		if !utf8.Valid(body) {
+			return "", "", "", errors.New("upload response is not valid UTF-8")
+		}
+		return string(body), "", "", nil
+	}
+
+	var response struct {
+		ProfileURL string `json:"profile_url"`
+		ProfileID  string `json:"profile_id"`
+		Type       string `json:"type"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", "", "", fmt.Errorf("invalid upload response: %w", err)
+	}
+	return response.ProfileURL, response.ProfileID, response.Type, nil
+}
+
+// decodeUploadResponse builds the UploadResult for an upload's response
+// body, including the "need_full" flag of JSON answers. A body that cannot
+// be parsed leaves the result empty rather than failing an upload the
+// server accepted.
+func decodeUploadResponse(body string) UploadResult {
+	url, id, typ, err := parseUploadResponse([]byte(body))
+	if err != nil {
+		return UploadResult{}
+	}
+	result := UploadResult{ProfileURL: url, ProfileID: id, Type: typ}
+
+	var flags struct {
+		NeedFull bool `json:"need_full"`
+	}
+	if json.Unmarshal([]byte(body), &flags) == nil {
+		result.NeedFull = flags.NeedFull
+	}
+	return result
+}
@@ -0,0 +1,81 @@
+package pprofio
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestParseUploadResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		url     string
+		id      string
+		typ     string
+		wantErr bool
+	}{
+		{
+			name: "json",
+			body: `{"profile_url":"https://storage.pprofio.com/p1.pprof","profile_id":"p1","type":"cpu"}`,
+			url:  "https://storage.pprofio.com/p1.pprof",
+			id:   "p1",
+			typ:  "cpu",
+		},
+		{name: "plain text", body: "https://storage.pprofio.com/p2.pprof\n", url: "https://storage.pprofio.com/p2.pprof"},
+		{name: "json without fields", body: `{"status":"ok"}`},
+		{name: "empty", body: "", wantErr: true},
+		{name: "whitespace", body: " \r\n\t", wantErr: true},
+		{name: "truncated json", body: `{"profile_url":"https://`, wantErr: true},
+		{name: "wrong field type", body: `{"profile_url":42}`, wantErr: true},
+		{name: "non-UTF-8", body: "\xff\xfe\xfd", wantErr: true},
+		{name: "deeply nested", body: `{"a":` + strings.Repeat("[", 100000) + strings.Repeat("]", 100000) + "}", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, id, typ, err := parseUploadResponse([]byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseUploadResponse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if url != tt.url || id != tt.id || typ != tt.typ {
+				t.Errorf("parseUploadResponse() = (%q, %q, %q), want (%q, %q, %q)", url, id, typ, tt.url, tt.id, tt.typ)
+			}
+		})
+	}
+}
+
+func FuzzParseUploadResponse(f *testing.F) {
+	f.Add([]byte(`{"profile_url":"https://storage.pprofio.com/p1.pprof","profile_id":"p1","type":"cpu","need_full":true}`))
+	f.Add([]byte("https://storage.pprofio.com/p2.pprof\n"))
+	f.Add([]byte(""))
+	f.Add([]byte("\xff\xfe"))
+	f.Add([]byte(`{"profile_url":"\ud800"}`))
+	f.Add([]byte(`{"profile_url":{"nested":[[[[{}]]]]}}`))
+	f.Add([]byte(`{"a":` + strings.Repeat("[", 20000) + strings.Repeat("]", 20000) + "}"))
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		url, id, typ, err := parseUploadResponse(body)
+		decodeUploadResponse(string(body))
+		if err != nil {
+			return
+		}
+		if !utf8.ValidString(url) || !utf8.ValidString(id) || !utf8.ValidString(typ) {
+			t.Fatalf("parseUploadResponse(%q) returned invalid UTF-8: (%q, %q, %q)", body, url, id, typ)
+		}
+
+		// Parsed fields survive a round trip through a JSON answer
+		encoded, err := json.Marshal(map[string]string{"profile_url": url, "profile_id": id, "type": typ})
+		if err != nil {
+			t.Fatalf("json.Marshal() error = %v", err)
+		}
+		gotURL, gotID, gotType, err := parseUploadResponse(encoded)
+		if err != nil {
+			t.Fatalf("parseUploadResponse(%s) error = %v", encoded, err)
+		}
+		if gotURL != url || gotID != id || gotType != typ {
+			t.Errorf("round trip of %s = (%q, %q, %q), want (%q, %q, %q)", encoded, gotURL, gotID, gotType, url, id, typ)
+		}
+	})
+}
@@ -0,0 +1,61 @@
+package pprofio
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// uploadToStorage uploads a profile through Storage once one of the
+// UploadWorkers slots is free, so at most UploadWorkers profiles are
+// compressed and sent at a time. Profiles held in memory are streamed, or
+// written to a temp file for storages that only accept files.
+func (p *Profiler) uploadToStorage(ctx context.Context, profile collectedProfile, profileType ProfileType) (UploadResult, error) {
+	if p.uploadSlots != nil {
+		select {
+		case p.uploadSlots <- struct{}{}:
+			defer func() { <-p.uploadSlots }()
+		case <-ctx.Done():
+			return UploadResult{}, ctx.Err()
+		}
+	}
+	if profile.path != "" {
+		return p.config.Storage.Upload(ctx, profile.path)
+	}
+	if stream, ok := p.config.Storage.(StreamStorage); ok {
+		return stream.UploadStream(ctx, bytes.NewReader(profile.data), profile.name)
+	}
+
+	f, err := p.createTempFile(profileType)
+	if err != nil {
+		return UploadResult{}, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(profile.data); err != nil {
+		f.Close()
+		return UploadResult{}, fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return UploadResult{}, fmt.Errorf("failed to write temp file: %w", err)
+	}
+	return p.config.Storage.Upload(ctx, f.Name())
+}
+
+// collectConcurrently collects the given types at once, returning their
+// results in the order given. Uploads remain bounded by UploadWorkers.
+func (p *Profiler) collectConcurrently(ctx context.Context, types []profileType) []CollectionResult {
+	results := make([]CollectionResult, len(types))
+
+	var wg sync.WaitGroup
+	for i, t := range types {
+		wg.Add(1)
+		go func(i int, t profileType) {
+			defer wg.Done()
+			results[i] = p.collectResult(ctx, t)
+		}(i, t)
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,83 @@
+package pprofio
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// concurrencyStorage records how many uploads run at once
+type concurrencyStorage struct {
+	mu      sync.Mutex
+	active  int
+	maxSeen int
+	done    int
+}
+
+func (s *concurrencyStorage) Upload(ctx context.Context, filePath string) (UploadResult, error) {
+	s.mu.Lock()
+	s.active++
+	if s.active > s.maxSeen {
+		s.maxSeen = s.active
+	}
+	s.mu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	s.mu.Lock()
+	s.active--
+	s.done++
+	s.mu.Unlock()
+	return UploadResult{ProfileURL: filePath}, nil
+}
+
+func TestUploadWorkers(t *testing.T) {
+	const workers, profiles = 3, 24
+
+	storage := &concurrencyStorage{}
+	p, err := New(Config{
+		APIKey:          "test-key",
+		IngestURL:       "https://api.pprofio.com",
+		Storage:         storage,
+		ServiceName:     "test-service",
+		EnableGoroutine: true,
+		UploadWorkers:   workers,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "goroutine.pprof")
+	if err := os.WriteFile(path, []byte("profile"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, profiles)
+	for i := 0; i < profiles; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := p.storeProfile(context.Background(), profileFile(path), ProfileGoroutine, ""); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("storeProfile() error = %v", err)
+	}
+
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	if storage.done != profiles {
+		t.Errorf("uploads completed = %d, want %d", storage.done, profiles)
+	}
+	if storage.maxSeen != workers {
+		t.Errorf("max concurrent uploads = %d, want %d", storage.maxSeen, workers)
+	}
+}
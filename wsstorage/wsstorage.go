@@ -0,0 +1,204 @@
+// Package wsstorage provides a pprofio Storage that streams profiles to a
+// realtime backend over a long-lived WebSocket connection.
+//
+// Each profile is sent as one binary message: a 4-byte big-endian header
+// length, a JSON Header, then the gzip-compressed profile. The connection is
+// dialed on first use and redialed after the server drops it.
+package wsstorage
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/pprofio/pprofio"
+)
+
+// defaultWriteTimeout bounds a send when the upload context has no deadline
+const defaultWriteTimeout = 30 * time.Second
+
+// Header describes the profile that follows it in a message
+type Header struct {
+	Type      string            `json:"type"`
+	Encoding  string            `json:"encoding"`
+	Size      int               `json:"size"`
+	Timestamp int64             `json:"timestamp"`
+	Tags      map[string]string `json:"tags,omitempty"`
+}
+
+// Storage sends profiles over a WebSocket connection it keeps open
+type Storage struct {
+	url    string
+	header http.Header
+	dialer *websocket.Dialer
+
+	mu   sync.Mutex
+	conn *websocket.Conn
+}
+
+// Option configures Storage.
+type Option func(*Storage)
+
+// WithHeader sets HTTP headers sent with the WebSocket handshake, such as
+// Authorization.
+func WithHeader(header http.Header) Option {
+	return func(s *Storage) {
+		s.header = header
+	}
+}
+
+// WithDialer replaces the default dialer, e.g. to configure TLS or proxies.
+func WithDialer(dialer *websocket.Dialer) Option {
+	return func(s *Storage) {
+		s.dialer = dialer
+	}
+}
+
+// New returns a Storage sending to the ws:// or wss:// URL. The connection
+// is dialed on the first upload.
+func New(url string, opts ...Option) (*Storage, error) {
+	if !strings.HasPrefix(url, "ws://") && !strings.HasPrefix(url, "wss://") {
+		return nil, errors.New("URL must use the ws or wss scheme")
+	}
+
+	s := &Storage{url: url, dialer: websocket.DefaultDialer}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s, nil
+}
+
+// Upload sends the profile as one framed binary message. If the connection
+// has dropped, it is redialed; a send failing on a stale connection is
+// retried once on a fresh one.
+func (s *Storage) Upload(ctx context.Context, filePath string) (pprofio.UploadResult, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return pprofio.UploadResult{}, fmt.Errorf("failed to read profile file: %w", err)
+	}
+
+	data, err = pprofio.CompressProfile(data)
+	if err != nil {
+		return pprofio.UploadResult{}, err
+	}
+
+	header := Header{
+		Type:      string(pprofio.UploadProfileType(ctx, filePath)),
+		Encoding:  "gzip",
+		Size:      len(data),
+		Timestamp: time.Now().Unix(),
+	}
+	header.Tags, _ = pprofio.TagsFromUploadContext(ctx)
+
+	message, err := frame(header, data)
+	if err != nil {
+		return pprofio.UploadResult{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		conn, err := s.connect(ctx)
+		if err != nil {
+			return pprofio.UploadResult{}, err
+		}
+
+		if err = s.send(ctx, conn, message); err == nil {
+			break
+		}
+		s.drop(conn)
+		if attempt > 0 {
+			return pprofio.UploadResult{}, fmt.Errorf("failed to send profile: %w", err)
+		}
+	}
+
+	return pprofio.UploadResult{ProfileURL: s.url, Type: header.Type, Size: int64(len(data))}, nil
+}
+
+// Close closes the connection, if open. A later Upload dials a new one.
+func (s *Storage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// connect returns the open connection, dialing one if needed. s.mu is held.
+func (s *Storage) connect(ctx context.Context) (*websocket.Conn, error) {
+	if s.conn != nil {
+		return s.conn, nil
+	}
+
+	conn, resp, err := s.dialer.DialContext(ctx, s.url, s.header)
+	if resp != nil && resp.Body != nil {
+		resp.Body.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	s.conn = conn
+	go s.readLoop(conn)
+	return conn, nil
+}
+
+// readLoop consumes incoming messages so control frames are handled, and
+// forgets the connection once the server closes it.
+func (s *Storage) readLoop(conn *websocket.Conn) {
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			s.mu.Lock()
+			s.drop(conn)
+			s.mu.Unlock()
+			return
+		}
+	}
+}
+
+// drop closes conn and forgets it if it is still current. s.mu is held.
+func (s *Storage) drop(conn *websocket.Conn) {
+	conn.Close()
+	if s.conn == conn {
+		s.conn = nil
+	}
+}
+
+func (s *Storage) send(ctx context.Context, conn *websocket.Conn, message []byte) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultWriteTimeout)
+	}
+	if err := conn.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+	return conn.WriteMessage(websocket.BinaryMessage, message)
+}
+
+// frame prefixes data with the big-endian length of the JSON header and the
+// header itself.
+func frame(header Header, data []byte) ([]byte, error) {
+	encoded, err := json.Marshal(header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal header: %w", err)
+	}
+
+	message := make([]byte, 4, 4+len(encoded)+len(data))
+	binary.BigEndian.PutUint32(message, uint32(len(encoded)))
+	message = append(message, encoded...)
+	return append(message, data...), nil
+}
@@ -0,0 +1,179 @@
+package wsstorage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeServer records framed messages and can drop connections after
+// receiving a number of messages
+type fakeServer struct {
+	mu          sync.Mutex
+	messages    [][]byte
+	connections int
+	dropAfter   int
+	auth        string
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	s.mu.Lock()
+	s.connections++
+	s.auth = r.Header.Get("Authorization")
+	s.mu.Unlock()
+
+	for received := 0; ; received++ {
+		if s.dropAfter > 0 && received == s.dropAfter {
+			return
+		}
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.messages = append(s.messages, message)
+		s.mu.Unlock()
+	}
+}
+
+func (s *fakeServer) snapshot() ([][]byte, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]byte(nil), s.messages...), s.connections
+}
+
+func writeProfile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	return path
+}
+
+func parseFrame(t *testing.T, message []byte) (Header, []byte) {
+	t.Helper()
+	if len(message) < 4 {
+		t.Fatalf("message too short: %d bytes", len(message))
+	}
+	n := binary.BigEndian.Uint32(message)
+	var header Header
+	if err := json.Unmarshal(message[4:4+n], &header); err != nil {
+		t.Fatalf("failed to decode header: %v", err)
+	}
+	return header, message[4+n:]
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestUploadSendsFramedProfile(t *testing.T) {
+	server := &fakeServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	storage, err := New("ws"+strings.TrimPrefix(ts.URL, "http"),
+		WithHeader(http.Header{"Authorization": []string{"Bearer test-key"}}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer storage.Close()
+
+	profile := []byte("cpu profile data")
+	result, err := storage.Upload(context.Background(), writeProfile(t, "cpu.pprof123", profile))
+	if err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	if result.Type != "cpu" || result.Size == 0 {
+		t.Errorf("Upload() = %+v", result)
+	}
+
+	waitFor(t, func() bool { messages, _ := server.snapshot(); return len(messages) == 1 })
+	messages, _ := server.snapshot()
+
+	header, body := parseFrame(t, messages[0])
+	if header.Type != "cpu" || header.Encoding != "gzip" || header.Size != len(body) {
+		t.Errorf("header = %+v, body %d bytes", header, len(body))
+	}
+
+	gr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("body is not gzip: %v", err)
+	}
+	decoded, _ := io.ReadAll(gr)
+	if !bytes.Equal(decoded, profile) {
+		t.Errorf("decoded body = %q, want %q", decoded, profile)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.auth != "Bearer test-key" {
+		t.Errorf("Authorization = %q, want handshake header", server.auth)
+	}
+}
+
+func TestUploadReconnectsAfterDrop(t *testing.T) {
+	server := &fakeServer{dropAfter: 1}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	storage, err := New("ws" + strings.TrimPrefix(ts.URL, "http"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer storage.Close()
+
+	if _, err := storage.Upload(context.Background(), writeProfile(t, "goroutine.pprof", []byte("first"))); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+
+	// The server closes the connection after one message
+	waitFor(t, func() bool {
+		storage.mu.Lock()
+		defer storage.mu.Unlock()
+		return storage.conn == nil
+	})
+
+	if _, err := storage.Upload(context.Background(), writeProfile(t, "goroutine.pprof", []byte("second"))); err != nil {
+		t.Fatalf("Upload() after drop error = %v", err)
+	}
+
+	waitFor(t, func() bool { messages, _ := server.snapshot(); return len(messages) == 2 })
+	if _, connections := server.snapshot(); connections != 2 {
+		t.Errorf("connections = %d, want 2", connections)
+	}
+}
+
+func TestNewRejectsHTTPURL(t *testing.T) {
+	if _, err := New("http://ingest.example.com/profiles"); err == nil {
+		t.Error("New() should reject a non-WebSocket URL")
+	}
+}